@@ -6,6 +6,7 @@ import (
 	"forza-garage/client"
 	"forza-garage/database"
 	"forza-garage/helpers"
+	"forza-garage/logging"
 	"forza-garage/lookups"
 	"forza-garage/models"
 	"math"
@@ -15,6 +16,7 @@ import (
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go"
+	"github.com/influxdata/influxdb-client-go/api"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -28,6 +30,7 @@ type Tracker struct {
 	GetUserName  func(ID string) (string, error)
 	// GetUserNameOID func(userID primitive.ObjectID) (string, error) // war für alte Lösung
 	Requests *client.Registry
+	Log      *logging.Logger
 }
 
 type Visit struct {
@@ -91,7 +94,7 @@ func (t *Tracker) SaveVisitor(domain string, profileID string, userID string) {
 		map[string]interface{}{"userId": userID},
 		time.Now())
 
-	// ToDo: log Error
+	// errors are logged asynchronously (see LogWriteErrors)
 	t.VisitorAPI.WriteAPI.WritePoint(p)
 
 }
@@ -144,7 +147,7 @@ func (t *Tracker) SaveSearchCourse(search *models.CourseSearchParams, results []
 			fields,
 			ts)
 
-		// ToDo: log Error
+		// errors are logged asynchronously (see LogWriteErrors)
 		t.SearchAPI.WriteAPI.WritePoint(p)
 	}
 
@@ -184,7 +187,7 @@ func (t *Tracker) SaveSearch(domain string, gameCode int32, seriesCodes []int32,
 		fields,
 		time.Now())
 
-	// ToDo: log Error
+	// errors are logged asynchronously (see LogWriteErrors)
 	t.SearchAPI.WriteAPI.WritePoint(p)
 
 }
@@ -425,9 +428,58 @@ func (t *Tracker) ListVisitors(profileID string, startDT time.Time, userID strin
 	*/
 }
 
+// ListUserVisits returns the profile visits of a user (data export)
+// only visits still present in the analytics store (TTL) are returned
+func (t *Tracker) ListUserVisits(userID string) ([]Visit, error) {
+
+	if os.Getenv("USE_ANALYTICS") != "YES" {
+		return nil, nil
+	}
+
+	flux := `from(bucket: "%s")
+		|> range(start: -30d)
+		|> filter(fn: (r) => r["_measurement"] == "visit" and r["_field"] == "userId" and r["_value"] == "%s")
+		|> sort(columns: ["_time"], desc: true)`
+
+	flux = fmt.Sprintf(
+		flux,
+		os.Getenv("ANALYTICS_VISITORS_BUCKET"),
+		userID)
+
+	result, err := t.VisitorAPI.QueryAPI.Query(context.Background(), flux)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var visit Visit
+	var visits []Visit
+	for result.Next() {
+		visit.VisitTS = result.Record().Time()
+		visit.ObjectID, _ = result.Record().ValueByKey("profileId").(string) // domain_id
+		visit.UserID = userID
+		visits = append(visits, visit)
+	}
+
+	return visits, nil
+}
+
+// LogWriteErrors logs the errors of the asynchronous writes (visits, searches)
+func (t *Tracker) LogWriteErrors() {
+	for bucket, writeAPI := range map[string]api.WriteAPI{"visitors": t.VisitorAPI.WriteAPI, "searches": t.SearchAPI.WriteAPI} {
+		if writeAPI == nil {
+			continue
+		}
+		go func(bucket string, errs <-chan error) {
+			for err := range errs {
+				t.Log.Err(err, logging.Fields{"bucket": bucket})
+			}
+		}(bucket, writeAPI.Errors())
+	}
+}
+
 // Replicate moves the visits from the cache (InfluxDB) into the database (Mongo)
 func (t *Tracker) Replicate() {
-	fmt.Println("Replicating...")
+	t.Log.Info("replicating visits")
 
 	// ausführen jede Stunde
 	// älter 30 tage
@@ -465,8 +517,7 @@ func (t *Tracker) Replicate() {
 
 	result, err := t.SearchAPI.QueryAPI.Query(ctx, flux)
 	if err != nil {
-		// ToDO: Log Error helpers.WrapError(err, helpers.FuncName())
-		fmt.Println(helpers.WrapError(err, helpers.FuncName()))
+		t.Log.Err(helpers.WrapError(err, helpers.FuncName()))
 		return
 	}
 
@@ -515,8 +566,7 @@ func (t *Tracker) Replicate() {
 		case "course", "championship":
 			opModels["racing"] = append(opModels["racing"], opModel)
 		default:
-			// ToDo: Log
-			fmt.Println("ERROR: repl not correctly implemented")
+			t.Log.Warn("visits of unknown profile type not replicated", logging.Fields{"profileId": result.Record().ValueByKey("profileId")})
 		}
 
 		/*
@@ -542,8 +592,7 @@ func (t *Tracker) Replicate() {
 
 	// abort if no data to process
 	if i == 0 {
-		// TODO: Log
-		fmt.Printf("%v: %v profile's visit(s) replicated.\n", time.Now().Format(time.RFC3339), 0)
+		t.Log.Info("visits replicated", logging.Fields{"profiles": 0})
 		return
 	}
 
@@ -556,15 +605,14 @@ func (t *Tracker) Replicate() {
 		if v != nil {
 			res, err := t.collections[k].BulkWrite(ctx, v, opts) // context noch unklar, background ist nicht cancellable
 			if err != nil {
-				// ToDO: Log Error helpers.WrapError(err, helpers.FuncName())
-				fmt.Println(helpers.WrapError(err, helpers.FuncName()))
+				t.Log.Err(helpers.WrapError(err, helpers.FuncName()), logging.Fields{"collection": k})
+				continue
 			}
-			cnt = res.MatchedCount
+			cnt += res.MatchedCount
 		}
 	}
 
-	// ToDo: could be logged
-	fmt.Printf("%v: %v profile's visit(s) replicated.\n", time.Now().Format(time.RFC3339), cnt)
+	t.Log.Info("visits replicated", logging.Fields{"profiles": cnt})
 
 	// 3. delete transfered data from influxDB
 	/*
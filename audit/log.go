@@ -0,0 +1,196 @@
+package audit
+
+// append-only log of security related and content-changing actions
+// entries are never updated or deleted by the API
+
+import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/authorization"
+	"forza-garage/helpers"
+	"forza-garage/logging"
+	"forza-garage/lookups"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// action types
+const (
+	ActionLogin          = "login"
+	ActionLoginFailed    = "loginFailed"
+	ActionPasswordChange = "passwordChange"
+	ActionPasswordReset  = "passwordReset"
+	ActionRoleChange     = "roleChange"
+	ActionSuspend        = "suspend"
+	ActionUnsuspend      = "unsuspend"
+	ActionAccountDelete  = "accountDelete"
+	ActionAccountRestore = "accountRestore"
+	ActionAccountPurge   = "accountPurge"
+	ActionBlock          = "block"
+	ActionUnblock        = "unblock"
+	ActionCourseCreate   = "courseCreate"
+	ActionCourseUpdate   = "courseUpdate"
+	ActionUploadDelete   = "uploadDelete"
+	ActionModerate       = "moderate"
+)
+
+// context keys used to pass client information of a request
+type contextKey string
+
+const (
+	ipKey        contextKey = "ip"
+	userAgentKey contextKey = "userAgent"
+)
+
+// Entry is a single record of the audit log
+type Entry struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	TS        time.Time          `json:"ts" bson:"ts"`
+	ActorID   string             `json:"actorID,omitempty" bson:"actorID,omitempty"` // empty for anonymous or system actions
+	ActorName string             `json:"actorName,omitempty" bson:"actorName,omitempty"`
+	Action    string             `json:"action" bson:"action"`
+	Target    string             `json:"target" bson:"target"` // domain_id, eg. user_5feb2473b4d37f7f0285847a
+	Details   interface{}        `json:"details,omitempty" bson:"details,omitempty"`
+	IP        string             `json:"ip,omitempty" bson:"ip,omitempty"`
+	UserAgent string             `json:"userAgent,omitempty" bson:"userAgent,omitempty"`
+}
+
+// SearchParams filters the audit log (all fields are optional)
+type SearchParams struct {
+	ActorID  string
+	Action   string
+	Target   string
+	StartDT  *time.Time
+	EndDT    *time.Time
+	Page     int // starts with 0
+	PageSize int
+}
+
+// Log provides the audit API
+type Log struct {
+	collection     *mongo.Collection
+	GetUserName    func(ID string) (string, error)
+	GetCredentials func(userOID primitive.ObjectID, loadFriendlist bool) *authorization.Credentials
+	Logger         *logging.Logger
+}
+
+// SetConnections is called in Env Model Initializiation
+func (l *Log) SetConnections(mongoCollections map[string]*mongo.Collection) {
+	l.collection = mongoCollections["audit"]
+}
+
+// Target builds the target's key of a domain object
+func Target(domain string, ID string) string {
+	return domain + "_" + ID
+}
+
+// WithClient adds the client's information of a request to a context,
+// which is passed to Audit
+func WithClient(ctx context.Context, ip string, userAgent string) context.Context {
+	ctx = context.WithValue(ctx, ipKey, ip)
+	return context.WithValue(ctx, userAgentKey, userAgent)
+}
+
+// Audit records an action. The context is used to pass the client's information (see WithClient),
+// not to cancel the write, so entries are saved even if the request has already finished.
+// no error is returned since auditing must not abort the (already performed) action
+func (l *Log) Audit(ctx context.Context, actor string, action string, target string, details interface{}) {
+
+	entry := Entry{
+		ID:      primitive.NewObjectID(),
+		TS:      time.Now(),
+		ActorID: actor,
+		Action:  action,
+		Target:  target,
+		Details: details,
+	}
+
+	if actor != "" {
+		entry.ActorName, _ = l.GetUserName(actor)
+	}
+	if ctx != nil {
+		entry.IP, _ = ctx.Value(ipKey).(string)
+		entry.UserAgent, _ = ctx.Value(userAgentKey).(string)
+	}
+
+	dbCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	_, err := l.collection.InsertOne(dbCtx, entry)
+	if err != nil {
+		// the entry is kept in the application's log at least
+		l.Logger.Err(helpers.WrapError(err, helpers.FuncName()), logging.Fields{"actor": actor, "action": action, "target": target})
+	}
+}
+
+// Search lists audit entries, most recent first (admins only)
+func (l *Log) Search(searchSpecs *SearchParams, executiveUserID string) ([]Entry, error) {
+
+	cred := l.GetCredentials(helpers.ObjectID(executiveUserID), false)
+	if cred == nil || cred.RoleCode != lookups.UserRoleAdmin {
+		return nil, apperror.ErrDenied
+	}
+
+	filter := bson.D{}
+	if searchSpecs.ActorID != "" {
+		filter = append(filter, bson.E{Key: "actorID", Value: searchSpecs.ActorID})
+	}
+	if searchSpecs.Action != "" {
+		filter = append(filter, bson.E{Key: "action", Value: searchSpecs.Action})
+	}
+	if searchSpecs.Target != "" {
+		filter = append(filter, bson.E{Key: "target", Value: searchSpecs.Target})
+	}
+	if searchSpecs.StartDT != nil || searchSpecs.EndDT != nil {
+		period := bson.D{}
+		if searchSpecs.StartDT != nil {
+			period = append(period, bson.E{Key: "$gte", Value: *searchSpecs.StartDT})
+		}
+		if searchSpecs.EndDT != nil {
+			period = append(period, bson.E{Key: "$lt", Value: *searchSpecs.EndDT})
+		}
+		filter = append(filter, bson.E{Key: "ts", Value: period})
+	}
+
+	if searchSpecs.PageSize <= 0 || searchSpecs.PageSize > 100 {
+		searchSpecs.PageSize = 50
+	}
+	if searchSpecs.Page < 0 {
+		searchSpecs.Page = 0
+	}
+
+	sort := bson.D{
+		{Key: "ts", Value: -1},
+	}
+
+	opts := options.Find().
+		SetSort(sort).
+		SetSkip(int64(searchSpecs.Page * searchSpecs.PageSize)).
+		SetLimit(int64(searchSpecs.PageSize))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := l.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var entries []Entry
+
+	err = cursor.All(ctx, &entries)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// check for empty result set (no error raised by find)
+	if entries == nil {
+		return nil, apperror.ErrNoData
+	}
+
+	return entries, nil
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"forza-garage/helpers"
+	"forza-garage/logging"
 	"net/http"
 	"os"
 	"time"
@@ -192,6 +193,50 @@ func DeleteAuths(tokenType string, userID string, currentUUID string) (int64, er
 	return delCnt, delErr
 }
 
+// RevokeAuths deletes all tokens (AT & RT) of a user from the registry, regardless of how many
+// are in use. It's used whenever a user must be logged-out on all devices (eg. suspension by an admin)
+// returns the count of deleted tokens
+func RevokeAuths(userID string) (int64, error) {
+
+	var ctx = context.Background()
+	var delCnt int64 = 0
+
+	for _, tt := range []string{"at", "rt"} {
+		var cursor uint64
+		var keys []string
+		var err error
+
+		// keys must be scanned, since redis can't search values (see DeleteAuths)
+		for {
+			keys, cursor, err = client.Scan(ctx, cursor, tt+"_*", 10).Result()
+			if err != nil {
+				return delCnt, err
+			}
+
+			for _, k := range keys {
+				val, err := client.Get(ctx, k).Result()
+				if err != nil {
+					// key might have expired in the meantime
+					continue
+				}
+				if val == userID {
+					deleted, err := client.Del(ctx, k).Result()
+					if err != nil {
+						return delCnt, err
+					}
+					delCnt += deleted
+				}
+			}
+
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+
+	return delCnt, nil
+}
+
 // CreateToken erzeugt ein Token-Paar (AT & RT)
 func CreateToken(userID string) (*TokenDetails, error) {
 
@@ -393,6 +438,12 @@ func TokenAuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+
+		// the token is valid, hence its user is logged with the request
+		if details, err := ExtractTokenMetadata(AT, c.Request); err == nil {
+			logging.AddFields(c, logging.Fields{"userID": details.UserID})
+		}
+
 		c.Next()
 	}
 }
@@ -0,0 +1,60 @@
+package client
+
+// rate limiter for user actions (eg. votes)
+// a sliding window of the latest actions is kept per key (user, user & profile...)
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter mediates access to the actions-map using mutex (see registry)
+type Limiter struct {
+	sync.Mutex
+	actions map[string][]time.Time
+}
+
+// NewLimiter returns an initialized limiter
+func NewLimiter() *Limiter {
+	return &Limiter{actions: make(map[string][]time.Time)}
+}
+
+// Allow registers an action, if less than limit actions were registered within the window
+func (l *Limiter) Allow(key string, limit int, window time.Duration) bool {
+
+	now := time.Now()
+
+	l.Lock()
+	defer l.Unlock()
+
+	// keep the actions within the window only
+	recent := l.actions[key][:0]
+	for _, ts := range l.actions[key] {
+		if now.Sub(ts) < window {
+			recent = append(recent, ts)
+		}
+	}
+
+	if len(recent) >= limit {
+		l.actions[key] = recent
+		return false
+	}
+
+	l.actions[key] = append(recent, now)
+	return true
+}
+
+// Flush removes keys without actions since maxAge
+// usually called by a GO-routine that runs in a ticker
+func (l *Limiter) Flush(maxAge time.Duration) {
+
+	now := time.Now()
+
+	l.Lock()
+	for key, actions := range l.actions {
+		if len(actions) == 0 || now.Sub(actions[len(actions)-1]) > maxAge {
+			delete(l.actions, key)
+		}
+	}
+	l.Unlock()
+}
@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"forza-garage/audit"
+	"forza-garage/authentication"
+	"forza-garage/environment"
+	"forza-garage/helpers"
+	"forza-garage/logging"
+	"forza-garage/models"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ExportUserData sends all data related to the current user as a ZIP archive (GDPR)
+// the archive contains JSON documents per domain and the uploaded files
+func ExportUserData(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	userOID := helpers.ObjectID(userID)
+
+	// collect everything before the response is started, so errors can still be reported
+	user, err := environment.Env.UserModel.GetUserByID(userID, userID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+	user.Password = ""
+
+	references, err := environment.Env.UserModel.ExportReferences(userOID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	courses, err := environment.Env.CourseModel.ListUserCourses(userOID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	comments, replies, err := environment.Env.CommentModel.ListUserComments(userOID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	votes, err := environment.Env.VoteModel.ListUserVotes(userOID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	uploads, err := environment.Env.UploadModel.ListUserUploads(userOID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	visits, err := environment.Env.Tracker.ListUserVisits(userID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	// documents of the archive
+	documents := map[string]interface{}{
+		"profile.json":  user,
+		"social.json":   references,
+		"courses.json":  courses,
+		"comments.json": comments,
+		"replies.json":  replies,
+		"votes.json":    votes,
+		"uploads.json":  uploads,
+		"visits.json":   visits,
+	}
+
+	// the login name is quoted/encoded by the mime package (names may contain any character)
+	disposition := mime.FormatMediaType("attachment", map[string]string{
+		"filename": "forza-garage_" + user.LoginName + "_" + time.Now().Format("2006-01-02") + ".zip",
+	})
+	if disposition == "" {
+		disposition = "attachment; filename=\"forza-garage_" + time.Now().Format("2006-01-02") + ".zip\""
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", disposition)
+	c.Status(http.StatusOK)
+
+	// the archive is streamed, hence errors can't be sent to the client anymore
+	zw := zip.NewWriter(c.Writer)
+	defer zw.Close()
+
+	for name, data := range documents {
+		err = writeZipJSON(zw, name, data)
+		if err != nil {
+			logging.FromContext(c).Err(err, logging.Fields{"document": name})
+			return
+		}
+	}
+
+	for _, u := range uploads {
+		err = writeZipFile(zw, "files/"+u.File.SysFileName, u)
+		if err != nil {
+			// missing files are skipped, metadata is included anyway
+			logging.FromContext(c).Warn("file skipped by export", logging.Fields{"fileName": u.File.SysFileName, "error": err})
+		}
+	}
+}
+
+// DeleteAccount schedules the current user's account to be purged after a grace period
+// the user is logged out on all devices but may log-in again to restore the account
+func DeleteAccount(c *gin.Context) {
+
+	var apiError ErrorResponse
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// password is required again
+	data := struct {
+		Password string `json:"password" binding:"required"`
+	}{}
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apiError.Code = InvalidJSON
+		apiError.Message = apiError.String(apiError.Code)
+		c.JSON(http.StatusUnprocessableEntity, apiError)
+		return
+	}
+
+	deletionTS, err := environment.Env.UserModel.ScheduleDeletion(userID, strings.TrimSpace(data.Password))
+	if err != nil {
+		if err == models.ErrInvalidUser {
+			apiError.Code = InvalidLogin
+			apiError.Message = apiError.String(apiError.Code)
+			c.JSON(http.StatusUnauthorized, apiError)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	environment.Env.Audit.Audit(auditContext(c), userID, audit.ActionAccountDelete, audit.Target(models.ContentTypeUser, userID),
+		bson.M{"deletionTS": *deletionTS})
+
+	_, _ = authentication.RevokeAuths(userID)
+	_ = helpers.DelCookie(c, os.Getenv("JWTCK_NAME"))
+
+	// wrap response into an object
+	res := struct {
+		DeletionTS time.Time `json:"deletionTS"`
+	}{*deletionTS}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// RestoreAccount cancels the deletion of the current user's account during the grace period
+func RestoreAccount(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	err = environment.Env.UserModel.RestoreAccount(userID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	environment.Env.Audit.Audit(auditContext(c), userID, audit.ActionAccountRestore, audit.Target(models.ContentTypeUser, userID), nil)
+
+	c.Status(http.StatusNoContent)
+}
+
+// internal helpers
+
+func writeZipJSON(zw *zip.Writer, name string, data interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(data)
+}
+
+func writeZipFile(zw *zip.Writer, name string, upload models.UserUpload) error {
+	f, err := environment.Env.UploadModel.OpenFile(upload)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, f)
+	return err
+}
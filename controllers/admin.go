@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"forza-garage/apperror"
+	"forza-garage/audit"
+	"forza-garage/authentication"
+	"forza-garage/environment"
+	"forza-garage/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// user management for admins (permissions are checked by the model)
+
+// SearchUsers lists users by name, e-mail or xbox tag
+// http://localhost:3000/admin/users?search=rog&role=1&page=0&pageSize=20
+func SearchUsers(c *gin.Context) {
+
+	var apiError ErrorResponse
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	search := new(models.UserSearchParams)
+	search.SearchTerm = strings.TrimSpace(c.Query("search"))
+
+	if c.Query("role") != "" {
+		i, err := strconv.Atoi(c.Query("role"))
+		if err != nil {
+			apiError.Code = InvalidJSON
+			apiError.Message = apiError.String(apiError.Code)
+			c.JSON(http.StatusUnprocessableEntity, apiError)
+			return
+		}
+		role := int32(i)
+		search.RoleCode = &role
+	}
+
+	// paging is optional (defaults set by model)
+	search.Page, _ = strconv.Atoi(c.Query("page"))
+	search.PageSize, _ = strconv.Atoi(c.Query("pageSize"))
+
+	users, err := environment.Env.UserModel.SearchUsers(search, userID)
+	if err != nil {
+		// nothing found (not an error to the client)
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// SetUserRole changes the role of a user
+func SetUserRole(c *gin.Context) {
+
+	var apiError ErrorResponse
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// pointer, since 0 (guest) is a valid value
+	data := struct {
+		RoleCode *int32 `json:"roleCode" binding:"required"`
+	}{}
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apiError.Code = InvalidJSON
+		apiError.Message = apiError.String(apiError.Code)
+		c.JSON(http.StatusUnprocessableEntity, apiError)
+		return
+	}
+
+	err = environment.Env.UserModel.SetRole(c.Param("id"), *data.RoleCode, userID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	environment.Env.Audit.Audit(auditContext(c), userID, audit.ActionRoleChange, audit.Target(models.ContentTypeUser, c.Param("id")),
+		bson.M{"roleCode": *data.RoleCode})
+
+	c.Status(http.StatusNoContent)
+}
+
+// SuspendUser locks an account for a given number of days and logs the user out on all devices
+func SuspendUser(c *gin.Context) {
+
+	var apiError ErrorResponse
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	data := struct {
+		Days   int    `json:"days" binding:"required"`
+		Reason string `json:"reason"`
+	}{}
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apiError.Code = InvalidJSON
+		apiError.Message = apiError.String(apiError.Code)
+		c.JSON(http.StatusUnprocessableEntity, apiError)
+		return
+	}
+
+	until := time.Now().AddDate(0, 0, data.Days)
+
+	err = environment.Env.UserModel.SuspendUser(c.Param("id"), until, userID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	environment.Env.Audit.Audit(auditContext(c), userID, audit.ActionSuspend, audit.Target(models.ContentTypeUser, c.Param("id")),
+		bson.M{"until": until, "reason": strings.TrimSpace(data.Reason)})
+
+	// existing sessions are revoked, so the suspension takes effect immediately
+	// (refresh is rejected as well)
+	_, err = authentication.RevokeAuths(c.Param("id"))
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// UnsuspendUser lifts a user's suspension
+func UnsuspendUser(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	err = environment.Env.UserModel.UnsuspendUser(c.Param("id"), userID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	environment.Env.Audit.Audit(auditContext(c), userID, audit.ActionUnsuspend, audit.Target(models.ContentTypeUser, c.Param("id")), nil)
+
+	c.Status(http.StatusNoContent)
+}
+
+// ResetUserPassword sets a temporary password, which is sent to the admin only
+// the user is logged out on all devices and asked to change the password after the next log-in
+func ResetUserPassword(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	tempPassword, err := environment.Env.UserModel.ResetPassword(c.Param("id"), userID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	environment.Env.Audit.Audit(auditContext(c), userID, audit.ActionPasswordReset, audit.Target(models.ContentTypeUser, c.Param("id")), nil)
+
+	// any error is ignored, since the old password is not valid anymore
+	_, _ = authentication.RevokeAuths(c.Param("id"))
+
+	// wrap response into an object
+	res := struct {
+		Password string `json:"password"`
+	}{tempPassword}
+
+	c.JSON(http.StatusOK, res)
+}
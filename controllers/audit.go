@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/audit"
+	"forza-garage/authentication"
+	"forza-garage/environment"
+	"forza-garage/helpers"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditContext passes the client's information of a request to the audit log
+func auditContext(c *gin.Context) context.Context {
+	return audit.WithClient(c.Request.Context(), helpers.GetIP(c.Request), c.Request.UserAgent())
+}
+
+// ListAuditLog returns audit entries (admins only)
+// http://localhost:3000/admin/audit?actor=5feb2473b4d37f7f0285847a&target=user_5feb2473b4d37f7f0285847a&from=2021-01-01T00:00:00Z&to=2021-02-01T00:00:00Z&page=0
+func ListAuditLog(c *gin.Context) {
+
+	var apiError ErrorResponse
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	search := new(audit.SearchParams)
+	search.ActorID = strings.TrimSpace(c.Query("actor"))
+	search.Action = strings.TrimSpace(c.Query("action"))
+	search.Target = strings.TrimSpace(c.Query("target"))
+
+	// time range (RFC3339)
+	if c.Query("from") != "" {
+		ts, err := time.Parse(time.RFC3339, c.Query("from"))
+		if err != nil {
+			apiError.Code = InvalidRequest
+			apiError.Message = apiError.String(apiError.Code)
+			c.JSON(http.StatusUnprocessableEntity, apiError)
+			return
+		}
+		search.StartDT = &ts
+	}
+	if c.Query("to") != "" {
+		ts, err := time.Parse(time.RFC3339, c.Query("to"))
+		if err != nil {
+			apiError.Code = InvalidRequest
+			apiError.Message = apiError.String(apiError.Code)
+			c.JSON(http.StatusUnprocessableEntity, apiError)
+			return
+		}
+		search.EndDT = &ts
+	}
+
+	// paging is optional (defaults set by the log)
+	search.Page, _ = strconv.Atoi(c.Query("page"))
+	search.PageSize, _ = strconv.Atoi(c.Query("pageSize"))
+
+	entries, err := environment.Env.Audit.Search(search, userID)
+	if err != nil {
+		// nothing found (not an error to the client)
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
@@ -1,7 +1,7 @@
 package controllers
 
 import (
-	"fmt"
+	"forza-garage/audit"
 	"forza-garage/authentication"
 	"forza-garage/environment"
 	"forza-garage/helpers"
@@ -125,9 +125,8 @@ func Register(c *gin.Context) {
 	// this also validates the user name, pwd etc.
 	ID, err := environment.Env.UserModel.CreateUser(data)
 	if err != nil {
-		fmt.Println(err)
 		// ToDo: maybe check for an existing XBox-Tag and ask do u really want ... :-)
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -175,7 +174,7 @@ func Login(c *gin.Context) {
 			return
 		}
 		// "real" error
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -183,6 +182,7 @@ func Login(c *gin.Context) {
 	// übergibt das unverschlüsselte PWD vom Login und das verschlüsselte aus der DB
 	granted := environment.Env.UserModel.CheckPassword(givenUser.Password, *dbUser)
 	if !granted {
+		environment.Env.Audit.Audit(auditContext(c), "", audit.ActionLoginFailed, audit.Target(models.ContentTypeUser, dbUser.ID.Hex()), nil)
 		// send custom error message
 		apiError.Code = InvalidLogin
 		apiError.Message = apiError.String(apiError.Code)
@@ -190,15 +190,24 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	// suspended accounts are rejected after the password check, so the state of an account
+	// is not revealed to anyone guessing
+	if dbUser.Suspended() {
+		status, apiError := HandleError(c, models.ErrUserSuspended)
+		c.JSON(status, apiError)
+		return
+	}
+
 	// create, register & save pair of AT/RT
 	err = authentication.CreateTokens(c, dbUser.ID.Hex())
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
 
 	environment.Env.UserModel.SetLastSeen(dbUser.ID)
+	environment.Env.Audit.Audit(auditContext(c), dbUser.ID.Hex(), audit.ActionLogin, audit.Target(models.ContentTypeUser, dbUser.ID.Hex()), nil)
 
 	// passwort nicht erneut zurücksenden
 	dbUser.Password = ""
@@ -244,7 +253,7 @@ func Refresh(c *gin.Context) {
 
 	au, err := authentication.ExtractTokenMetadata(authentication.RT, c.Request)
 	if err != nil {
-		_, apiError = HandleError(err)
+		_, apiError = HandleError(c, err)
 		c.JSON(http.StatusUnauthorized, apiError)
 		return
 	}
@@ -252,7 +261,7 @@ func Refresh(c *gin.Context) {
 	// ist das RT noch gültig? (macht beim AT die Middleware)
 	err = authentication.TokenValid(authentication.RT, c.Request)
 	if err != nil {
-		_, apiError = HandleError(err)
+		_, apiError = HandleError(c, err)
 		c.JSON(http.StatusUnauthorized, apiError)
 		return
 	}
@@ -260,7 +269,7 @@ func Refresh(c *gin.Context) {
 	// userID für die Ausstellung eines neues Token Pair
 	userID, err := authentication.FetchAuth(au)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -271,7 +280,7 @@ func Refresh(c *gin.Context) {
 	if err != nil {
 		// user does not exist - erneute Prüfung eigentlich kaum nötig, kann aber noch mehr Sicherheit geben :-)
 		if err == models.ErrInvalidUser {
-			status, apiError := HandleError(err)
+			status, apiError := HandleError(c, err)
 			c.JSON(status, apiError)
 			return
 		}
@@ -280,6 +289,14 @@ func Refresh(c *gin.Context) {
 		return
 	}
 
+	// sessions of suspended users are revoked, but a refresh token might have been issued concurrently
+	if dbUser.Suspended() {
+		_, _ = authentication.RevokeAuths(userID)
+		status, apiError := HandleError(c, models.ErrUserSuspended)
+		c.JSON(status, apiError)
+		return
+	}
+
 	// falls zu viele RTs (Clients) für den User in Umlauf sind, alle löschen, sonst nur das aktuelle
 	// die ATs werden stehen gelassen; diese Clients können also noch damit arbeiten
 	// ein neuer Refresh wird dann aber nicht mehr gehen
@@ -294,7 +311,7 @@ func Refresh(c *gin.Context) {
 	// create, register & save pair of AT/RT
 	err = authentication.CreateTokens(c, userID)
 	if err != nil {
-		_, apiError = HandleError(err)
+		_, apiError = HandleError(c, err)
 		c.JSON(http.StatusUnauthorized, apiError)
 		return
 	}
@@ -304,11 +321,6 @@ func Refresh(c *gin.Context) {
 	// passwort nicht erneut zurücksenden
 	dbUser.Password = ""
 
-	// add patch to build URL of profile picture
-	if dbUser.ProfilePicture != nil {
-		dbUser.ProfilePicture.URL = os.Getenv("API_HOME") + ":" + os.Getenv("API_PORT") + environment.UploadEndpoint + "/" + dbUser.ProfilePicture.URL
-	}
-
 	c.JSON(http.StatusOK, &dbUser)
 }
 
@@ -361,7 +373,7 @@ func VerifyPassword(c *gin.Context) {
 			return
 		}
 		// technical error
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -428,7 +440,7 @@ func ChangePassword(c *gin.Context) {
 			return
 		}
 		// "real" error
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -453,8 +465,10 @@ func ChangePassword(c *gin.Context) {
 	// ToDo: Validate new PWD (or include that in SetPWD)
 	err = environment.Env.UserModel.SetPassword(dbUser.ID, data.NewPassword)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
+
+	environment.Env.Audit.Audit(auditContext(c), userID, audit.ActionPasswordChange, audit.Target(models.ContentTypeUser, userID), nil)
 }
@@ -7,6 +7,7 @@ import (
 	"forza-garage/helpers"
 	"forza-garage/models"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -38,7 +39,7 @@ func AddComment(c *gin.Context) {
 	// validate request
 	comment, err := environment.Env.CommentModel.Validate(data)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -50,7 +51,12 @@ func AddComment(c *gin.Context) {
 
 	id, err := environment.Env.CommentModel.Create(comment)
 	if err != nil {
-		status, apiError := HandleError(err)
+		// profile or parent comment not found
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -58,11 +64,52 @@ func AddComment(c *gin.Context) {
 	c.JSON(http.StatusCreated, Created{id})
 }
 
-// ListCommentsPubic returns all comments and their answers (limited)
-// (generic handlers for all profile types)
+// ListCommentsPublic returns all comments and their answers of a course (limited)
 func ListCommentsPublic(c *gin.Context) {
+	listComments(c, models.ContentTypeCourse, c.Param("id"), "")
+}
 
-	comments, err := environment.Env.CommentModel.ListComments(c.Param("id"), "")
+// ListCommentsMember returns all comments and their answers of a course (limited)
+// This is the version that includes a user's votes if present
+func ListCommentsMember(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	listComments(c, models.ContentTypeCourse, c.Param("id"), userID)
+}
+
+// ListProfileComments returns the comments of a user's profile (members only)
+func ListProfileComments(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	listComments(c, models.ContentTypeUser, c.Param("id"), userID)
+}
+
+// ListUploadComments returns the comments of an uploaded file (screenshots)
+// members receive their votes; anonymous visitors are served as well (no middleware)
+func ListUploadComments(c *gin.Context) {
+
+	// any error is considered an anonymous visitor
+	userID, _ := authentication.Authenticate(c.Request)
+
+	listComments(c, models.ContentTypeUpload, c.Param("fid"), userID)
+}
+
+// generic handler for all profile types
+// http://localhost:3000/courses/public/608e63ced04782d5c49c1eb8/comments?sort=top
+func listComments(c *gin.Context, profileType string, profileKey string, userID string) {
+
+	// optional, most recent first by default
+	comments, err := environment.Env.CommentModel.ListComments(profileType, profileKey, c.Query("sort"), userID)
 	if err != nil {
 		// nothing found (not an error to the client)
 		if err == apperror.ErrNoData {
@@ -70,7 +117,7 @@ func ListCommentsPublic(c *gin.Context) {
 			return
 		}
 		// technical errors
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -78,9 +125,14 @@ func ListCommentsPublic(c *gin.Context) {
 	c.JSON(http.StatusOK, comments)
 }
 
-// ListCommentsMember returns all comments and their answers (limited)
-// This is the version that includes a user's votes if present
-func ListCommentsMember(c *gin.Context) {
+// UpdateComment changes the text of a comment or reply (author or admin)
+func UpdateComment(c *gin.Context) {
+
+	var (
+		err      error
+		data     models.Comment
+		apiError ErrorResponse
+	)
 
 	userID, err := authentication.Authenticate(c.Request)
 	if err != nil {
@@ -88,7 +140,102 @@ func ListCommentsMember(c *gin.Context) {
 		return
 	}
 
-	comments, err := environment.Env.CommentModel.ListComments(c.Param("id"), userID)
+	// only the text is used
+	if err = c.ShouldBindJSON(&data); err != nil {
+		apiError.Code = InvalidJSON
+		apiError.Message = apiError.String(apiError.Code)
+		c.JSON(http.StatusUnprocessableEntity, apiError)
+		return
+	}
+
+	comment, err := environment.Env.CommentModel.Validate(data)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	err = environment.Env.CommentModel.Update(c.Param("id"), comment.Comment, userID)
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteComment removes a comment or reply (author or admin)
+func DeleteComment(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	err = environment.Env.CommentModel.Delete(c.Param("id"), userID)
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// PinComment keeps a comment on top of the profile's comment section (profile owner)
+func PinComment(c *gin.Context) {
+	setPinned(c, true)
+}
+
+// UnpinComment removes the pin of a comment (profile owner)
+func UnpinComment(c *gin.Context) {
+	setPinned(c, false)
+}
+
+func setPinned(c *gin.Context, pinned bool) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	err = environment.Env.CommentModel.SetPinned(c.Param("id"), pinned, userID)
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListReplies returns a page of a comment's replies
+// members receive their votes; anonymous visitors are served as well (no middleware)
+// http://localhost:3000/comments/608e63ced04782d5c49c1eb8/replies?cursor=608e63ced04782d5c49c1eb9&pageSize=10
+func ListReplies(c *gin.Context) {
+
+	// any error is considered an anonymous visitor
+	userID, _ := authentication.Authenticate(c.Request)
+
+	// optional, defaults set by model
+	pageSize, _ := strconv.Atoi(c.Query("pageSize"))
+
+	replies, err := environment.Env.CommentModel.ListReplies(c.Param("id"), c.Query("cursor"), pageSize, userID)
 	if err != nil {
 		// nothing found (not an error to the client)
 		if err == apperror.ErrNoData {
@@ -96,10 +243,44 @@ func ListCommentsMember(c *gin.Context) {
 			return
 		}
 		// technical errors
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
 
-	c.JSON(http.StatusOK, comments)
+	c.JSON(http.StatusOK, replies)
+}
+
+// DisableComments switches off the comment section of a profile (owner or admin)
+// http://localhost:3000/profiles/course/608e63ced04782d5c49c1eb8/comments/disabled
+func DisableComments(c *gin.Context) {
+	setCommentsDisabled(c, true)
+}
+
+// EnableComments switches the comment section of a profile on again
+func EnableComments(c *gin.Context) {
+	setCommentsDisabled(c, false)
+}
+
+func setCommentsDisabled(c *gin.Context, disabled bool) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// the ID of uploads is their file name
+	err = environment.Env.CommentModel.SetCommentsDisabled(c.Param("type"), c.Param("id"), disabled, userID)
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
 }
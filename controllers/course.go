@@ -2,13 +2,16 @@ package controllers
 
 import (
 	"forza-garage/apperror"
+	"forza-garage/audit"
 	"forza-garage/authentication"
 	"forza-garage/environment"
+	"forza-garage/helpers"
 	"forza-garage/models"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 // AddCourse creates a new route
@@ -37,7 +40,7 @@ func AddCourse(c *gin.Context) {
 	// validate request
 	course, err := environment.Env.CourseModel.Validate(data)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -45,11 +48,13 @@ func AddCourse(c *gin.Context) {
 	// userID als Parameter, damit hier nicht DB-Spezifisches gebraucht wird (Mongo-OID)
 	id, err := environment.Env.CourseModel.CreateCourse(course, userID)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
 
+	environment.Env.Audit.Audit(auditContext(c), userID, audit.ActionCourseCreate, audit.Target(models.ContentTypeCourse, id), nil)
+
 	c.JSON(http.StatusCreated, Created{id})
 }
 
@@ -132,7 +137,7 @@ func ListCoursesPublic(c *gin.Context) {
 			return
 		}
 		// technical errors
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -233,7 +238,7 @@ func ListCoursesMember(c *gin.Context) {
 			return
 		}
 		// technical errors
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -281,7 +286,7 @@ func GetCoursePublic(c *gin.Context) {
 		case apperror.ErrNoData:
 			c.Status(http.StatusNoContent)
 		default:
-			status, apiError := HandleError(err)
+			status, apiError := HandleError(c, err)
 			c.JSON(status, apiError)
 		}
 		return
@@ -290,8 +295,8 @@ func GetCoursePublic(c *gin.Context) {
 	c.JSON(http.StatusOK, data)
 
 	// log this request, if it was a new one
-	if environment.Env.Requests.Continue(getIP(c.Request), id) {
-		environment.Env.Tracker.SaveVisitor("course", id, userID)
+	if environment.Env.Requests.Continue(helpers.GetIP(c.Request), id) {
+		environment.Env.Tracker.SaveVisitor(models.ContentTypeCourse, id, userID)
 	}
 }
 
@@ -334,7 +339,7 @@ func GetCourseMember(c *gin.Context) {
 		case apperror.ErrNoData:
 			c.Status(http.StatusNoContent)
 		default:
-			status, apiError := HandleError(err)
+			status, apiError := HandleError(c, err)
 			c.JSON(status, apiError)
 		}
 		return
@@ -343,8 +348,8 @@ func GetCourseMember(c *gin.Context) {
 	c.JSON(http.StatusOK, data)
 
 	// log this request, if it was a new one
-	if environment.Env.Requests.Continue(getIP(c.Request), id) {
-		environment.Env.Tracker.SaveVisitor("course", id, userID)
+	if environment.Env.Requests.Continue(helpers.GetIP(c.Request), id) {
+		environment.Env.Tracker.SaveVisitor(models.ContentTypeCourse, id, userID)
 	}
 }
 
@@ -380,7 +385,7 @@ func UpdateCourse(c *gin.Context) {
 	// validate request (inhaltlich)
 	course, err := environment.Env.CourseModel.Validate(data)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -403,11 +408,14 @@ func UpdateCourse(c *gin.Context) {
 
 	err = environment.Env.CourseModel.UpdateCourse(course, userID)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
 
+	environment.Env.Audit.Audit(auditContext(c), userID, audit.ActionCourseUpdate, audit.Target(models.ContentTypeCourse, course.ID.Hex()),
+		bson.M{"name": course.Name, "recVer": course.MetaInfo.RecVer})
+
 	c.Status(http.StatusNoContent) // evtl. auch 205
 }
 
@@ -443,7 +451,7 @@ func ExistsForzaShare(c *gin.Context) {
 
 	exists, err := environment.Env.CourseModel.ForzaSharingExists(data.ForzaSharing)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -2,10 +2,12 @@ package controllers
 
 import (
 	"errors"
-	"fmt"
 	"forza-garage/apperror"
+	"forza-garage/logging"
 	"forza-garage/models"
 	"net/http"
+
+	"github.com/gin-gonic/gin"
 )
 
 // generic custom error types
@@ -15,12 +17,14 @@ var (
 
 // ErrorResponse is the standardized error structure which may be returned by any API
 type ErrorResponse struct {
-	Code    int32  `json:"code"`
-	Message string `json:"msg"`
+	Code      int32  `json:"code"`
+	Message   string `json:"msg"`
+	RequestID string `json:"requestId,omitempty"` // system errors only, quoted by the users in bug reports
 }
 
 // HandleError encodes the std ErrorResponse
-func HandleError(err error) (httpStatus int, apiError ErrorResponse) {
+// the error is logged with the request's fields
+func HandleError(c *gin.Context, err error) (httpStatus int, apiError ErrorResponse) {
 
 	// Status grundsätzlich 422 (Unprocessable Entity)
 	// diese werden vom Client als App-Error behandelt
@@ -33,7 +37,6 @@ func HandleError(err error) (httpStatus int, apiError ErrorResponse) {
 		return 0, apiError
 	}
 
-	fmt.Println(err)
 	switch err {
 	// system
 	case apperror.ErrMultipleRecords:
@@ -78,6 +81,14 @@ func HandleError(err error) (httpStatus int, apiError ErrorResponse) {
 		apiError.Code = InvalidPassword
 		apiError.Message = apiError.String(apiError.Code)
 		httpStatus = http.StatusUnprocessableEntity
+	case models.ErrInvalidRole, models.ErrInvalidSuspension:
+		apiError.Code = InvalidRequest
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusUnprocessableEntity
+	case models.ErrUserSuspended:
+		apiError.Code = AccountSuspended
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusUnauthorized
 	// course
 	case models.ErrCourseNameMissing:
 		apiError.Code = CourseNameMissing
@@ -87,11 +98,78 @@ func HandleError(err error) (httpStatus int, apiError ErrorResponse) {
 		apiError.Code = ForzaShareTaken
 		apiError.Message = apiError.String(apiError.Code)
 		httpStatus = http.StatusUnprocessableEntity
+	case models.ErrContentRejected:
+		apiError.Code = ContentRejected
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusUnprocessableEntity
+	// comment
+	case models.ErrCommentEmpty, models.ErrInvalidProfile, models.ErrInvalidUploadProfile:
+		apiError.Code = InvalidRequest
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusUnprocessableEntity
+	// moderation
+	case models.ErrInvalidDecision, models.ErrInvalidReport:
+		apiError.Code = InvalidRequest
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusUnprocessableEntity
+	case models.ErrAlreadyReported:
+		apiError.Code = AlreadyReported
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusUnprocessableEntity
+	case models.ErrCommentDeleted:
+		apiError.Code = CommentDeleted
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusUnprocessableEntity
+	case models.ErrVoteLimitExceeded:
+		apiError.Code = VoteLimitExceeded
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusTooManyRequests
+	case models.ErrUnsupportedFile:
+		apiError.Code = UnsupportedFile
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusUnsupportedMediaType
+	case models.ErrFileTooLarge:
+		apiError.Code = FileTooLarge
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusRequestEntityTooLarge
+	case models.ErrMaximumFilesReached:
+		apiError.Code = MaximumFilesReached
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusUnprocessableEntity
+	case models.ErrStorageQuotaExceeded:
+		apiError.Code = StorageQuotaExceeded
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusUnprocessableEntity
+	case models.ErrClipTooLong:
+		apiError.Code = ClipTooLong
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusUnprocessableEntity
+	case models.ErrUploadOffset:
+		apiError.Code = UploadOffsetMismatch
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusConflict
+	case models.ErrInvalidDateRange, models.ErrInvalidOrder:
+		apiError.Code = InvalidRequest
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusUnprocessableEntity
+	case models.ErrCommentsDisabled:
+		apiError.Code = CommentsDisabled
+		apiError.Message = apiError.String(apiError.Code)
+		httpStatus = http.StatusUnprocessableEntity
 	default:
 		apiError.Code = SystemError
 		apiError.Message = apiError.String(apiError.Code)
 		httpStatus = http.StatusInternalServerError
 	}
+
+	// application errors are expected (validation, permissions), system errors are not
+	if apiError.Code == SystemError {
+		apiError.RequestID = logging.RequestID(c)
+		logging.FromContext(c).Err(err)
+	} else {
+		logging.FromContext(c).Debug(err.Error(), logging.Fields{"code": apiError.Code})
+	}
+
 	return httpStatus, apiError
 }
 
@@ -117,6 +195,24 @@ const (
 	// course
 	CourseNameMissing
 	ForzaShareTaken
+	// account (appended to keep existing codes stable)
+	AccountSuspended
+	// comment
+	CommentDeleted
+	// moderation
+	AlreadyReported
+	ContentRejected
+	// comment (continued)
+	CommentsDisabled
+	// vote
+	VoteLimitExceeded
+	// upload
+	UnsupportedFile
+	FileTooLarge
+	MaximumFilesReached
+	StorageQuotaExceeded
+	UploadOffsetMismatch
+	ClipTooLong
 	SystemError = 99999
 )
 
@@ -146,11 +242,39 @@ func (er ErrorResponse) String(code int32) string {
 	// user
 	case InvalidFriend:
 		msg = "could not add or remove friend"
+	case AccountSuspended:
+		msg = "user account is suspended"
 	// course
 	case CourseNameMissing:
 		msg = "course name is required"
 	case ForzaShareTaken:
 		msg = "Duplicate Forza Share Code"
+	// comment
+	case CommentDeleted:
+		msg = "comment was deleted"
+	case CommentsDisabled:
+		msg = "comments are disabled"
+	// moderation
+	case AlreadyReported:
+		msg = "item already reported"
+	case ContentRejected:
+		msg = "text contains inappropriate content"
+	// vote
+	case VoteLimitExceeded:
+		msg = "too many votes, try again later"
+	// upload
+	case UnsupportedFile:
+		msg = "unsupported file type (JPEG, PNG, WebP, MP4 or WebM required)"
+	case FileTooLarge:
+		msg = "file too large"
+	case MaximumFilesReached:
+		msg = "maximum number of files reached"
+	case StorageQuotaExceeded:
+		msg = "storage quota exceeded"
+	case UploadOffsetMismatch:
+		msg = "upload offset doesn't match, resume at the current offset"
+	case ClipTooLong:
+		msg = "video clip too long"
 	case SystemError:
 		msg = "Server Problem"
 	}
@@ -1,5 +1,7 @@
 package controllers
 
+import "forza-garage/models"
+
 // Created is the standard response for new items
 type Created struct {
 	ID string `json:"id"`
@@ -7,7 +9,11 @@ type Created struct {
 
 // Uploaded is the standard response for new uploads
 type Uploaded struct {
-	URL        string `json:"url"`
-	StatusCode int32  `json:"statusCode"`
-	StatusText string `json:"statusText"`
+	FileName   string                   `json:"fileName"`
+	URL        string                   `json:"url"`
+	StatusCode int32                    `json:"statusCode"`
+	StatusText string                   `json:"statusText"`
+	Renditions []models.UploadRendition `json:"renditions,omitempty"`
+	MediaType  string                   `json:"mediaType"`          // image, video
+	Duration   float64                  `json:"duration,omitempty"` // seconds (clips)
 }
@@ -1,8 +1,8 @@
 package controllers
 
 import (
-	"fmt"
 	"forza-garage/database"
+	"forza-garage/logging"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -12,7 +12,7 @@ import (
 func ListLookups(c *gin.Context) {
 	lookups, err := database.GetLookups()
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(c).Err(err)
 		c.JSON(http.StatusNoContent, nil)
 		return
 	}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"forza-garage/apperror"
+	"forza-garage/audit"
+	"forza-garage/authentication"
+	"forza-garage/environment"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GetModerationQueue returns a random sample of pending or flagged content of all domains
+// http://localhost:3000/moderation/queue?size=10
+func GetModerationQueue(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// optional, default set by model
+	size, _ := strconv.Atoi(c.Query("size"))
+
+	items, err := environment.Env.Moderation.GetModerationItem(size, userID)
+	if err != nil {
+		// nothing to review (not an error to the client)
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+// Moderate approves, rejects or flags an item of the queue
+// http://localhost:3000/moderation/comment/608e63ced04782d5c49c1eb8/approve
+func Moderate(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	err = environment.Env.Moderation.Moderate(c.Param("type"), c.Param("id"), c.Param("decision"), userID)
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	environment.Env.Audit.Audit(auditContext(c), userID, audit.ActionModerate, audit.Target(c.Param("type"), c.Param("id")),
+		bson.M{"decision": c.Param("decision")})
+
+	c.Status(http.StatusNoContent)
+}
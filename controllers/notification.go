@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"forza-garage/apperror"
+	"forza-garage/authentication"
+	"forza-garage/environment"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListNotifications returns the latest notifications of the current user
+// http://localhost:3000/user/notifications?unread=true
+func ListNotifications(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	notifications, err := environment.Env.Notification.ListNotifications(userID, c.Query("unread") == "true")
+	if err != nil {
+		// nothing found (not an error to the client)
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.JSON(http.StatusOK, notifications)
+}
+
+// MarkNotificationsRead marks a notification of the current user as read (all of them without ID)
+// http://localhost:3000/user/notifications/read?id=608e63ced04782d5c49c1eb8
+func MarkNotificationsRead(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	err = environment.Env.Notification.MarkRead(userID, c.Query("id"))
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"forza-garage/apperror"
+	"forza-garage/authentication"
+	"forza-garage/environment"
+	"forza-garage/helpers"
+	"forza-garage/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AddReport saves a user's report of some content
+func AddReport(c *gin.Context) {
+
+	var (
+		err      error
+		data     models.Report
+		apiError ErrorResponse
+	)
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	if err = c.ShouldBindJSON(&data); err != nil {
+		apiError.Code = InvalidJSON
+		apiError.Message = apiError.String(apiError.Code)
+		c.JSON(http.StatusUnprocessableEntity, apiError)
+		return
+	}
+
+	report, err := environment.Env.ReportModel.Validate(data)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	// apply user from token
+	report.ReporterID = helpers.ObjectID(userID)
+
+	err = environment.Env.ReportModel.CreateReport(report)
+	if err != nil {
+		// content not found or not visible to the user
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.JSON(http.StatusCreated, Created{report.ID.Hex()})
+}
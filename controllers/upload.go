@@ -1,18 +1,25 @@
 package controllers
 
 import (
-	"fmt"
+	"encoding/base64"
 	"forza-garage/apperror"
+	"forza-garage/audit"
 	"forza-garage/authentication"
 	"forza-garage/environment"
 	"forza-garage/helpers"
+	"forza-garage/logging"
 	"forza-garage/models"
+	"forza-garage/storage"
+	"io"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/twinj/uuid"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 // Upload is the generic file uploader for profiles
@@ -43,7 +50,7 @@ func UploadFile(c *gin.Context) {
 	// single file
 	file, err := c.FormFile("file")
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(c).Debug("file missing", logging.Fields{"error": err})
 		apiError.Code = InvalidJSON
 		apiError.Message = apiError.String(apiError.Code)
 		c.JSON(http.StatusUnprocessableEntity, apiError)
@@ -53,53 +60,241 @@ func UploadFile(c *gin.Context) {
 	// generate file name & initialize metadata
 	uploadInfo = new(models.UploadInfo)
 	uploadInfo.UploadedID = helpers.ObjectID(userID) // executive user from token
-	uploadInfo.SysFileName = profileType + "_" + uuid.NewV4().String() + filepath.Ext(file.Filename)
+	uploadInfo.SysFileName = profileType + "_" + uuid.NewV4().String()
 	uploadInfo.OrigFileName = file.Filename
 	uploadInfo.Description = c.PostForm("description")
-	uploadInfo.URL = os.Getenv("API_HOME") + ":" + os.Getenv("API_PORT") + environment.UploadEndpoint + "/" + uploadInfo.SysFileName
 
 	// https://www.devdungeon.com/content/working-files-go
-
-	stage := os.Getenv("UPLOAD_STAGE") + "/" + uploadInfo.SysFileName
-
-	// Upload the file to specific stage
-	err = c.SaveUploadedFile(file, stage)
+	src, err := file.Open()
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(c).Err(err)
 		apiError.Code = SystemError
 		apiError.Message = apiError.String(apiError.Code)
+		apiError.RequestID = logging.RequestID(c)
 		c.JSON(http.StatusInternalServerError, apiError)
 		return
 	}
+	defer src.Close()
 
-	// move file to destination
-	dst := os.Getenv("UPLOAD_TARGET") + "/" + uploadInfo.SysFileName
-	err = os.Rename(stage, dst)
+	// save file & meta data (registry)
+	err = environment.Env.UploadModel.StoreFile(profileID, profileType, uploadInfo, src, file.Size)
 	if err != nil {
-		fmt.Println(err)
-		apiError.Code = SystemError
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.JSON(http.StatusCreated, Uploaded{
+		uploadInfo.SysFileName,
+		uploadInfo.URL,
+		uploadInfo.StatusCode,
+		uploadInfo.StatusText,
+		uploadInfo.Renditions,
+		uploadInfo.MediaType,
+		uploadInfo.Duration,
+	})
+}
+
+// resumable uploads (tus 1.0.0 core & creation, expiration and termination extensions)
+// the metadata of the upload is passed on creation (Upload-Metadata: profileId, profileType, filename, description)
+const tusVersion = "1.0.0"
+
+// CreateResumableUpload registers an upload, the chunks are sent to the returned location
+// http://localhost:3000/upload/resumable
+func CreateResumableUpload(c *gin.Context) {
+
+	var apiError ErrorResponse
+
+	c.Header("Tus-Resumable", tusVersion)
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// deferred lengths aren't supported
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	metadata := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil || metadata["profileId"] == "" || metadata["profileType"] == "" {
+		apiError.Code = InvalidRequest
 		apiError.Message = apiError.String(apiError.Code)
-		c.JSON(http.StatusInternalServerError, apiError)
+		c.JSON(http.StatusBadRequest, apiError)
 		return
 	}
 
-	// update meta data (registry)
-	err = environment.Env.UploadModel.SaveMetaData(profileID, profileType, uploadInfo)
+	// generate file name & initialize metadata (see UploadFile)
+	uploadInfo := new(models.UploadInfo)
+	uploadInfo.UploadedID = helpers.ObjectID(userID) // executive user from token
+	uploadInfo.SysFileName = metadata["profileType"] + "_" + uuid.NewV4().String()
+	uploadInfo.OrigFileName = metadata["filename"]
+	uploadInfo.Description = metadata["description"]
+
+	upload, err := environment.Env.UploadModel.CreateResumable(metadata["profileId"], metadata["profileType"], uploadInfo, length)
 	if err != nil {
-		fmt.Println(err)
-		apiError.Code = SystemError
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.Header("Location", c.Request.URL.Path+"/"+upload.ID.Hex())
+	c.Header("Upload-Expires", upload.ExpiresTS.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// GetResumableUpload returns the offset to resume an upload at (HEAD)
+func GetResumableUpload(c *gin.Context) {
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	upload, err := environment.Env.UploadModel.GetResumable(c.Param("id"), userID)
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, _ := HandleError(c, err)
+		c.Status(status) // no body
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Upload-Expires", upload.ExpiresTS.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+// PatchResumableUpload appends a chunk at the offset (Upload-Offset)
+// the upload is stored with the last chunk and returned like by UploadFile
+func PatchResumableUpload(c *gin.Context) {
+
+	var apiError ErrorResponse
+
+	c.Header("Tus-Resumable", tusVersion)
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.Status(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		apiError.Code = InvalidRequest
 		apiError.Message = apiError.String(apiError.Code)
-		c.JSON(http.StatusInternalServerError, apiError)
+		c.JSON(http.StatusBadRequest, apiError)
 		return
 	}
 
-	c.JSON(http.StatusCreated, Uploaded{
+	upload, uploadInfo, err := environment.Env.UploadModel.WriteResumable(c.Param("id"), userID, offset, c.Request.ContentLength, c.Request.Body)
+	if upload != nil {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.Header("Upload-Expires", upload.ExpiresTS.UTC().Format(http.TimeFormat))
+	}
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	if uploadInfo == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, Uploaded{
+		uploadInfo.SysFileName,
 		uploadInfo.URL,
 		uploadInfo.StatusCode,
 		uploadInfo.StatusText,
+		uploadInfo.Renditions,
+		uploadInfo.MediaType,
+		uploadInfo.Duration,
 	})
 }
 
+// DeleteResumableUpload cancels an upload
+func DeleteResumableUpload(c *gin.Context) {
+
+	c.Header("Tus-Resumable", tusVersion)
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	err = environment.Env.UploadModel.DeleteResumable(c.Param("id"), userID)
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// DownloadBlob serves a file of the local blob store, the URL must be signed (see GetMetaData)
+// http://localhost:3000/upload/active/9f86d081884c7d65.jpg?exp=1618002000&sig=q2b7jD1u...
+func DownloadBlob(c *gin.Context) {
+
+	local, ok := environment.Env.Blobs.(*storage.LocalStore)
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !local.Verify(key, c.Query("exp"), c.Query("sig")) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	content, info, err := local.Get(key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		logging.FromContext(c).Err(err, logging.Fields{"key": key})
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	// files are never changed, but a profile's visibility might be (verified, hence exp is valid)
+	exp, _ := strconv.ParseInt(c.Query("exp"), 10, 64)
+	c.Header("Cache-Control", "private, max-age="+strconv.FormatInt(exp-time.Now().Unix(), 10))
+	c.Header("Content-Type", info.ContentType)
+
+	if rs, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, path.Base(key), info.LastModified, rs)
+		return
+	}
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, content)
+}
+
 // DownloadFilesPublic is the generic URL-provider for all profiles
 // if moderation is enabled, this endpoint only returns approved content
 func DownloadFilesPublic(c *gin.Context) {
@@ -113,7 +308,7 @@ func DownloadFilesPublic(c *gin.Context) {
 			return
 		}
 		// technical errors
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -140,17 +335,11 @@ func DownloadFilesMember(c *gin.Context) {
 			return
 		}
 		// technical errors
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
 
-	// add patch to build URL of profile picture
-	// statt env künftig zentrales config obj - dann im Model
-	for i, v := range fileInfos {
-		fileInfos[i].URL = os.Getenv("API_HOME") + ":" + os.Getenv("API_PORT") + environment.UploadEndpoint + "/" + v.URL
-	}
-
 	c.JSON(http.StatusOK, fileInfos)
 }
 
@@ -168,7 +357,172 @@ func DeleteFile(c *gin.Context) {
 	profileOID := helpers.ObjectID(c.Param("id"))
 	userOID := helpers.ObjectID(userID)
 
-	_ = environment.Env.UploadModel.DeleteUpload(profileOID, c.Param("fid"), userOID)
+	err = environment.Env.UploadModel.DeleteUpload(profileOID, c.Param("fid"), userOID)
+	if err == nil {
+		environment.Env.Audit.Audit(auditContext(c), userID, audit.ActionUploadDelete, audit.Target(models.ContentTypeUpload, c.Param("fid")),
+			bson.M{"profileID": c.Param("id")})
+	}
 
 	// always return OK since any error is ignored
 }
+
+// SortUploads arranges the files of a profile's gallery (profile owner)
+// all file names must be passed in the new order
+func SortUploads(c *gin.Context) {
+
+	var apiError ErrorResponse
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	data := struct {
+		FileNames []string `json:"fileNames" binding:"required"`
+	}{}
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apiError.Code = InvalidJSON
+		apiError.Message = apiError.String(apiError.Code)
+		c.JSON(http.StatusUnprocessableEntity, apiError)
+		return
+	}
+
+	err = environment.Env.UploadModel.SortUploads(c.Param("id"), data.FileNames, userID)
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// UpdateUpload changes the description of a file (uploader)
+func UpdateUpload(c *gin.Context) {
+
+	var apiError ErrorResponse
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// an empty description removes it
+	data := struct {
+		Description string `json:"description"`
+	}{}
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apiError.Code = InvalidJSON
+		apiError.Message = apiError.String(apiError.Code)
+		c.JSON(http.StatusUnprocessableEntity, apiError)
+		return
+	}
+
+	err = environment.Env.UploadModel.SetDescription(c.Param("id"), c.Param("fid"), strings.TrimSpace(data.Description), userID)
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// SetUploadCover chooses the file shown in lists (profile owner)
+func SetUploadCover(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	err = environment.Env.UploadModel.SetCover(c.Param("id"), c.Param("fid"), userID)
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetStorageUsage returns the current user's storage usage and quota
+func GetStorageUsage(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	usage, err := environment.Env.UserModel.GetStorageUsage(userID)
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// GetGarbageReport lists the blobs without uploads, nothing is removed (admins only)
+// http://localhost:3000/admin/uploads/garbage
+func GetGarbageReport(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	report, err := environment.Env.UploadModel.GetGarbageReport(userID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// Upload-Metadata: comma-separated pairs of key and base64-encoded value (the value may be missing)
+func parseUploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		value := ""
+		if len(fields) > 1 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				continue
+			}
+			value = string(decoded)
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata
+}
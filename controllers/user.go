@@ -1,16 +1,15 @@
 package controllers
 
 import (
-	"fmt"
 	"forza-garage/apperror"
+	"forza-garage/audit"
 	"forza-garage/authentication"
 	"forza-garage/environment"
 	"forza-garage/helpers"
+	"forza-garage/logging"
 	"forza-garage/lookups"
 	"forza-garage/models"
 	"net/http"
-	"os"
-	"path/filepath"
 
 	"github.com/gin-gonic/gin"
 	"github.com/twinj/uuid"
@@ -86,7 +85,7 @@ func GetUser(c *gin.Context) {
 			return
 		}
 		// technical errors
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -105,16 +104,11 @@ func GetUser(c *gin.Context) {
 	// don't send password hash
 	user.Password = ""
 
-	// add patch to build URL of profile picture
-	if user.ProfilePicture != nil {
-		user.ProfilePicture.URL = os.Getenv("API_HOME") + ":" + os.Getenv("API_PORT") + environment.UploadEndpoint + "/" + user.ProfilePicture.URL
-	}
-
 	c.JSON(http.StatusOK, &user)
 
 	// log this request, if it was a new one
-	if environment.Env.Requests.Continue(getIP(c.Request), c.Param("id")) {
-		environment.Env.Tracker.SaveVisitor("user", c.Param("id"), userID)
+	if environment.Env.Requests.Continue(helpers.GetIP(c.Request), c.Param("id")) {
+		environment.Env.Tracker.SaveVisitor(models.ContentTypeUser, c.Param("id"), userID)
 	}
 }
 
@@ -144,10 +138,12 @@ func BlockUser(c *gin.Context) { // ToDo: Unlock
 
 	err = environment.Env.UserModel.BlockUser(userID, data.BlockedUserID)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
+
+	environment.Env.Audit.Audit(auditContext(c), userID, audit.ActionBlock, audit.Target(models.ContentTypeUser, data.BlockedUserID), nil)
 }
 
 // UnblockUser removes someone from the user's ignorelist
@@ -176,10 +172,12 @@ func UnblockUser(c *gin.Context) { // ToDo: Unlock
 
 	err = environment.Env.UserModel.UnblockUser(userID, data.BlockedUserID)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
+
+	environment.Env.Audit.Audit(auditContext(c), userID, audit.ActionUnblock, audit.Target(models.ContentTypeUser, data.BlockedUserID), nil)
 }
 
 // GetFriends sends a profile
@@ -203,7 +201,7 @@ func GetFriends(c *gin.Context) {
 			return
 		}
 		// technical errors
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -232,7 +230,7 @@ func GetFollowings(c *gin.Context) {
 			return
 		}
 		// technical errors
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -261,7 +259,7 @@ func GetFollowers(c *gin.Context) {
 			return
 		}
 		// technical errors
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -295,7 +293,7 @@ func AddFriend(c *gin.Context) {
 
 	err = environment.Env.UserModel.AddFriend(userID, data.FriendID)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -330,7 +328,7 @@ func RemoveFriend(c *gin.Context) {
 
 	err = environment.Env.UserModel.RemoveFriend(userID, data.FriendID)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -363,7 +361,7 @@ func FollowUser(c *gin.Context) {
 
 	err = environment.Env.UserModel.FollowUser(userID, data.UserID)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -399,7 +397,7 @@ func UploadProfilePicture(c *gin.Context) {
 	// single file
 	file, err := c.FormFile("file")
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(c).Debug("file missing", logging.Fields{"error": err})
 		apiError.Code = InvalidJSON
 		apiError.Message = apiError.String(apiError.Code)
 		c.JSON(http.StatusUnprocessableEntity, apiError)
@@ -409,50 +407,37 @@ func UploadProfilePicture(c *gin.Context) {
 	// generate file name & initialize metadata
 	uploadInfo = new(models.UploadInfo)
 	uploadInfo.UploadedID = helpers.ObjectID(userID) // executive user from token
-	uploadInfo.SysFileName = "usr_" + uuid.NewV4().String() + filepath.Ext(file.Filename)
+	uploadInfo.SysFileName = "usr_" + uuid.NewV4().String()
 	uploadInfo.OrigFileName = file.Filename
-	uploadInfo.URL = os.Getenv("API_HOME") + ":" + os.Getenv("API_PORT") + environment.UploadEndpoint + "/" + uploadInfo.SysFileName
 
 	// https://www.devdungeon.com/content/working-files-go
-
-	stage := os.Getenv("UPLOAD_STAGE") + "/" + uploadInfo.SysFileName
-
-	// Upload the file to specific stage
-	err = c.SaveUploadedFile(file, stage)
+	src, err := file.Open()
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(c).Err(err)
 		apiError.Code = SystemError
 		apiError.Message = apiError.String(apiError.Code)
+		apiError.RequestID = logging.RequestID(c)
 		c.JSON(http.StatusInternalServerError, apiError)
 		return
 	}
+	defer src.Close()
 
-	// update meta data (registry)
-	// (file left in stage if this step fails)
-	err = environment.Env.UploadModel.SaveMetaData(profileID, "user", uploadInfo)
+	// save file & meta data (registry)
+	err = environment.Env.UploadModel.StoreFile(profileID, models.ContentTypeUser, uploadInfo, src, file.Size)
 	if err != nil {
-		fmt.Println(err)
-		apiError.Code = SystemError
-		apiError.Message = apiError.String(apiError.Code)
-		c.JSON(http.StatusInternalServerError, apiError)
-		return
-	}
-
-	// move file to destination
-	dst := os.Getenv("UPLOAD_TARGET") + "/" + uploadInfo.SysFileName
-	err = os.Rename(stage, dst)
-	if err != nil {
-		fmt.Println(err)
-		apiError.Code = SystemError
-		apiError.Message = apiError.String(apiError.Code)
-		c.JSON(http.StatusInternalServerError, apiError)
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
 		return
 	}
 
 	c.JSON(http.StatusCreated, Uploaded{
+		uploadInfo.SysFileName,
 		uploadInfo.URL,
 		uploadInfo.StatusCode,
 		uploadInfo.StatusText,
+		uploadInfo.Renditions,
+		uploadInfo.MediaType,
+		uploadInfo.Duration,
 	})
 
 }
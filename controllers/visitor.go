@@ -1,10 +1,11 @@
 package controllers
 
 import (
-	"fmt"
 	"forza-garage/apperror"
 	"forza-garage/authentication"
 	"forza-garage/environment"
+	"forza-garage/logging"
+	"forza-garage/models"
 	"net/http"
 	"time"
 
@@ -43,7 +44,7 @@ func GetCourseVisits(c *gin.Context) {
 		// https://forum.golangbridge.org/t/convert-string-to-date-in-yyyy-mm-dd-format/6026/2
 		startDT, err = time.Parse("2006-01-02", startStr) // seems magic date
 		if err != nil {
-			fmt.Println(err)
+			logging.FromContext(c).Debug("invalid start date", logging.Fields{"error": err})
 			apiError.Code = InvalidRequest
 			apiError.Message = apiError.String(apiError.Code)
 			c.JSON(http.StatusUnprocessableEntity, apiError)
@@ -51,9 +52,9 @@ func GetCourseVisits(c *gin.Context) {
 		}
 	}
 
-	visits, err := environment.Env.Tracker.GetVisits("course", id, startDT)
+	visits, err := environment.Env.Tracker.GetVisits(models.ContentTypeCourse, id, startDT)
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(c).Err(err)
 		apiError.Code = InvalidRequest // ToDO: evtl. intServ oder genauer
 		apiError.Message = apiError.String(apiError.Code)
 		c.JSON(http.StatusUnprocessableEntity, apiError)
@@ -106,7 +107,7 @@ func ListVisitors(c *gin.Context) {
 		// https://forum.golangbridge.org/t/convert-string-to-date-in-yyyy-mm-dd-format/6026/2
 		startDT, err = time.Parse("2006-01-02", startStr) // seems magic date
 		if err != nil {
-			fmt.Println(err)
+			logging.FromContext(c).Debug("invalid start date", logging.Fields{"error": err})
 			apiError.Code = InvalidRequest
 			apiError.Message = apiError.String(apiError.Code)
 			c.JSON(http.StatusUnprocessableEntity, apiError)
@@ -122,7 +123,7 @@ func ListVisitors(c *gin.Context) {
 			return
 		}
 		// technical errors
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
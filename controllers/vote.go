@@ -7,6 +7,8 @@ import (
 	"forza-garage/helpers"
 	"forza-garage/models"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -39,7 +41,7 @@ func CastVote(c *gin.Context) {
 		// validate request
 		course, err := environment.Env.CourseModel.Validate(data)
 		if err != nil {
-			status, apiError := HandleError(err)
+			status, apiError := HandleError(c, err)
 			c.JSON(status, apiError)
 			return
 		}
@@ -47,22 +49,42 @@ func CastVote(c *gin.Context) {
 
 	// apply userID from token (username resolved in model)
 	data.UserID = helpers.ObjectID(userID)
+	data.IP = helpers.GetIP(c.Request)
 
-	// inject SetRating based on domain
+	err = environment.Env.VoteModel.Throttle(userID, data.ProfileID)
+	if err != nil {
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	// inject the voted domain
 	var profileVotes *models.ProfileVotes
 	switch data.ProfileType {
-	case "course":
-		profileVotes, err = environment.Env.VoteModel.CastVote(data, environment.Env.CourseModel.SetRating)
-	case "comment":
-		profileVotes, err = environment.Env.VoteModel.CastVote(data, environment.Env.CommentModel.SetRating)
+	case models.ContentTypeCourse:
+		profileVotes, err = environment.Env.VoteModel.CastVote(data, environment.Env.CourseModel)
+	case models.ContentTypeComment, models.ContentTypeReply:
+		// replies share the comments' domain (embedded)
+		data.ProfileType = models.ContentTypeComment
+		// revoking is always possible (eg. deleted comments)
+		if data.Vote != models.VoteNeutral {
+			err = environment.Env.CommentModel.CheckVote(data.ProfileID, userID)
+			if err == apperror.ErrNoData {
+				c.Status(http.StatusNotFound)
+				return
+			}
+		}
+		if err == nil {
+			profileVotes, err = environment.Env.VoteModel.CastVote(data, environment.Env.CommentModel)
+		}
 	default:
-		apiError.Code = SystemError
+		apiError.Code = InvalidRequest
 		apiError.Message = apiError.String(apiError.Code)
-		// fmt.Println(err)
-		c.JSON(http.StatusInternalServerError, apiError)
+		c.JSON(http.StatusUnprocessableEntity, apiError)
+		return
 	}
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -70,9 +92,80 @@ func CastVote(c *gin.Context) {
 	c.JSON(http.StatusOK, profileVotes)
 }
 
-// GetUserVote returns the vote of a user to a profile - entfernt
+// ListVoteClusters returns suspicious groups of votes (admins only)
+// http://localhost:3000/admin/votes/clusters?hours=24
+func ListVoteClusters(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// optional, defaults set by model
+	hours, _ := strconv.Atoi(c.Query("hours"))
+
+	clusters, err := environment.Env.VoteModel.DetectClusters(hours, userID)
+	if err != nil {
+		// nothing found (not an error to the client)
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.JSON(http.StatusOK, clusters)
+}
+
+// GetCourseVoteHistory returns the daily votes and rating of a course (creator & admins)
+// range defaults to the last 30 days
+// http://localhost:3000/courses/member/6060491beab278c482d04ed8/votes/history?from=2021-03-01&to=2021-03-31
+func GetCourseVoteHistory(c *gin.Context) {
+
+	var apiError ErrorResponse
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -29)
+
+	// https://forum.golangbridge.org/t/convert-string-to-date-in-yyyy-mm-dd-format/6026/2
+	for param, value := range map[string]*time.Time{"from": &from, "to": &to} {
+		if c.Query(param) == "" {
+			continue
+		}
+		*value, err = time.Parse("2006-01-02", c.Query(param))
+		if err != nil {
+			apiError.Code = InvalidRequest
+			apiError.Message = apiError.String(apiError.Code)
+			c.JSON(http.StatusUnprocessableEntity, apiError)
+			return
+		}
+	}
+
+	history, err := environment.Env.VoteModel.GetVoteHistory(models.ContentTypeCourse, c.Param("id"), from, to, userID)
+	if err != nil {
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
+		c.JSON(status, apiError)
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// GetUserVote returns the vote of a user to a profile
 // http://localhost:3000/user/vote?pId=6055d819671e62579fcc2151
-/*
 func GetUserVote(c *gin.Context) {
 
 	var profileId = c.Query("pId")
@@ -86,7 +179,7 @@ func GetUserVote(c *gin.Context) {
 
 	vote, err := environment.Env.VoteModel.GetUserVote(profileId, userID)
 	if err != nil {
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -98,7 +191,6 @@ func GetUserVote(c *gin.Context) {
 
 	c.JSON(http.StatusOK, res)
 }
-*/
 
 // GetUserVotes returns the votes of a user to profiles of given type
 // http://localhost:3000/users/601526e8a468e8973193facd/votes?pDomain=course
@@ -121,7 +213,7 @@ func GetUserVotes(c *gin.Context) {
 			return
 		}
 		// technical errors
-		status, apiError := HandleError(err)
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -129,19 +221,44 @@ func GetUserVotes(c *gin.Context) {
 	c.JSON(http.StatusOK, votes)
 }
 
-// Nicht mehr benutzt
 // GetVotesPublic returns the current votes for and against a profile
 // http://localhost:3000/courses/public/6060491beab278c482d04ed8/votes
-/*
 func GetVotesPublic(c *gin.Context) {
+	getVotes(c, models.ContentTypeCourse, "")
+}
 
-	var (
-		profileId = c.Param("id")
-	)
+// GetVotesMember returns the current votes for and against a profile as well as a user's action
+// http://localhost:3000/courses/member/6060491beab278c482d04ed8/votes
+func GetVotesMember(c *gin.Context) {
+
+	userID, err := authentication.Authenticate(c.Request)
+	if err != nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	getVotes(c, models.ContentTypeCourse, userID)
+}
+
+// GetCommentVotes returns the current votes of a comment or reply (and the user's action for members)
+// http://localhost:3000/comments/608e63ced04782d5c49c1eb8/votes
+func GetCommentVotes(c *gin.Context) {
+
+	// any error is considered an anonymous visitor
+	userID, _ := authentication.Authenticate(c.Request)
+
+	getVotes(c, models.ContentTypeComment, userID)
+}
+
+func getVotes(c *gin.Context, profileType string, userID string) {
 
-	profileVotes, err := environment.Env.VoteModel.GetVotes(profileId, "")
+	profileVotes, err := environment.Env.VoteModel.GetVotes(profileType, c.Param("id"), userID)
 	if err != nil {
-		status, apiError := HandleError(err)
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
@@ -149,11 +266,18 @@ func GetVotesPublic(c *gin.Context) {
 	c.JSON(http.StatusOK, profileVotes)
 }
 
-// GetVotesMember returns the current votes for and against a profile as well as a user's action
-// http://localhost:3000/courses/member/6060491beab278c482d04ed8/votes
-func GetVotesMember(c *gin.Context) {
+// ListCourseVoters returns a page of the users who up-voted a course (creator & admins)
+// http://localhost:3000/courses/member/6060491beab278c482d04ed8/voters?cursor=...&pageSize=20
+func ListCourseVoters(c *gin.Context) {
+	listVoters(c, models.ContentTypeCourse)
+}
+
+// ListCommentVoters returns a page of the users who up-voted a comment or reply (author & admins)
+func ListCommentVoters(c *gin.Context) {
+	listVoters(c, models.ContentTypeComment)
+}
 
-	var profileId = c.Param("id")
+func listVoters(c *gin.Context, profileType string) {
 
 	userID, err := authentication.Authenticate(c.Request)
 	if err != nil {
@@ -161,13 +285,20 @@ func GetVotesMember(c *gin.Context) {
 		return
 	}
 
-	profileVotes, err := environment.Env.VoteModel.GetVotes(profileId, userID)
+	// optional, defaults set by model
+	pageSize, _ := strconv.Atoi(c.Query("pageSize"))
+
+	voters, err := environment.Env.VoteModel.ListVoters(profileType, c.Param("id"), c.Query("cursor"), pageSize, userID)
 	if err != nil {
-		status, apiError := HandleError(err)
+		// nothing found (not an error to the client)
+		if err == apperror.ErrNoData {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		status, apiError := HandleError(c, err)
 		c.JSON(status, apiError)
 		return
 	}
 
-	c.JSON(http.StatusOK, profileVotes)
+	c.JSON(http.StatusOK, voters)
 }
-*/
@@ -2,36 +2,59 @@ package environment
 
 import (
 	"forza-garage/analytics"
+	"forza-garage/audit"
+	"forza-garage/authentication"
 	"forza-garage/authorization"
 	"forza-garage/client"
 	"forza-garage/database"
+	"forza-garage/filter"
+	"forza-garage/logging"
 	"forza-garage/models"
+	"forza-garage/storage"
 	"os"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // upload serving endpoint's path is used
-// also by the respective handlers to build response url
+// also by the local blob store to build the files' URLs
 const UploadEndpoint = "/upload"
 
 // Environment is used for dependency-injection (package de-coupling)
 type Environment struct {
 	Requests     *client.Registry
+	Limiter      *client.Limiter
 	Tracker      *analytics.Tracker
 	Credentials  *authorization.Credentials
+	Filter       filter.ContentFilter
+	Blobs        storage.BlobStore
+	Audit        *audit.Log
 	UserModel    models.UserModel
 	VoteModel    models.VoteModel
 	CommentModel models.CommentModel
 	UploadModel  models.UploadModel
 	CourseModel  models.CourseModel
+	Moderation   *models.Moderation
+	ReportModel  models.ReportModel
+	Notification models.NotificationModel
+	Log          *logging.Logger // requests have their own (see logging.FromContext)
 }
 
 // newEnv operates as the constructor to initialize the collection references (private)
 func newEnv(mongoClient *mongo.Client, influxClient *influxdb2.Client) *Environment {
 	env := &Environment{}
 
+	// structured logging (APP_ENV, LOG_LEVEL), packages without injection use the default logger
+	// the models are copied by the injections below, hence their loggers are set first
+	env.Log = logging.NewFromEnv()
+	logging.SetDefault(env.Log)
+	env.UserModel.Log = env.Log
+	env.VoteModel.Log = env.Log
+	env.UploadModel.Log = env.Log
+	env.CourseModel.Log = env.Log
+
 	// ToDO: mongoClient für Modelle entfernen
 
 	// ToDO: überlegen, ob zentral bei der connection als funktion getCollection
@@ -39,11 +62,16 @@ func newEnv(mongoClient *mongo.Client, influxClient *influxdb2.Client) *Environm
 	mongoCollections["users"] = mongoClient.Database(os.Getenv("DB_NAME")).Collection("users") // ToDO: const
 	mongoCollections["racing"] = mongoClient.Database(os.Getenv("DB_NAME")).Collection("racing")
 	mongoCollections["social"] = mongoClient.Database(os.Getenv("DB_NAME")).Collection("social")
+	mongoCollections["audit"] = mongoClient.Database(os.Getenv("DB_NAME")).Collection("audit")
+	mongoCollections["comments"] = mongoClient.Database(os.Getenv("DB_NAME")).Collection("comments")
 
 	// keep track of clients and their last requests
 	env.Requests = new(client.Registry)
 	env.Requests.Initialize()
 
+	// rate limits of user actions
+	env.Limiter = client.NewLimiter()
+
 	// prepare analytics gathering (profile visits)
 	// always create the object so no futher checking is needed in the models
 	env.Tracker = new(analytics.Tracker)
@@ -60,32 +88,74 @@ func newEnv(mongoClient *mongo.Client, influxClient *influxdb2.Client) *Environm
 	env.Tracker.SearchAPI.QueryAPI = fluxClient.QueryAPI(os.Getenv("ANALYTICS_ORG"))
 	// no deletes required for search bucket (TTL set)
 	env.Tracker.Requests = env.Requests
+	env.Tracker.Log = env.Log
+	if os.Getenv("USE_ANALYTICS") == "YES" {
+		env.Tracker.LogWriteErrors()
+	}
 
 	env.Credentials = new(authorization.Credentials)
 	env.Credentials.SetConnections(mongoCollections)
 
+	// content filter for user generated texts
+	env.Filter = filter.NewWordFilter()
+
+	// append-only log of security related actions
+	env.Audit = new(audit.Log)
+	env.Audit.SetConnections(mongoCollections)
+	env.Audit.GetCredentials = env.Credentials.GetCredentials
+	env.Audit.Logger = env.Log
+
+	// uploaded files (STORAGE_DRIVER)
+	blobs, err := storage.NewBlobStore(os.Getenv("API_HOME") + ":" + os.Getenv("API_PORT") + UploadEndpoint)
+	if err != nil {
+		env.Log.Err(err)
+		os.Exit(1)
+	}
+	env.Blobs = blobs
+
 	// upload muss vor user initialisiert werden
 	env.UploadModel.Collection = mongoClient.Database(os.Getenv("DB_NAME")).Collection("uploads")
 	//env.UploadModel.GetUserNameOID = env.UserModel.GetUserNameOID
 	env.UploadModel.GetCredentials = env.Credentials.GetCredentials
+	env.UploadModel.Filter = env.Filter
+	env.UploadModel.Blobs = env.Blobs
+	env.UploadModel.Refs = mongoClient.Database(os.Getenv("DB_NAME")).Collection("blobs")
+	env.UploadModel.Resumables = mongoClient.Database(os.Getenv("DB_NAME")).Collection("resumableUploads")
 
 	env.UserModel.Client = mongoClient
 	env.UserModel.Collection = mongoClient.Database(os.Getenv("DB_NAME")).Collection("users") // ToDO: Const
 	env.UserModel.Social = mongoClient.Database(os.Getenv("DB_NAME")).Collection("social")    // ToDO: Const
-	env.UserModel.GetProfilePicture = env.UploadModel.GetMetaData
+	// models are copied by method values, the upload model is completed below
+	env.UserModel.GetProfilePicture = func(profileOID primitive.ObjectID, userID string) ([]models.FileInfo, error) {
+		return env.UploadModel.GetMetaData(profileOID, userID)
+	}
 
 	env.UploadModel.GetUserNameOID = env.UserModel.GetUserNameOID // ToDo: Evtl. auch in author - REIHENFOLGE heikel
+	env.UploadModel.ReserveStorage = env.UserModel.ReserveStorage
+	env.UploadModel.ReleaseStorage = env.UserModel.ReleaseStorage
 
 	// inject user model function to analytics tracker after its initialization
 	env.Tracker.GetUserName = env.UserModel.GetUserName
+	env.Audit.GetUserName = env.UserModel.GetUserName
+	env.UserModel.Audit = env.Audit.Audit
 	// env.Tracker.GetUserNameOID = env.UserModel.GetUserNameOID - nicht mehr benötigt; alte Lösung
 
+	env.Notification.Collection = mongoClient.Database(os.Getenv("DB_NAME")).Collection("notifications")
+	env.UserModel.DeleteNotifications = env.Notification.DeleteNotifications
+
+	env.VoteModel.Client = mongoClient
 	env.VoteModel.Collection = mongoClient.Database(os.Getenv("DB_NAME")).Collection("votes") // ToDO: Const
+	env.VoteModel.History = mongoClient.Database(os.Getenv("DB_NAME")).Collection("voteEvents")
 	env.VoteModel.GetUserNameOID = env.UserModel.GetUserNameOID
+	env.VoteModel.GetCredentials = env.UserModel.GetCredentials
+	env.VoteModel.Allow = env.Limiter.Allow
+	env.VoteModel.GetVoterNames = env.UserModel.GetDisplayNames
 
 	env.CommentModel.Collection = mongoClient.Database(os.Getenv("DB_NAME")).Collection("comments")
 	env.CommentModel.GetUserNameOID = env.UserModel.GetUserNameOID
-	env.CommentModel.GetUserVotes = env.VoteModel.GetUserVotes
+	env.CommentModel.GetUserVotes = env.VoteModel.GetUserVotesFor
+	env.CommentModel.GetCredentials = env.UserModel.GetCredentials
+	env.CommentModel.Filter = env.Filter
 
 	env.CourseModel.Client = mongoClient
 	env.CourseModel.Collection = mongoClient.Database(os.Getenv("DB_NAME")).Collection("racing") // ToDO: Const
@@ -93,9 +163,65 @@ func newEnv(mongoClient *mongo.Client, influxClient *influxdb2.Client) *Environm
 	env.CourseModel.GetUserName = env.UserModel.GetUserName
 	env.CourseModel.CredentialsReader = env.UserModel.GetCredentials // ToDo: auf authorization umstellen
 	env.CourseModel.GetUserVote = env.VoteModel.GetUserVote
+	env.CourseModel.GetCovers = env.UploadModel.GetCovers
+	env.CourseModel.Filter = env.Filter
+
+	// markup of comments (mentions, course references)
+	env.CommentModel.GetUserIDByName = env.UserModel.GetUserIDByName
+	env.CommentModel.GetCourseIDBySharing = env.CourseModel.GetCourseIDBySharing
+	env.CommentModel.Notify = env.Notification.Notify
+
+	// commentable profiles - their owners may pin comments and disable the section
+	env.CommentModel.Settings = mongoClient.Database(os.Getenv("DB_NAME")).Collection("commentSettings")
+	env.CommentModel.Profiles = map[string]models.ProfileAccess{
+		models.ContentTypeCourse: env.CourseModel.GetCommentProfile,
+		models.ContentTypeUser:   env.UserModel.GetCommentProfile,
+		models.ContentTypeUpload: env.UploadModel.GetCommentProfile,
+	}
+
+	// owners of the profiles may arrange their galleries
+	env.UploadModel.Profiles = map[string]models.ProfileAccess{
+		models.ContentTypeCourse: env.CourseModel.GetCommentProfile,
+		models.ContentTypeUser:   env.UserModel.GetCommentProfile,
+	}
+
+	// account purge requires all domains (injected after their initialization)
+	env.UserModel.AnonymizeCourses = env.CourseModel.AnonymizeCourses
+	env.UserModel.AnonymizeComments = env.CommentModel.AnonymizeComments
+	env.UserModel.DeleteUploads = env.UploadModel.DeleteUserUploads
+	env.UserModel.RevokeSessions = authentication.RevokeAuths
+	env.VoteModel.Profiles = map[string]models.Votable{
+		models.ContentTypeCourse:  env.CourseModel,
+		models.ContentTypeComment: env.CommentModel,
+	}
+	env.UserModel.RevokeVotes = func(userOID primitive.ObjectID) error {
+		return env.VoteModel.RevokeUserVotes(userOID)
+	}
 	// inject analytics
 	// env.CourseModel.Tracker = env.Tracker
 
+	// reported content
+	env.ReportModel.Collection = mongoClient.Database(os.Getenv("DB_NAME")).Collection("reports")
+	env.ReportModel.GetUserNameOID = env.UserModel.GetUserNameOID
+	env.ReportModel.CanView = env.CommentModel.CanView
+	env.ReportModel.ProfileOwners = map[string]func(profileOID primitive.ObjectID) (primitive.ObjectID, error){
+		models.ContentTypeCourse: env.CourseModel.GetCreatorID,
+		models.ContentTypeUser:   func(profileOID primitive.ObjectID) (primitive.ObjectID, error) { return profileOID, nil },
+	}
+
+	// review queue - models are copied, hence this must be done after their initialization
+	env.Moderation = new(models.Moderation)
+	env.Moderation.SetConnections(mongoCollections)
+	env.Moderation.GetCredentials = env.UserModel.GetCredentials
+	env.Moderation.CountReports = env.ReportModel.CountReports
+	env.Moderation.ResolveReports = env.ReportModel.ResolveReports
+	env.ReportModel.Flag = env.Moderation.Flag
+	env.Moderation.Samplers = map[string]models.Sampler{
+		models.ContentTypeComment: env.CommentModel,
+		models.ContentTypeUpload:  env.UploadModel,
+		models.ContentTypeProfile: env.ReportModel,
+	}
+
 	return env
 }
 
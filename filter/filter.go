@@ -0,0 +1,210 @@
+package filter
+
+// content filter for user generated texts (comments, course texts, file descriptions)
+
+import (
+	"forza-garage/lookups"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Verdict is the filter's decision, ordered by severity
+type Verdict int
+
+// verdicts
+const (
+	Allow Verdict = iota // text is fine
+	Mask                 // offending parts were replaced
+	Hold                 // text must be reviewed by a moderator
+)
+
+// AllLanguages is passed if the author's language is unknown
+const AllLanguages int32 = -1
+
+// Result is returned by a content filter
+type Result struct {
+	Verdict Verdict
+	Text    string   // masked text (original if allowed)
+	Reasons []string // what was found, for moderators
+}
+
+// ContentFilter checks user generated texts
+type ContentFilter interface {
+	Check(text string, languageCode int32) Result
+}
+
+// WordFilter is the built-in filter using word lists and some spam heuristics
+type WordFilter struct {
+	mask       map[int32]map[string]bool // per language
+	hold       map[int32]map[string]bool
+	MaxLinks   int // more links are held
+	MaxRepeats int // longer runs of the same character are shortened
+}
+
+// leetspeak replacements, applied before looking-up the word lists
+var leet = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'@': 'a',
+	'$': 's',
+}
+
+var (
+	// words may contain leetspeak characters
+	wordPattern = regexp.MustCompile(`[\p{L}\p{N}@$]+`)
+	linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S+|\b[a-z0-9-]+\.(com|net|org|info|biz|ru|xyz|io|de|ch)\b`)
+)
+
+// NewWordFilter initializes the filter with the built-in word lists
+func NewWordFilter() *WordFilter {
+	f := &WordFilter{
+		mask:       make(map[int32]map[string]bool),
+		hold:       make(map[int32]map[string]bool),
+		MaxLinks:   2,
+		MaxRepeats: 3,
+	}
+
+	f.AddWords(lookups.LanguageEN, Mask, maskEN...)
+	f.AddWords(lookups.LanguageEN, Hold, holdEN...)
+	f.AddWords(lookups.LanguageDE, Mask, maskDE...)
+	f.AddWords(lookups.LanguageDE, Hold, holdDE...)
+
+	return f
+}
+
+// AddWords extends a language's word list
+func (f *WordFilter) AddWords(languageCode int32, verdict Verdict, words ...string) {
+
+	list := f.mask
+	if verdict == Hold {
+		list = f.hold
+	}
+
+	if list[languageCode] == nil {
+		list[languageCode] = make(map[string]bool)
+	}
+
+	for _, w := range words {
+		list[languageCode][normalize(w)] = true
+	}
+}
+
+// Check applies the word lists of the given language (english is always checked) and the spam heuristics
+func (f *WordFilter) Check(text string, languageCode int32) Result {
+
+	result := Result{Verdict: Allow, Text: text}
+
+	// links & spam are checked on the original text
+	if links := len(linkPattern.FindAllString(text, -1)); links > f.MaxLinks {
+		result.hold("too many links")
+	}
+
+	if spam(text) {
+		result.hold("repeated words")
+	}
+
+	// shortening runs of characters changes the text (eg. "!!!!!!!!!")
+	shortened := shortenRuns(result.Text, f.MaxRepeats)
+	if shortened != result.Text {
+		result.Text = shortened
+		result.mask("repeated characters")
+	}
+
+	languages := []int32{lookups.LanguageEN}
+	if languageCode == AllLanguages {
+		languages = []int32{lookups.LanguageEN, lookups.LanguageDE}
+	} else if languageCode != lookups.LanguageEN {
+		languages = append(languages, languageCode)
+	}
+
+	result.Text = wordPattern.ReplaceAllStringFunc(result.Text, func(word string) string {
+		n := normalize(word)
+		for _, l := range languages {
+			if f.hold[l][n] {
+				result.hold("word list")
+				return word
+			}
+			if f.mask[l][n] {
+				result.mask("word list")
+				return strings.Repeat("*", len([]rune(word)))
+			}
+		}
+		return word
+	})
+
+	return result
+}
+
+// internal helpers
+
+func (r *Result) mask(reason string) {
+	if r.Verdict < Mask {
+		r.Verdict = Mask
+	}
+	r.Reasons = append(r.Reasons, reason)
+}
+
+func (r *Result) hold(reason string) {
+	r.Verdict = Hold
+	r.Reasons = append(r.Reasons, reason)
+}
+
+// lower case, leetspeak replaced and repeated characters collapsed ("fuuu" -> "fu")
+func normalize(word string) string {
+	var b strings.Builder
+	var last rune
+	for _, r := range strings.ToLower(word) {
+		if l, ok := leet[r]; ok {
+			r = l
+		}
+		if r == last {
+			continue
+		}
+		b.WriteRune(r)
+		last = r
+	}
+	return b.String()
+}
+
+// shortens runs of the same character to a maximum length
+func shortenRuns(text string, max int) string {
+	var b strings.Builder
+	var last rune
+	run := 0
+	for _, r := range text {
+		if r == last {
+			run++
+		} else {
+			run = 1
+			last = r
+		}
+		// digits are part of numbers (eg. lap times)
+		if run > max && !unicode.IsDigit(r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// the same word makes up most of a longer text
+func spam(text string) bool {
+	words := wordPattern.FindAllString(strings.ToLower(text), -1)
+	if len(words) < 6 {
+		return false
+	}
+
+	count := make(map[string]int)
+	for _, w := range words {
+		count[w]++
+		if count[w]*2 > len(words) {
+			return true
+		}
+	}
+	return false
+}
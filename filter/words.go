@@ -0,0 +1,22 @@
+package filter
+
+// built-in word lists (normalized on load, see AddWords)
+// kept short on purpose, extended by the operators via AddWords
+
+// masked words
+var maskEN = []string{
+	"fuck", "fucking", "fucker", "shit", "bullshit", "bitch", "asshole", "bastard", "cunt", "wanker", "twat",
+}
+
+var maskDE = []string{
+	"scheisse", "scheiße", "arschloch", "arsch", "wichser", "fotze", "hurensohn", "fick", "ficken", "schlampe", "missgeburt",
+}
+
+// words requiring a review (slurs, threats)
+var holdEN = []string{
+	"nigger", "faggot", "retard", "kys", "killyourself",
+}
+
+var holdDE = []string{
+	"kanake", "schwuchtel", "spast", "vergasen",
+}
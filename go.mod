@@ -14,5 +14,6 @@ require (
 	github.com/twinj/uuid v1.0.0
 	go.mongodb.org/mongo-driver v1.4.4
 	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/image v0.0.0-20201208152932-35266b937fa6
 	gopkg.in/stretchr/testify.v1 v1.2.2 // indirect
 )
@@ -0,0 +1,37 @@
+package helpers
+
+import (
+	"forza-garage/logging"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// numeric settings are read from the environment when they're used
+// invalid values are replaced by the defaults, a warning is logged once per setting (logger of the environment)
+
+var warnedSettings sync.Map
+
+// IntSetting reads a numeric setting, values below min are invalid (missing settings get the default)
+func IntSetting(name string, defaultValue int, min int) int {
+	return int(Int64Setting(name, int64(defaultValue), int64(min)))
+}
+
+// Int64Setting reads a numeric setting, values below min are invalid (missing settings get the default)
+func Int64Setting(name string, defaultValue int64, min int64) int64 {
+
+	raw := os.Getenv(name)
+	if raw == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || value < min {
+		if _, warned := warnedSettings.LoadOrStore(name, true); !warned {
+			logging.Default().Warn("invalid setting, default applied", logging.Fields{"setting": name, "value": raw})
+		}
+		return defaultValue
+	}
+
+	return value
+}
@@ -4,21 +4,36 @@ import "fmt"
 
 // SystemError wraps external errors (such as DB) and lets the caller add
 // additional context information
+// the wrapped error is kept (errors.Is/As), the location is logged (see logging.Logger.Err)
 type SystemError struct {
 	Context string // eg. Function Name
 	Err     error
+	File    string
+	Line    int
 }
 
 func (se *SystemError) Error() string {
 	return fmt.Sprintf("%s: %v", se.Context, se.Err)
 }
 
+// Unwrap returns the wrapped error
+func (se *SystemError) Unwrap() error {
+	return se.Err
+}
+
+// Location returns where the error was wrapped
+func (se *SystemError) Location() (string, int, string) {
+	return se.File, se.Line, se.Context
+}
+
 // WrapError lets the caller add context information to another error
 // (eg. after receiving a DB error)
 func WrapError(err error, info string) *SystemError {
-	fmt.Println(err) // ToDo: Log
+	file, line, _ := trace(2)
 	return &SystemError{
 		Context: info,
 		Err:     err,
+		File:    file,
+		Line:    line,
 	}
 }
@@ -1,4 +1,4 @@
-package controllers
+package helpers
 
 import (
 	"net"
@@ -8,7 +8,8 @@ import (
 
 // https://golangbyexample.com/golang-ip-address-http-request/
 
-func getIP(r *http.Request) string {
+// GetIP returns the client's IP, proxies are expected to set X-Real-IP or X-Forwarded-For
+func GetIP(r *http.Request) string {
 	//Get IP from the X-REAL-IP header
 	ip := r.Header.Get("X-REAL-IP")
 	netIP := net.ParseIP(ip)
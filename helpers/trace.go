@@ -18,7 +18,12 @@ func FuncName() string {
 // Trace is used for reflection
 // eg. embedded into error wrappers or use in loggers
 func Trace() (string, int, string) {
-	pc, file, line, ok := runtime.Caller(1)
+	return trace(2)
+}
+
+// location of a caller (1: the function calling trace)
+func trace(skip int) (string, int, string) {
+	pc, file, line, ok := runtime.Caller(skip)
 	if !ok {
 		return "?", 0, "?"
 	}
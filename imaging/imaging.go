@@ -0,0 +1,162 @@
+package imaging
+
+// processing of uploaded images (screenshots, profile pictures)
+// the type is detected by the content (magic bytes), the client's file name is not trusted.
+// every image is decoded and encoded again, hence metadata (EXIF, GPS...) is never stored.
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // registers the decoder
+)
+
+// supported input formats
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+)
+
+// decoding is refused for larger images (decompression bombs)
+const maxPixels = 50 * 1000 * 1000
+
+// errors
+var (
+	ErrUnsupported = errors.New("unsupported image format")
+	ErrTooLarge    = errors.New("image dimensions too large")
+)
+
+// Spec describes a rendition to be produced
+type Spec struct {
+	Name   string
+	Width  int  // maximum
+	Height int  // maximum
+	Crop   bool // fill the box (center), the aspect ratio is kept otherwise
+}
+
+// Rendition is an encoded image
+type Rendition struct {
+	Name        string
+	Data        []byte
+	Width       int
+	Height      int
+	ContentType string
+	Ext         string // file extension incl. dot
+}
+
+// Sniff detects the format by the first bytes of a file
+func Sniff(head []byte) (string, bool) {
+	switch {
+	case len(head) >= 3 && head[0] == 0xFF && head[1] == 0xD8 && head[2] == 0xFF:
+		return FormatJPEG, true
+	case len(head) >= 8 && bytes.Equal(head[:8], []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG, true
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return FormatWebP, true
+	}
+	return "", false
+}
+
+// Process decodes an image and produces the renditions
+// images with transparency are encoded as PNG, all others as JPEG
+func Process(data []byte, specs []Spec) ([]Rendition, error) {
+
+	format, ok := Sniff(data)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+
+	// cameras save the orientation instead of rotating the pixels
+	if format == FormatJPEG {
+		src = orient(src, exifOrientation(data))
+	}
+
+	opaque := true
+	if o, ok := src.(interface{ Opaque() bool }); ok {
+		opaque = o.Opaque()
+	}
+
+	renditions := make([]Rendition, 0, len(specs))
+	for _, spec := range specs {
+		img := resize(src, spec)
+
+		var buf bytes.Buffer
+		rendition := Rendition{Name: spec.Name, Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+		if opaque {
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+			rendition.ContentType, rendition.Ext = "image/jpeg", ".jpg"
+		} else {
+			err = png.Encode(&buf, img)
+			rendition.ContentType, rendition.Ext = "image/png", ".png"
+		}
+		if err != nil {
+			return nil, err
+		}
+		rendition.Data = buf.Bytes()
+
+		renditions = append(renditions, rendition)
+	}
+
+	return renditions, nil
+}
+
+// internal helpers
+
+// scales an image into the spec's box, images are never enlarged
+func resize(src image.Image, spec Spec) image.Image {
+
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// area of the source to be used
+	area := bounds
+	if spec.Crop {
+		// largest centered area with the box's aspect ratio
+		if w*spec.Height > h*spec.Width {
+			cw := h * spec.Width / spec.Height
+			area = image.Rect(bounds.Min.X+(w-cw)/2, bounds.Min.Y, bounds.Min.X+(w-cw)/2+cw, bounds.Max.Y)
+		} else {
+			ch := w * spec.Height / spec.Width
+			area = image.Rect(bounds.Min.X, bounds.Min.Y+(h-ch)/2, bounds.Max.X, bounds.Min.Y+(h-ch)/2+ch)
+		}
+		w, h = area.Dx(), area.Dy()
+	}
+
+	// fit into the box
+	dw, dh := w, h
+	if dw > spec.Width {
+		dw, dh = spec.Width, h*spec.Width/w
+	}
+	if dh > spec.Height {
+		dw, dh = w*spec.Height/h, spec.Height
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, area, draw.Src, nil)
+
+	return dst
+}
@@ -0,0 +1,45 @@
+package logging
+
+import "github.com/gin-gonic/gin"
+
+// request-scoped loggers and IDs are kept in the gin context (see middleware.RequestLogger)
+const (
+	contextKey   = "logger"
+	requestIDKey = "requestID"
+)
+
+// FromContext returns the logger of a request, the default logger if there's none
+func FromContext(c *gin.Context) *Logger {
+	if c != nil {
+		if l, ok := c.Get(contextKey); ok {
+			if logger, ok := l.(*Logger); ok {
+				return logger
+			}
+		}
+	}
+	return Default()
+}
+
+// SetContext sets the logger of a request
+func SetContext(c *gin.Context, l *Logger) {
+	c.Set(contextKey, l)
+}
+
+// AddFields adds fields to the logger of a request (eg. the user ID once the token is verified)
+func AddFields(c *gin.Context, fields Fields) {
+	SetContext(c, FromContext(c).With(fields))
+}
+
+// RequestID returns the ID of a request, it's quoted by the clients to correlate errors with log entries
+func RequestID(c *gin.Context) string {
+	if c != nil {
+		return c.GetString(requestIDKey)
+	}
+	return ""
+}
+
+// SetRequestID sets the ID of a request and adds it to the request's logger
+func SetRequestID(c *gin.Context, id string) {
+	c.Set(requestIDKey, id)
+	AddFields(c, Fields{requestIDKey: id})
+}
@@ -0,0 +1,231 @@
+package logging
+
+// structured, leveled logging
+// entries are written as JSON lines in production (APP_ENV=PRD, read by the log collector) and as
+// readable lines in development. loggers are immutable: With returns a copy with additional fields,
+// hence a request's logger (see middleware) never changes the application's logger.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of an entry
+type Level int8
+
+// levels
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	}
+	return "error"
+}
+
+// ParseLevel converts the name of a level (LOG_LEVEL)
+func ParseLevel(name string) (Level, bool) {
+	for _, l := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if strings.EqualFold(name, l.String()) {
+			return l, true
+		}
+	}
+	return LevelInfo, false
+}
+
+// Fields are the context of an entry (eg. request ID, user ID)
+type Fields map[string]interface{}
+
+// Logger writes entries of its level and above
+type Logger struct {
+	out    *output // shared by the copies
+	level  Level
+	fields Fields
+}
+
+type output struct {
+	mu   sync.Mutex
+	w    io.Writer
+	json bool
+}
+
+// used until the environment is initialized and by nil loggers
+var std = New(os.Stderr, LevelInfo, false)
+
+// New returns a logger writing to w
+func New(w io.Writer, level Level, json bool) *Logger {
+	return &Logger{out: &output{w: w, json: json}, level: level}
+}
+
+// NewFromEnv returns the logger configured by APP_ENV (JSON in PRD) and LOG_LEVEL (info by default)
+func NewFromEnv() *Logger {
+	level, ok := ParseLevel(os.Getenv("LOG_LEVEL"))
+	if !ok && os.Getenv("LOG_LEVEL") != "" {
+		std.Warn("invalid LOG_LEVEL", Fields{"value": os.Getenv("LOG_LEVEL")})
+	}
+	return New(os.Stdout, level, os.Getenv("APP_ENV") == "PRD")
+}
+
+// Default returns the logger of packages without injection
+func Default() *Logger {
+	return std
+}
+
+// SetDefault replaces the default logger (environment)
+func SetDefault(l *Logger) {
+	if l != nil {
+		std = l
+	}
+}
+
+// With returns a logger adding the fields to its entries
+func (l *Logger) With(fields Fields) *Logger {
+	l = l.get()
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{out: l.out, level: l.level, fields: merged}
+}
+
+// Debug writes an entry for developers
+func (l *Logger) Debug(msg string, fields ...Fields) {
+	l.get().write(LevelDebug, msg, fields)
+}
+
+// Info writes an entry about regular events (eg. start, scheduled jobs)
+func (l *Logger) Info(msg string, fields ...Fields) {
+	l.get().write(LevelInfo, msg, fields)
+}
+
+// Warn writes an entry about problems which were handled (eg. skipped items)
+func (l *Logger) Warn(msg string, fields ...Fields) {
+	l.get().write(LevelWarn, msg, fields)
+}
+
+// Error writes an entry about failed operations
+func (l *Logger) Error(msg string, fields ...Fields) {
+	l.get().write(LevelError, msg, fields)
+}
+
+// Err writes an error, the location is added if it's known (see helpers.SystemError)
+func (l *Logger) Err(err error, fields ...Fields) {
+	if err == nil {
+		return
+	}
+
+	f := Fields{}
+	var located interface {
+		Location() (file string, line int, function string)
+	}
+	if errors.As(err, &located) {
+		file, line, function := located.Location()
+		f["file"] = filepath.Base(filepath.Dir(file)) + "/" + filepath.Base(file)
+		f["line"] = line
+		f["func"] = function
+	}
+
+	l.get().write(LevelError, err.Error(), append([]Fields{f}, fields...))
+}
+
+// internal helpers
+
+func (l *Logger) get() *Logger {
+	if l == nil || l.out == nil {
+		return std
+	}
+	return l
+}
+
+func (l *Logger) write(level Level, msg string, fields []Fields) {
+
+	if level < l.level {
+		return
+	}
+
+	// fields of the entry replace the logger's ones
+	all := make(Fields, len(l.fields))
+	for k, v := range l.fields {
+		all[k] = v
+	}
+	for _, f := range fields {
+		for k, v := range f {
+			all[k] = v
+		}
+	}
+
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	now := time.Now()
+	if l.out.json {
+		buf.WriteString(`{"ts":`)
+		writeJSON(&buf, now.UTC().Format(time.RFC3339Nano))
+		buf.WriteString(`,"level":`)
+		writeJSON(&buf, level.String())
+		buf.WriteString(`,"msg":`)
+		writeJSON(&buf, msg)
+		for _, k := range keys {
+			buf.WriteByte(',')
+			writeJSON(&buf, k)
+			buf.WriteByte(':')
+			writeJSON(&buf, value(all[k]))
+		}
+		buf.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&buf, "%s %-5s %s", now.Format("2006-01-02 15:04:05.000"), strings.ToUpper(level.String()), msg)
+		for _, k := range keys {
+			fmt.Fprintf(&buf, " %s=%v", k, value(all[k]))
+		}
+		buf.WriteByte('\n')
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+// errors are encoded by their message (json encodes them as empty objects)
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case fmt.Stringer:
+		return t.String()
+	}
+	return v
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
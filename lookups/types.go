@@ -17,6 +17,7 @@ const (
 	LTcourseStyle
 	LTseries
 	LTcarClass
+	LTreportReason
 )
 
 // LookupType returns names of the available code types
@@ -48,6 +49,8 @@ func LookupType(lt int) string {
 		str = "series"
 	case lt == LTcarClass:
 		str = "car class"
+	case lt == LTreportReason:
+		str = "report reason"
 	}
 
 	return str
@@ -74,3 +74,12 @@ const (
 	CarClassOpen
 	CarClassMixed
 )
+
+// report reason
+const (
+	ReportReasonSpam = iota
+	ReportReasonOffensive
+	ReportReasonHarassment
+	ReportReasonCheating
+	ReportReasonOther
+)
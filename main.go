@@ -1,10 +1,11 @@
 package main
 
 import (
-	"fmt"
 	"forza-garage/authentication"
 	"forza-garage/database"
 	"forza-garage/environment"
+	"forza-garage/helpers"
+	"forza-garage/logging"
 	"log"
 	"os"
 	"time"
@@ -14,7 +15,7 @@ import (
 )
 
 var (
-	router = gin.Default()
+	router = gin.New() // access log & recovery: see middleware
 )
 
 // wird VOR der Programmausführung (main) gerufen
@@ -61,6 +62,18 @@ func main() {
 	requestTicker := time.NewTicker(time.Duration(1 * time.Minute)) // 5 * time.Second
 	done := make(chan bool, 1)                                      // done channel can be shared, it's only used to stop the listener (select-loop)
 
+	// accounts scheduled for deletion are purged after their grace period (and expired resumable uploads)
+	purgeTicker := time.NewTicker(time.Duration(1 * time.Hour))
+
+	// vote counters are maintained incrementally and recounted regularly
+	reconcileHours := helpers.IntSetting("VOTE_RECONCILE_HOURS", 24, 1)
+	reconcileTicker := time.NewTicker(time.Duration(reconcileHours) * time.Hour)
+	var reconciledTS time.Time // profiles voted since the last complete run are recounted (all after a restart)
+
+	// blobs without uploads (orphans) are removed regularly
+	gcHours := helpers.IntSetting("UPLOAD_GC_HOURS", 24, 1)
+	gcTicker := time.NewTicker(time.Duration(gcHours) * time.Hour)
+
 	go func() {
 		for {
 			select {
@@ -69,6 +82,23 @@ func main() {
 			//case t := <-ticker.C:
 			case <-requestTicker.C:
 				environment.Env.Requests.Flush()
+				environment.Env.Limiter.Flush(time.Hour)
+			case <-purgeTicker.C:
+				environment.Env.UserModel.PurgeAccounts()
+				environment.Env.UploadModel.PurgeResumables()
+			case t := <-reconcileTicker.C:
+				if environment.Env.VoteModel.Reconcile(reconciledTS) {
+					reconciledTS = t
+				}
+			case <-gcTicker.C:
+				report, err := environment.Env.UploadModel.CollectGarbage(false)
+				if err != nil {
+					environment.Env.Log.Err(err)
+					continue
+				}
+				environment.Env.Log.Info("blob garbage collected", logging.Fields{
+					"blobs": report.Blobs, "orphans": len(report.Orphans), "missing": len(report.Missing), "refsFixed": report.RefsFixed,
+				})
 			}
 		}
 	}()
@@ -100,12 +130,12 @@ func main() {
 		}
 	*/
 
-	fmt.Println("Forza-Garage running...")
+	environment.Env.Log.Info("Forza-Garage running...", logging.Fields{"env": os.Getenv("APP_ENV"), "port": os.Getenv("API_PORT")})
 	handleRequests()
 
 	// ToDO: Wird das überhaupt aufgerufen? => NEIN
 	// Muss das evtl. in einen SigTerm-Handler?
-	fmt.Println("shutdown test")
+	environment.Env.Log.Info("shutting down")
 
 	// save pending analytics to influxDB
 	// placed here, because defer causes NIL panic
@@ -113,6 +143,9 @@ func main() {
 	environment.Env.Tracker.SearchAPI.WriteAPI.Flush()
 
 	requestTicker.Stop()
+	purgeTicker.Stop()
+	reconcileTicker.Stop()
+	gcTicker.Stop()
 	// replTicker.Stop()
 	done <- true
 
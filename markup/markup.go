@@ -0,0 +1,160 @@
+package markup
+
+// lightweight markup of user generated texts (comments)
+// the raw input is never rendered, every part of the output is escaped
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Reference is a resolved mention or course reference
+type Reference struct {
+	ID   string // OID of the referenced user or course
+	Name string // login name or share code as written
+}
+
+// Resolver looks-up the referenced objects (injected by the caller)
+// unresolved references are rendered as plain text
+type Resolver struct {
+	User   func(loginName string) (id string, ok bool)
+	Course func(sharingCode int32) (id string, ok bool)
+}
+
+// Result is the rendered text and its references
+type Result struct {
+	HTML     string
+	Mentions []Reference // distinct users
+	Courses  []Reference // distinct courses
+}
+
+// supported syntax: **bold**, *italics* or _italics_, [text](https://...),
+// @loginName and #123456789 or #123 456 789 (Forza share code)
+// mentions start a word (e-mail addresses aren't mentions), the character in front is part of the match
+// since there's no look-behind; names are matched completely, their length is checked when rendered
+var tokenPattern = regexp.MustCompile(
+	`\[(?P<label>[^\]\n]{1,100})\]\((?P<url>https?://[^\s)]+)\)` +
+		`|\*\*(?P<bold>[^*\n]+)\*\*` +
+		`|\*(?P<italic>[^*\n]+)\*` +
+		`|\b_(?P<underscore>[^_\n]+)_\b` +
+		`|(?P<before>^|[^\p{L}\p{N}_.@-])@(?P<mention>[\p{L}\p{N}_.-]+)` +
+		`|#(?P<course>\d{3} ?\d{3} ?\d{3})\b`)
+
+// length of the login names
+const (
+	minNameLength = 3
+	maxNameLength = 30
+)
+
+// Render parses the markup of a text into sanitized HTML
+func Render(text string, resolver Resolver) Result {
+
+	var (
+		result  Result
+		builder strings.Builder
+	)
+
+	mentioned := make(map[string]bool)
+	referenced := make(map[string]bool)
+	group := func(match []int, name string) (string, bool) {
+		i := tokenPattern.SubexpIndex(name)
+		if match[2*i] < 0 {
+			return "", false
+		}
+		return text[match[2*i]:match[2*i+1]], true
+	}
+
+	last := 0
+	for _, match := range tokenPattern.FindAllStringSubmatchIndex(text, -1) {
+		builder.WriteString(escape(text[last:match[0]]))
+		last = match[1]
+
+		if label, ok := group(match, "label"); ok {
+			link, _ := group(match, "url")
+			if u, err := url.Parse(link); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+				builder.WriteString(`<a href="` + html.EscapeString(u.String()) + `" rel="nofollow noopener" target="_blank">` + escape(label) + `</a>`)
+			} else {
+				builder.WriteString(escape(text[match[0]:match[1]]))
+			}
+			continue
+		}
+		if bold, ok := group(match, "bold"); ok {
+			builder.WriteString("<strong>" + escape(bold) + "</strong>")
+			continue
+		}
+		if italic, ok := group(match, "italic"); ok {
+			builder.WriteString("<em>" + escape(italic) + "</em>")
+			continue
+		}
+		if italic, ok := group(match, "underscore"); ok {
+			builder.WriteString("<em>" + escape(italic) + "</em>")
+			continue
+		}
+		if name, ok := group(match, "mention"); ok {
+			before, _ := group(match, "before")
+			builder.WriteString(escape(before))
+			// a full stop ends the sentence, not the name
+			trimmed := strings.TrimRight(name, ".")
+			last -= len(name) - len(trimmed)
+			if length := utf8.RuneCountInString(trimmed); length < minNameLength || length > maxNameLength {
+				builder.WriteString("@" + escape(trimmed))
+			} else if id, found := lookupUser(resolver, trimmed); found {
+				builder.WriteString(`<a class="mention" href="/users/` + id + `">@` + escape(trimmed) + `</a>`)
+				if !mentioned[id] {
+					mentioned[id] = true
+					result.Mentions = append(result.Mentions, Reference{ID: id, Name: trimmed})
+				}
+			} else {
+				builder.WriteString("@" + escape(trimmed))
+			}
+			continue
+		}
+		if code, ok := group(match, "course"); ok {
+			if id, found := lookupCourse(resolver, code); found {
+				builder.WriteString(`<a class="course" href="/courses/` + id + `">#` + escape(code) + `</a>`)
+				if !referenced[id] {
+					referenced[id] = true
+					result.Courses = append(result.Courses, Reference{ID: id, Name: code})
+				}
+			} else {
+				builder.WriteString("#" + escape(code))
+			}
+			continue
+		}
+	}
+	builder.WriteString(escape(text[last:]))
+
+	result.HTML = builder.String()
+	return result
+}
+
+// internal helpers
+
+// escapes plain text and keeps its line breaks
+func escape(text string) string {
+	return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>")
+}
+
+func lookupUser(resolver Resolver, loginName string) (string, bool) {
+	if resolver.User == nil {
+		return "", false
+	}
+	id, ok := resolver.User(loginName)
+	return html.EscapeString(id), ok
+}
+
+func lookupCourse(resolver Resolver, code string) (string, bool) {
+	if resolver.Course == nil {
+		return "", false
+	}
+	sharingCode, err := strconv.Atoi(strings.ReplaceAll(code, " ", ""))
+	if err != nil {
+		return "", false
+	}
+	id, ok := resolver.Course(int32(sharingCode))
+	return html.EscapeString(id), ok
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"forza-garage/helpers"
+	"forza-garage/logging"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/twinj/uuid"
+)
+
+// RequestIDHeader is passed by proxies/clients and returned with every response
+const RequestIDHeader = "X-Request-ID"
+
+// RequestLogger provides the handlers with a logger carrying the request's fields (see logging.FromContext)
+// and writes the access log once the request is handled. the user ID is added by the token middleware.
+func RequestLogger(logger *logging.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		logging.SetContext(c, logger.With(logging.Fields{
+			"method": c.Request.Method,
+			"route":  c.FullPath(),
+		}))
+
+		// IDs of proxies are kept to correlate their logs
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewV4().String()
+		}
+		logging.SetRequestID(c, requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		c.Next()
+
+		size := c.Writer.Size()
+		if size < 0 { // nothing written
+			size = 0
+		}
+		fields := logging.Fields{
+			"path":      c.Request.URL.Path,
+			"status":    c.Writer.Status(),
+			"latencyMs": float64(time.Since(start).Microseconds()) / 1000,
+			"bytes":     size,
+			"ip":        helpers.GetIP(c.Request),
+		}
+		if c.Writer.Status() >= 500 {
+			logging.FromContext(c).Warn("request", fields)
+		} else {
+			logging.FromContext(c).Info("request", fields)
+		}
+	}
+}
+
+// incoming IDs are written to the logs, hence they're limited to UUID-like values
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"fmt"
+	"forza-garage/controllers"
+	"forza-garage/logging"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recovery answers panics of the handlers with the std ErrorResponse (incl. the request ID)
+// instead of dropping the connection. the stack is logged with the request's fields.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+
+			logging.FromContext(c).Error(fmt.Sprint("panic: ", rec), logging.Fields{"stack": string(debug.Stack())})
+
+			// the response can't be changed once it's (partially) sent
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+
+			var apiError controllers.ErrorResponse
+			apiError.Code = controllers.SystemError
+			apiError.Message = apiError.String(apiError.Code)
+			apiError.RequestID = logging.RequestID(c)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, apiError)
+		}()
+
+		c.Next()
+	}
+}
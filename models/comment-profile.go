@@ -0,0 +1,178 @@
+package models
+
+import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/helpers"
+	"forza-garage/lookups"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// comment sections of the commentable domains (courses, user profiles, uploads)
+// each domain applies its own visibility rules, the owner may disable the section
+
+// CommentProfile is a commentable profile, resolved by its domain
+type CommentProfile struct {
+	ProfileID  primitive.ObjectID // commented profile, for uploads the profile the file belongs to
+	OwnerID    primitive.ObjectID // may pin comments and disable the section
+	ParentType string             // uploads only: type of the profile the file belongs to (its visibility applies)
+}
+
+// ProfileAccess resolves a profile and checks the user's permission to view it
+// the key is the profile's OID, or the file name of uploads
+type ProfileAccess func(profileKey string, userID string) (*CommentProfile, error)
+
+// commentSetting is saved while a profile's comments are disabled
+type commentSetting struct {
+	Key         string             `bson:"_id"` // profileType_profileKey
+	ProfileType string             `bson:"profileType"`
+	ProfileKey  string             `bson:"profileKey"`
+	DisabledTS  time.Time          `bson:"disabledTS"`
+	DisabledID  primitive.ObjectID `bson:"disabledID"`
+}
+
+// SetCommentsDisabled switches the comment section of a profile off or on (owner or admin)
+// existing comments are hidden while the section is disabled, but not deleted
+func (m CommentModel) SetCommentsDisabled(profileType string, profileKey string, disabled bool, executiveUserID string) error {
+
+	profile, err := m.resolveProfile(profileType, profileKey, executiveUserID)
+	if err != nil {
+		return err
+	}
+
+	credentials := m.GetCredentials(executiveUserID, false)
+	if profile.OwnerID != credentials.UserID && credentials.RoleCode != lookups.UserRoleAdmin {
+		return apperror.ErrDenied
+	}
+
+	key := profileType + "_" + profileKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	if !disabled {
+		_, err = m.Settings.DeleteOne(ctx, bson.D{{Key: "_id", Value: key}})
+		if err != nil {
+			return helpers.WrapError(err, helpers.FuncName())
+		}
+		return nil
+	}
+
+	setting := commentSetting{
+		Key:         key,
+		ProfileType: profileType,
+		ProfileKey:  profileKey,
+		DisabledTS:  time.Now(),
+		DisabledID:  credentials.UserID,
+	}
+
+	_, err = m.Settings.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: key}},
+		bson.D{{Key: "$setOnInsert", Value: setting}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return nil
+}
+
+// CanView checks if a user may see some content (eg. to report it)
+// comments and replies must be listed, profiles and uploads are checked by their domain
+func (m CommentModel) CanView(contentType string, contentID string, userID string) error {
+
+	if contentType != ContentTypeComment {
+		_, err := m.resolveProfile(contentType, contentID, userID)
+		return err
+	}
+
+	commentOID, err := primitive.ObjectIDFromHex(contentID)
+	if err != nil {
+		return apperror.ErrNoData
+	}
+
+	thread, target, err := m.findThread(commentOID)
+	if err != nil {
+		return err
+	}
+
+	// replies of hidden comments are hidden as well (but not those of deleted ones)
+	if target.Deleted {
+		return apperror.ErrNoData
+	}
+	for _, c := range []*Comment{thread, target} {
+		if c.StatusCode == lookups.CommentStatusPending || c.StatusCode == lookups.CommentStatusBlocked {
+			return apperror.ErrNoData
+		}
+	}
+
+	profileType, profileKey := profileKeyOf(thread)
+	_, err = m.resolveProfile(profileType, profileKey, userID)
+	return err
+}
+
+// internal helpers
+
+// resolves a profile and checks if its comment section may be read or written
+func (m CommentModel) openSection(profileType string, profileKey string, userID string) (*CommentProfile, error) {
+
+	profile, err := m.resolveProfile(profileType, profileKey, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	count, err := m.Settings.CountDocuments(ctx, bson.D{{Key: "_id", Value: profileType + "_" + profileKey}})
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+	if count > 0 {
+		return nil, ErrCommentsDisabled
+	}
+
+	return profile, nil
+}
+
+// applies the visibility rules of the profile's domain (uploads: rules of the profile they belong to)
+func (m CommentModel) resolveProfile(profileType string, profileKey string, userID string) (*CommentProfile, error) {
+
+	access, ok := m.Profiles[profileType]
+	if !ok {
+		return nil, ErrInvalidProfile
+	}
+
+	profile, err := access(profileKey, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if profile.ParentType != "" {
+		parentAccess, ok := m.Profiles[profile.ParentType]
+		if !ok {
+			return nil, apperror.ErrNoData
+		}
+		_, err = parentAccess(profile.ProfileID.Hex(), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return profile, nil
+}
+
+// type and key of the profile a comment belongs to (file name for uploads)
+func profileKeyOf(comment *Comment) (string, string) {
+	if comment.ProfileType == nil {
+		return "", ""
+	}
+	if comment.FileName != nil {
+		return *comment.ProfileType, *comment.FileName
+	}
+	return *comment.ProfileType, comment.ProfileID.Hex()
+}
@@ -0,0 +1,333 @@
+package models
+
+import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/filter"
+	"forza-garage/helpers"
+	"forza-garage/lookups"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// editing, deleting and pinning of comments and their (embedded) replies
+
+// DeletedCommentText replaces the text of a deleted comment which still has replies (tombstone)
+const DeletedCommentText = "[deleted]"
+
+// Update changes the text of a comment or reply (author or admin)
+// the previous text is kept in the history
+func (m CommentModel) Update(commentID string, text string, executiveUserID string) error {
+
+	commentOID, err := primitive.ObjectIDFromHex(commentID)
+	if err != nil {
+		return apperror.ErrNoData
+	}
+
+	thread, target, err := m.findThread(commentOID)
+	if err != nil {
+		return err
+	}
+
+	if target.Deleted {
+		return ErrCommentDeleted
+	}
+
+	credentials := m.GetCredentials(executiveUserID, false)
+	if target.CreatedID != credentials.UserID && credentials.RoleCode != lookups.UserRoleAdmin {
+		return apperror.ErrDenied
+	}
+
+	checked := m.Filter.Check(text, credentials.LanguageCode)
+	text = checked.Text
+
+	// nothing to do
+	if target.Comment == text {
+		return nil
+	}
+
+	now := time.Now()
+
+	// the revision is taken before the target is changed
+	revision := revisionOf(target)
+
+	html, mentions := m.render(text)
+
+	// users mentioned before were notified already
+	for i := range mentions {
+		for _, previous := range target.Mentions {
+			if previous.UserID == mentions[i].UserID {
+				mentions[i].Notified = previous.Notified
+			}
+		}
+	}
+
+	set := bson.D{
+		{Key: "comment", Value: text},
+		{Key: "commentHtml", Value: html},
+		{Key: "modifiedTS", Value: now},
+		{Key: "modifiedID", Value: credentials.UserID},
+		{Key: "modifiedName", Value: credentials.LoginName},
+	}
+
+	// edits by members are moderated again, held ones in any case
+	if (os.Getenv("COMMENT_MODERATION") == "YES" && credentials.RoleCode != lookups.UserRoleAdmin) || checked.Verdict == filter.Hold {
+		target.StatusCode = lookups.CommentStatusPending
+		set = append(set,
+			bson.E{Key: "statusCD", Value: lookups.CommentStatusPending},
+			bson.E{Key: "statusTS", Value: now},
+			bson.E{Key: "statusID", Value: credentials.UserID},
+			bson.E{Key: "statusName", Value: credentials.LoginName})
+	}
+
+	target.Mentions = mentions
+	mentioned := markMentions(target)
+	set = append(set, bson.E{Key: "mentions", Value: mentions})
+
+	err = m.updateThread(thread, target, set, bson.D{{Key: "history", Value: revision}})
+	if err != nil {
+		return err
+	}
+
+	parentID, parentType := parentOf(thread, target)
+	m.notifyMentions(target, mentioned, parentID, parentType)
+
+	return nil
+}
+
+// Delete removes a comment or reply (author or admin)
+// comments with replies are replaced by a tombstone, so the thread remains readable
+func (m CommentModel) Delete(commentID string, executiveUserID string) error {
+
+	commentOID, err := primitive.ObjectIDFromHex(commentID)
+	if err != nil {
+		return apperror.ErrNoData
+	}
+
+	thread, target, err := m.findThread(commentOID)
+	if err != nil {
+		return err
+	}
+
+	if target.Deleted {
+		return ErrCommentDeleted
+	}
+
+	credentials := m.GetCredentials(executiveUserID, false)
+	if target.CreatedID != credentials.UserID && credentials.RoleCode != lookups.UserRoleAdmin {
+		return apperror.ErrDenied
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	// replies are removed from the array
+	if target != thread {
+		// a tombstone is removed together with its last reply
+		if thread.Deleted && len(thread.Replies) == 1 {
+			_, err = m.Collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: thread.ID}})
+			if err != nil {
+				return helpers.WrapError(err, helpers.FuncName())
+			}
+			return nil
+		}
+
+		_, err = m.Collection.UpdateOne(ctx,
+			bson.D{{Key: "_id", Value: thread.ID}},
+			bson.D{{Key: "$pull", Value: bson.D{{Key: "replies", Value: bson.D{{Key: "_id", Value: commentOID}}}}}})
+		if err != nil {
+			return helpers.WrapError(err, helpers.FuncName())
+		}
+		return nil
+	}
+
+	if len(thread.Replies) == 0 {
+		_, err = m.Collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: thread.ID}})
+		if err != nil {
+			return helpers.WrapError(err, helpers.FuncName())
+		}
+		return nil
+	}
+
+	// tombstone - the previous texts are removed as well (deleted content is not kept)
+	set := bson.D{
+		{Key: "comment", Value: DeletedCommentText},
+		{Key: "commentHtml", Value: DeletedCommentText},
+		{Key: "mentions", Value: bson.A{}},
+		{Key: "history", Value: bson.A{}},
+		{Key: "deleted", Value: true},
+		{Key: "modifiedTS", Value: time.Now()},
+		{Key: "modifiedID", Value: credentials.UserID},
+		{Key: "modifiedName", Value: credentials.LoginName},
+	}
+
+	return m.updateThread(thread, target, set, nil)
+}
+
+// SetPinned pins or unpins a comment (owner of the commented profile or admin)
+// by convention, replies can't be pinned
+func (m CommentModel) SetPinned(commentID string, pinned bool, executiveUserID string) error {
+
+	commentOID, err := primitive.ObjectIDFromHex(commentID)
+	if err != nil {
+		return apperror.ErrNoData
+	}
+
+	thread, target, err := m.findThread(commentOID)
+	if err != nil {
+		return err
+	}
+
+	if target != thread || thread.ProfileType == nil {
+		return apperror.ErrDenied
+	}
+
+	if thread.Deleted {
+		return ErrCommentDeleted
+	}
+
+	credentials := m.GetCredentials(executiveUserID, false)
+	if credentials.RoleCode != lookups.UserRoleAdmin {
+		profileType, profileKey := profileKeyOf(thread)
+		profile, err := m.resolveProfile(profileType, profileKey, executiveUserID)
+		if err != nil {
+			return err
+		}
+		if profile.OwnerID != credentials.UserID {
+			return apperror.ErrDenied
+		}
+	}
+
+	// unpinned comments don't have the field (omitempty)
+	var update bson.D
+	if pinned {
+		update = bson.D{{Key: "$set", Value: bson.D{{Key: "pinned", Value: true}}}}
+	} else {
+		update = bson.D{{Key: "$unset", Value: bson.D{{Key: "pinned", Value: ""}}}}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	result, err := m.Collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: thread.ID}}, update)
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrNoData // document might have been deleted
+	}
+
+	return nil
+}
+
+// internal helpers
+
+// reads the document containing a comment or reply
+// target points to the comment itself or to the respective reply of the thread
+func (m CommentModel) findThread(commentOID primitive.ObjectID) (thread *Comment, target *Comment, err error) {
+
+	filter := bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "_id", Value: commentOID}},
+			bson.D{{Key: "replies._id", Value: commentOID}},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	thread = new(Comment)
+
+	err = m.Collection.FindOne(ctx, filter).Decode(thread)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, apperror.ErrNoData
+		}
+		return nil, nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	if thread.ID == commentOID {
+		return thread, thread, nil
+	}
+
+	for i := range thread.Replies {
+		if thread.Replies[i].ID == commentOID {
+			return thread, &thread.Replies[i], nil
+		}
+	}
+
+	// reply removed in the meantime
+	return nil, nil, apperror.ErrNoData
+}
+
+// applies $set and $push to a comment or to a reply (positional operator)
+func (m CommentModel) updateThread(thread *Comment, target *Comment, set bson.D, push bson.D) error {
+
+	filter := bson.D{{Key: "_id", Value: thread.ID}}
+
+	if target != thread {
+		filter = bson.D{{Key: "replies._id", Value: target.ID}}
+		for i := range set {
+			set[i].Key = "replies.$." + set[i].Key
+		}
+		for i := range push {
+			push[i].Key = "replies.$." + push[i].Key
+		}
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: set},
+	}
+	if len(push) > 0 {
+		update = append(update, bson.E{Key: "$push", Value: push})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	result, err := m.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrNoData // document might have been deleted
+	}
+
+	return nil
+}
+
+// commented profile of a comment, parent comment of a reply
+func parentOf(thread *Comment, target *Comment) (primitive.ObjectID, *string) {
+	if target == thread {
+		return thread.ProfileID, thread.ProfileType
+	}
+	parentType := ContentTypeComment
+	return thread.ID, &parentType
+}
+
+// current version of a comment, saved to the history before it is changed
+func revisionOf(comment *Comment) CommentRevision {
+
+	revision := CommentRevision{
+		WrittenTS:   primitive.ObjectID.Timestamp(comment.ID),
+		WrittenID:   comment.CreatedID,
+		WrittenName: comment.CreatedName,
+		Comment:     comment.Comment,
+	}
+
+	if comment.ModifiedTS != nil {
+		revision.WrittenTS = *comment.ModifiedTS
+		revision.WrittenID = comment.ModifiedID
+		if comment.ModifiedName != nil {
+			revision.WrittenName = *comment.ModifiedName
+		}
+	}
+
+	return revision
+}
@@ -2,10 +2,11 @@ package models
 
 import (
 	"context"
-	"fmt"
 	"forza-garage/apperror"
+	"forza-garage/filter"
 	"forza-garage/helpers"
 	"forza-garage/lookups"
+	"forza-garage/markup"
 	"os"
 	"strings"
 	"time"
@@ -26,6 +27,7 @@ type Comment struct {
 	ID           primitive.ObjectID `json:"id" bson:"_id"`                                      // comment or reply ID
 	ProfileID    primitive.ObjectID `json:"profileId,omitempty" bson:"profileId,omitempty"`     // required for comments
 	ProfileType  *string            `json:"profileType,omitempty" bson:"profileType,omitempty"` // required for comments
+	FileName     *string            `json:"fileName,omitempty" bson:"fileName,omitempty"`       // commented file (profile type upload)
 	CreatedTS    time.Time          `json:"createdTS" bson:"-"`                                 // extracted from OID
 	CreatedID    primitive.ObjectID `json:"createdID" bson:"createdID"`
 	CreatedName  string             `json:"createdName" bson:"createdName"`
@@ -43,8 +45,28 @@ type Comment struct {
 	StatusID     primitive.ObjectID `json:"statusID" bson:"statusID"`
 	StatusName   string             `json:"statusName" bson:"statusName"`
 	Pinned       *bool              `json:"pinned,omitempty" bson:"pinned,omitempty"`
-	Comment      string             `json:"comment" bson:"comment"`
-	Replies      []Comment          `json:"replies,omitempty" bson:"replies,omitempty"` // applies to GET-requests only
+	Deleted      bool               `json:"deleted,omitempty" bson:"deleted,omitempty"`       // tombstone of a deleted comment with replies
+	Comment      string             `json:"comment" bson:"comment"`                           // raw input (never rendered)
+	CommentHTML  string             `json:"commentHtml" bson:"commentHtml"`                   // sanitized markup
+	Mentions     []CommentMention   `json:"mentions,omitempty" bson:"mentions,omitempty"`     // resolved @loginName references
+	History      []CommentRevision  `json:"history,omitempty" bson:"history,omitempty"`       // previous texts (edits)
+	ReplyCount   int32              `json:"replyCount,omitempty" bson:"replyCount,omitempty"` // calculated by queries (not persisted)
+	Replies      []Comment          `json:"replies,omitempty" bson:"replies,omitempty"`       // applies to GET-requests only
+}
+
+// CommentRevision keeps the previous text of an edited comment or reply
+type CommentRevision struct {
+	WrittenTS   time.Time          `json:"writtenTS" bson:"writtenTS"` // when this version was written
+	WrittenID   primitive.ObjectID `json:"writtenID" bson:"writtenID"`
+	WrittenName string             `json:"writtenName" bson:"writtenName"`
+	Comment     string             `json:"comment" bson:"comment"`
+}
+
+// CommentMention references a user mentioned in a comment or reply (@loginName)
+type CommentMention struct {
+	UserID   primitive.ObjectID `json:"userID" bson:"userID"`
+	UserName string             `json:"userName" bson:"userName"`
+	Notified bool               `json:"-" bson:"notified"` // mentions in pending content are notified after its approval
 }
 
 // CommentListItem is the reduced data structure used for lists (eg. comment sections of profiles)
@@ -55,14 +77,32 @@ type CommentListItem struct {
 	CreatedID   primitive.ObjectID `json:"createdID"`
 	CreatedName string             `json:"createdName"`
 	Modified    bool               `json:"modified"`
+	Deleted     bool               `json:"deleted"`
 	UpVotes     int32              `json:"upVotes"`
 	DownVotes   int32              `json:"downVotes"`
+	Rating      float32            `json:"rating"`
 	UserVote    int32              `json:"userVote" bson:"-"`
 	Pinned      *bool              `json:"pinned,omitempty"`
 	Comment     string             `json:"comment"`
+	CommentHTML string             `json:"commentHtml"`
+	Mentions    []CommentMention   `json:"mentions,omitempty"`
+	ReplyCount  int32              `json:"replyCount"`
 	Replies     []CommentListItem  `json:"replies,omitempty"`
 }
 
+// sort orders of comment lists (pinned comments are always listed first)
+const (
+	CommentSortNew = "new" // most recent first (default)
+	CommentSortTop = "top" // best rated first (lower bound of the rating)
+)
+
+// ReplyList is a page of a comment's replies
+type ReplyList struct {
+	ReplyCount int32             `json:"replyCount"`
+	Replies    []CommentListItem `json:"replies"`
+	NextCursor string            `json:"nextCursor,omitempty"` // passed to read the next page (none if this is the last one)
+}
+
 // CommentModel provides the logic to the interface and access to the database
 type CommentModel struct {
 	Collection *mongo.Collection
@@ -70,7 +110,15 @@ type CommentModel struct {
 	// somit muss das nicht der Controller machen
 	GetUserNameOID func(userID primitive.ObjectID) (string, error)
 	GetCredentials func(userId string, loadFriendlist bool) *Credentials
-	GetUserVotes   func(domain string, userID string) ([]UserVote, error) // injected from votes model
+	GetUserVotes   func(userID string, profileOIDs []primitive.ObjectID) ([]UserVote, error) // injected from votes model
+	Filter         filter.ContentFilter
+	// mentions and course references are resolved when the markup is rendered
+	GetUserIDByName      func(loginName string) (primitive.ObjectID, error)
+	GetCourseIDBySharing func(sharingCode int32) (primitive.ObjectID, error)
+	Notify               func(notification Notification) error // injected from notification model
+	// commentable profiles per profile type (visibility rules, owners)
+	Profiles map[string]ProfileAccess
+	Settings *mongo.Collection // disabled comment sections
 }
 
 // Validate checks given values and sets defaults where applicable (immutable)
@@ -78,7 +126,7 @@ func (m CommentModel) Validate(comment Comment) (*Comment, error) {
 
 	cleaned := comment
 
-	// hier kann eine "Zensur-Func" afgerufen werden
+	// content filter is applied by Create/Update (language of the author)
 	cleaned.Comment = strings.TrimSpace(cleaned.Comment)
 
 	if cleaned.Comment == "" {
@@ -88,11 +136,57 @@ func (m CommentModel) Validate(comment Comment) (*Comment, error) {
 	return &cleaned, nil
 }
 
+// new comments require their profile, replies pass their parent's ID (edits don't pass either)
+func validateProfile(comment *Comment) error {
+	if comment.ProfileType == nil {
+		return ErrInvalidProfile
+	}
+	// uploads are identified by their file name, the profile is resolved by Create
+	if *comment.ProfileType == ContentTypeUpload {
+		if comment.FileName == nil || *comment.FileName == "" {
+			return ErrInvalidProfile
+		}
+	} else {
+		comment.FileName = nil
+		if comment.ProfileID == primitive.NilObjectID {
+			return ErrInvalidProfile
+		}
+	}
+	return nil
+}
+
 // Create adds a new Comment or Response
 func (m CommentModel) Create(comment *Comment) (string, error) {
 
 	// Validate called by controller
 
+	// the profile's visibility rules apply to its comments and their replies
+	if comment.ID == primitive.NilObjectID {
+		if err := validateProfile(comment); err != nil {
+			return "", err
+		}
+		profileType, profileKey := profileKeyOf(comment)
+		profile, err := m.openSection(profileType, profileKey, comment.CreatedID.Hex())
+		if err != nil {
+			return "", err
+		}
+		comment.ProfileID = profile.ProfileID
+	} else {
+		thread, target, err := m.findThread(comment.ID)
+		if err != nil {
+			return "", err
+		}
+		// replies can't be answered
+		if target != thread {
+			return "", apperror.ErrNoData
+		}
+		profileType, profileKey := profileKeyOf(thread)
+		_, err = m.openSection(profileType, profileKey, comment.CreatedID.Hex())
+		if err != nil {
+			return "", err
+		}
+	}
+
 	// set common fields
 	now := time.Now()
 	userName, err := m.GetUserNameOID(comment.CreatedID)
@@ -102,12 +196,17 @@ func (m CommentModel) Create(comment *Comment) (string, error) {
 	}
 	comment.CreatedName = userName
 
+	// held content is reviewed even if moderation is disabled
+	checked := m.Filter.Check(comment.Comment, m.GetCredentials(comment.CreatedID.Hex(), false).LanguageCode)
+	comment.Comment = checked.Text
+	comment.CommentHTML, comment.Mentions = m.render(comment.Comment)
+
 	comment.UpVotes = 0
 	comment.DownVotes = 0
 	comment.Rating = 0
 	comment.RatingSort = 0
 
-	if os.Getenv("COMMENT_MODERATION") == "YES" {
+	if os.Getenv("COMMENT_MODERATION") == "YES" || checked.Verdict == filter.Hold {
 		comment.StatusCode = lookups.CommentStatusPending
 	} else {
 		comment.StatusCode = lookups.CommentStatusVisible
@@ -116,6 +215,8 @@ func (m CommentModel) Create(comment *Comment) (string, error) {
 	comment.StatusID = comment.CreatedID
 	comment.StatusName = comment.CreatedName
 
+	mentioned := markMentions(comment)
+
 	if comment.ID == primitive.NilObjectID {
 		// new comment
 		comment.ID = primitive.NewObjectID()
@@ -128,6 +229,8 @@ func (m CommentModel) Create(comment *Comment) (string, error) {
 			return "", helpers.WrapError(err, helpers.FuncName()) // primitive.NilObjectID.Hex() ? probly useless
 		}
 
+		m.notifyMentions(comment, mentioned, comment.ProfileID, comment.ProfileType)
+
 		return res.InsertedID.(primitive.ObjectID).Hex(), nil
 	} else {
 		// new reply - push array
@@ -138,11 +241,15 @@ func (m CommentModel) Create(comment *Comment) (string, error) {
 		comment.ID = primitive.NewObjectID() // generate UID for the reply
 		comment.ProfileID = primitive.NilObjectID
 		comment.ProfileType = nil
+		comment.FileName = nil
 		comment.Pinned = nil // by convention, answers can't be pinged
 		comment.Replies = nil
 
-		// ID set by controller
-		filter := bson.D{{Key: "_id", Value: id}}
+		// ID set by controller (deleted comments can't be answered)
+		filter := bson.D{
+			{Key: "_id", Value: id},
+			{Key: "deleted", Value: bson.D{{Key: "$ne", Value: true}}},
+		}
 		// insert new reply at the beginning of the array
 		fields := bson.D{
 			{Key: "$push", Value: bson.D{
@@ -165,20 +272,49 @@ func (m CommentModel) Create(comment *Comment) (string, error) {
 			return "", apperror.ErrNoData // document might have been deleted
 		}
 
+		parentType := ContentTypeComment
+		m.notifyMentions(comment, mentioned, id, &parentType)
+
 		return comment.ID.Hex(), nil
 	}
 
 }
 
 // ListComments returns all comments and their possible answers to a given profile (limited)
-// userID is required to look-up the user's votes
-func (m CommentModel) ListComments(profileId string, userID string) ([]CommentListItem, error) {
+// the profile is identified by its OID, uploads by their file name
+// userID is required to apply the profile's visibility rules and to look-up the user's votes
+func (m CommentModel) ListComments(profileType string, profileKey string, sortOrder string, userID string) ([]CommentListItem, error) {
 
-	id, err := primitive.ObjectIDFromHex(profileId)
+	profile, err := m.openSection(profileType, profileKey, userID)
 	if err != nil {
-		return nil, apperror.ErrNoData
+		return nil, err
 	}
 
+	// number of replies loaded with each comment (further ones are read by ListReplies)
+	preview := helpers.IntSetting("COMMENT_REPLY_PREVIEW", 2, 0)
+
+	// always exclude pending/blocked content
+	// COMMENT_MODERATION env-option controls process, not publishing
+	filter := bson.D{
+		{Key: "profileId", Value: profile.ProfileID},
+		{Key: "profileType", Value: profileType},
+		{Key: "statusCD", Value: bson.D{
+			{Key: "$nin", Value: excludedStatus},
+		}},
+	}
+	if profileType == ContentTypeUpload {
+		filter = append(filter, bson.E{Key: "fileName", Value: profileKey})
+	}
+
+	// pinned comments first
+	sort := bson.D{
+		{Key: "pinned", Value: -1},
+	}
+	if sortOrder == CommentSortTop {
+		sort = append(sort, bson.E{Key: "ratingSort", Value: -1})
+	}
+	sort = append(sort, bson.E{Key: "_id", Value: -1})
+
 	// only read required fields for small list
 	fields := bson.D{
 		{Key: "createdID", Value: 1},
@@ -186,34 +322,30 @@ func (m CommentModel) ListComments(profileId string, userID string) ([]CommentLi
 		{Key: "modifiedTS", Value: 1},
 		{Key: "upVotes", Value: 1},
 		{Key: "downVotes", Value: 1},
+		{Key: "rating", Value: 1},
 		{Key: "pinned", Value: 1},
+		{Key: "deleted", Value: 1},
 		{Key: "comment", Value: 1},
+		{Key: "commentHtml", Value: 1},
+		{Key: "mentions", Value: 1},
+		{Key: "replyCount", Value: bson.D{{Key: "$size", Value: visibleReplies(nil)}}},
 		{Key: "replies", Value: bson.D{
-			{Key: "$slice", Value: 2}, // reads fist items, but full structure
+			{Key: "$slice", Value: bson.A{visibleReplies(nil), preview}}, // reads fist items, but full structure
 		}},
-		//{Key: "replies", Value: 1}, // reads full list, full structure
 	}
 
-	// always exclude pending/blocked content
-	// COMMENT_MODERATION env-option controls process, not publishing
-	exclStatus := [2]int32{lookups.CommentStatusBlocked, lookups.CommentStatusPending}
-	filter := bson.D{
-		{Key: "profileId", Value: id},
-		{Key: "statusCD", Value: bson.D{
-			{Key: "$nin", Value: exclStatus},
-		}},
+	// aggregation is used to filter the (embedded) replies by their status as well
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: filter}},
+		bson.D{{Key: "$sort", Value: sort}},
+		bson.D{{Key: "$limit", Value: 5}},
+		bson.D{{Key: "$project", Value: fields}},
 	}
 
-	sort := bson.D{
-		{Key: "_id", Value: -1},
-	}
-
-	opts := options.Find().SetProjection(fields).SetLimit(5).SetSort(sort)
-
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel() // nach 10 Sekunden abbrechen
 
-	cursor, err := m.Collection.Find(ctx, filter, opts)
+	cursor, err := m.Collection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, helpers.WrapError(err, helpers.FuncName())
 	}
@@ -232,121 +364,202 @@ func (m CommentModel) ListComments(profileId string, userID string) ([]CommentLi
 	}
 
 	// copy data to reduced list-struct
-	var commentList []CommentListItem
-	var comment CommentListItem
-	// var reply CommentListItem
-
-	for _, c := range comments {
-		comment.ID = c.ID
-		comment.CreatedTS = primitive.ObjectID.Timestamp(c.ID)
-		comment.CreatedID = c.CreatedID
-		comment.CreatedName = c.CreatedName
-		comment.Modified = (c.ModifiedTS != nil)
-		comment.UpVotes = c.UpVotes
-		comment.DownVotes = c.DownVotes
-		comment.Pinned = c.Pinned
-		comment.Comment = c.Comment
+	commentList := make([]CommentListItem, len(comments))
+
+	for i, c := range comments {
+		commentList[i] = toListItem(&c)
+		commentList[i].Pinned = c.Pinned
 		if len(c.Replies) > 0 {
-			comment.Replies = make([]CommentListItem, len(c.Replies))
-			for i, r := range c.Replies {
-				fmt.Println(r.UpVotes)
-				comment.Replies[i].ID = r.ID
-				comment.Replies[i].CreatedTS = primitive.ObjectID.Timestamp(r.ID)
-				comment.Replies[i].CreatedID = r.CreatedID
-				comment.Replies[i].CreatedName = r.CreatedName
-				comment.Replies[i].Modified = (r.ModifiedTS != nil)
-				comment.Replies[i].UpVotes = r.UpVotes
-				comment.Replies[i].DownVotes = r.DownVotes
-				comment.Replies[i].Pinned = nil // by convention not present for replies
-				comment.Replies[i].Comment = r.Comment
+			commentList[i].Replies = make([]CommentListItem, len(c.Replies))
+			for j := range c.Replies {
+				commentList[i].Replies[j] = toListItem(&c.Replies[j]) // pinned by convention not present for replies
 			}
 		}
-
-		commentList = append(commentList, comment)
 	}
 
 	// die User Votes für die entsprechenden ListComments lesen
-	if userID != "" {
-		// fehler kann hier ignoriert werden, teilresultat reicht auch
-		uv, _ := m.GetUserVotes("comment", userID)
-
-		// merge user votes into list of comments and their replies
-		if uv != nil {
-			// https://yourbasic.org/golang/gotcha-change-value-range/
-			for i := range commentList {
-				// process comments
-				for _, v := range uv {
-					if commentList[i].ID == v.ProfileID {
-						commentList[i].UserVote = v.UserVote
-					}
-				}
-				// process replies
-				for j := range commentList[i].Replies {
-					for _, v := range uv {
-						if commentList[i].Replies[j].ID == v.ProfileID {
-							commentList[i].Replies[j].UserVote = v.UserVote
-						}
-					}
-				}
-			}
-		}
-	}
+	m.mergeUserVotes(commentList, userID)
 
 	return commentList, nil
 }
 
-// SetRating is called by the voting model
-func (m CommentModel) SetRating(social *Social) error {
+// ListReplies returns a page of a comment's replies (most recent first)
+// the ID of the last reply of a page is passed as the cursor to read the next page
+func (m CommentModel) ListReplies(commentID string, cursorID string, pageSize int, userID string) (*ReplyList, error) {
 
-	// set fields to be possibily updated
-	fields := bson.D{
-		// systemfields
-		{Key: "$set", Value: bson.D{{Key: "rating", Value: social.Rating}}},
-		{Key: "$set", Value: bson.D{{Key: "ratingSort", Value: social.SortOrder}}},
-		{Key: "$set", Value: bson.D{{Key: "upVotes", Value: social.UpVotes}}},
-		{Key: "$set", Value: bson.D{{Key: "downVotes", Value: social.DownVotes}}},
+	id, err := primitive.ObjectIDFromHex(commentID)
+	if err != nil {
+		return nil, apperror.ErrNoData
+	}
+
+	// replies are pushed to the beginning of the array, hence older ones have lower IDs
+	var cursorOID *primitive.ObjectID
+	if cursorID != "" {
+		oid, err := primitive.ObjectIDFromHex(cursorID)
+		if err != nil {
+			return nil, apperror.ErrNoData
+		}
+		cursorOID = &oid
 	}
 
-	filter := bson.D{{Key: "_id", Value: social.ProfileOID}}
+	if pageSize <= 0 || pageSize > 50 {
+		pageSize = 10
+	}
+
+	// same status filter as top-level comments
+	filter := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "statusCD", Value: bson.D{
+			{Key: "$nin", Value: excludedStatus},
+		}},
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel() // nach 10 Sekunden abbrechen
 
-	result, err := m.Collection.UpdateOne(ctx, filter, fields)
+	// same visibility rules as the comment list: the profile is checked before any reply is read
+	var parent Comment
+	opts := options.FindOne().SetProjection(bson.D{
+		{Key: "profileId", Value: 1},
+		{Key: "profileType", Value: 1},
+		{Key: "fileName", Value: 1},
+	})
+	err = m.Collection.FindOne(ctx, filter, opts).Decode(&parent)
 	if err != nil {
-		return helpers.WrapError(err, helpers.FuncName())
+		// parent not found or not visible
+		if err == mongo.ErrNoDocuments {
+			return nil, apperror.ErrNoData
+		}
+		return nil, helpers.WrapError(err, helpers.FuncName())
 	}
 
-	// replies to comments are embedded to make queries for GET-requests faster.
-	// this means, there should be distuingished between comments and replies when updating the rating.
-	// howevever this is not possible in the current design, since the voting system should be generic.
-	// this drawback is accepted; it means that votes to replies require a second database access. when
-	// the parent's (comment) document was not found by "UpdateOne" a second update will be issued that
-	// targets the embedded array containing the answers.
-	if result.MatchedCount == 0 {
+	profileType, profileKey := profileKeyOf(&parent)
+	_, err = m.openSection(profileType, profileKey, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// one more is read to know if there's another page
+	fields := bson.D{
+		{Key: "replyCount", Value: bson.D{{Key: "$size", Value: visibleReplies(nil)}}},
+		{Key: "replies", Value: bson.D{
+			{Key: "$slice", Value: bson.A{visibleReplies(cursorOID), pageSize + 1}},
+		}},
+	}
+
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: filter}},
+		bson.D{{Key: "$project", Value: fields}},
+	}
+
+	cursor, err := m.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
 
-		// find the comment by the ID of the answer
-		// { 'replies._id': ObjectId('608e63ced04782d5c49c1eb8')}
+	var comments []Comment
+
+	err = cursor.All(ctx, &comments)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// removed in the meantime
+	if len(comments) == 0 {
+		return nil, apperror.ErrNoData
+	}
+
+	replies := comments[0].Replies
+
+	list := ReplyList{
+		ReplyCount: comments[0].ReplyCount,
+	}
+
+	if len(replies) > pageSize {
+		replies = replies[:pageSize]
+		list.NextCursor = replies[pageSize-1].ID.Hex()
+	}
+
+	list.Replies = make([]CommentListItem, len(replies))
+	for i := range replies {
+		list.Replies[i] = toListItem(&replies[i])
+	}
+
+	m.mergeUserVotes(list.Replies, userID)
+
+	return &list, nil
+}
 
-		// db.people.update({name: "Tom", "marks.subject": "English"},{"$set":{"marks.$.marks": 85}})
-		// https://riptutorial.com/mongodb/example/22368/update-of-embedded-documents-
+// IncVotes is called by the voting model to adjust the counters of a comment or reply
+// (comment first, reply second - see SetRating)
+func (m CommentModel) IncVotes(ctx context.Context, profileOID primitive.ObjectID, up int32, down int32) (*Social, error) {
 
-		filter = bson.D{
-			{Key: "replies._id", Value: social.ProfileOID}, // find comment by reply id
-			{Key: "replies._id", Value: social.ProfileOID}, // find reply itself
+	counters := func(prefix string) bson.D {
+		return bson.D{{Key: "$inc", Value: bson.D{
+			{Key: prefix + "upVotes", Value: up},
+			{Key: prefix + "downVotes", Value: down},
+		}}}
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var comment Comment
+	err := m.Collection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: profileOID}}, counters(""), opts).Decode(&comment)
+	if err == mongo.ErrNoDocuments {
+		err = m.Collection.FindOneAndUpdate(ctx, bson.D{{Key: "replies._id", Value: profileOID}}, counters("replies.$."), opts).Decode(&comment)
+	}
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, apperror.ErrNoData // document might have been deleted
 		}
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
 
-		fields = bson.D{
-			{Key: "$set", Value: bson.D{{Key: "replies.$.rating", Value: social.Rating}}},
-			{Key: "$set", Value: bson.D{{Key: "replies.$.ratingSort", Value: social.SortOrder}}},
-			{Key: "$set", Value: bson.D{{Key: "replies.$.upVotes", Value: social.UpVotes}}},
-			{Key: "$set", Value: bson.D{{Key: "replies.$.downVotes", Value: social.DownVotes}}},
+	target := &comment
+	if comment.ID != profileOID {
+		target = nil
+		for i := range comment.Replies {
+			if comment.Replies[i].ID == profileOID {
+				target = &comment.Replies[i]
+				break
+			}
 		}
+		if target == nil {
+			return nil, apperror.ErrNoData
+		}
+	}
 
-		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel() // nach 10 Sekunden abbrechen
+	return &Social{
+		ProfileOID: profileOID,
+		UpVotes:    target.UpVotes,
+		DownVotes:  target.DownVotes,
+	}, nil
+}
 
-		result, err := m.Collection.UpdateOne(ctx, filter, fields)
+// SetRating is called by the voting model
+func (m CommentModel) SetRating(ctx context.Context, social *Social) error {
+
+	// replies to comments are embedded to make queries for GET-requests faster,
+	// but the voting system is generic and does not distinguish between comments and replies.
+	// hence the comment is updated first; if no document was found, the ID belongs to a reply
+	// which is updated by the positional operator (second database access)
+	// https://riptutorial.com/mongodb/example/22368/update-of-embedded-documents-
+	rating := func(prefix string) bson.D {
+		return bson.D{{Key: "$set", Value: bson.D{
+			{Key: prefix + "rating", Value: social.Rating},
+			{Key: prefix + "ratingSort", Value: social.SortOrder},
+			{Key: prefix + "upVotes", Value: social.UpVotes},
+			{Key: prefix + "downVotes", Value: social.DownVotes},
+		}}}
+	}
+
+	result, err := m.Collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: social.ProfileOID}}, rating(""))
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	if result.MatchedCount == 0 {
+		result, err = m.Collection.UpdateOne(ctx, bson.D{{Key: "replies._id", Value: social.ProfileOID}}, rating("replies.$."))
 		if err != nil {
 			return helpers.WrapError(err, helpers.FuncName())
 		}
@@ -358,3 +571,428 @@ func (m CommentModel) SetRating(social *Social) error {
 
 	return nil
 }
+
+// CheckVote verifies that a comment or reply may be voted by a user
+// the comment must be visible to the user and authors can't vote their own comments
+func (m CommentModel) CheckVote(commentOID primitive.ObjectID, userID string) error {
+
+	thread, target, err := m.findThread(commentOID)
+	if err != nil {
+		return err
+	}
+
+	if target.Deleted {
+		return ErrCommentDeleted
+	}
+
+	if target.StatusCode == lookups.CommentStatusPending || target.StatusCode == lookups.CommentStatusBlocked {
+		return apperror.ErrNoData
+	}
+
+	if target.CreatedID == helpers.ObjectID(userID) {
+		return apperror.ErrDenied
+	}
+
+	profileType, profileKey := profileKeyOf(thread)
+	_, err = m.openSection(profileType, profileKey, userID)
+
+	return err
+}
+
+// GetVoteProfile resolves a comment or reply as a voted profile
+// the comment must be visible to the user (status, comment section), its author is the owner
+func (m CommentModel) GetVoteProfile(commentOID primitive.ObjectID, userID string) (*VoteProfile, error) {
+
+	thread, target, err := m.findThread(commentOID)
+	if err != nil {
+		return nil, err
+	}
+
+	if (target.StatusCode == lookups.CommentStatusPending || target.StatusCode == lookups.CommentStatusBlocked) &&
+		target.CreatedID != helpers.ObjectID(userID) {
+		return nil, apperror.ErrNoData
+	}
+
+	profileType, profileKey := profileKeyOf(thread)
+	_, err = m.openSection(profileType, profileKey, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VoteProfile{OwnerID: target.CreatedID, UpVotes: target.UpVotes, DownVotes: target.DownVotes}, nil
+}
+
+// ListUserComments returns all comments and replies written by a user (data export)
+// replies of other users are not included
+func (m CommentModel) ListUserComments(userOID primitive.ObjectID) (comments []Comment, replies []Comment, err error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	opts := options.Find().SetProjection(bson.D{{Key: "replies", Value: 0}})
+
+	cursor, err := m.Collection.Find(ctx, bson.D{{Key: "createdID", Value: userOID}}, opts)
+	if err != nil {
+		return nil, nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	err = cursor.All(ctx, &comments)
+	if err != nil {
+		return nil, nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// replies are embedded into other user's comments
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.D{{Key: "replies.createdID", Value: userOID}}}},
+		bson.D{{Key: "$unwind", Value: "$replies"}},
+		bson.D{{Key: "$match", Value: bson.D{{Key: "replies.createdID", Value: userOID}}}},
+		bson.D{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: "$replies"}}}},
+	}
+
+	cursor, err = m.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	err = cursor.All(ctx, &replies)
+	if err != nil {
+		return nil, nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	for i := range comments {
+		comments[i].CreatedTS = primitive.ObjectID.Timestamp(comments[i].ID)
+	}
+	for i := range replies {
+		replies[i].CreatedTS = primitive.ObjectID.Timestamp(replies[i].ID)
+	}
+
+	return comments, replies, nil
+}
+
+// AnonymizeComments replaces the creator's name of a deleted account in comments and replies
+// the content remains, so threads stay readable
+func (m CommentModel) AnonymizeComments(userOID primitive.ObjectID, userName string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	_, err := m.Collection.UpdateMany(ctx,
+		bson.D{{Key: "createdID", Value: userOID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "createdName", Value: userName}}}})
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// all matching replies of a comment are updated by the filtered positional operator
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.D{{Key: "r.createdID", Value: userOID}}},
+	})
+
+	_, err = m.Collection.UpdateMany(ctx,
+		bson.D{{Key: "replies.createdID", Value: userOID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "replies.$[r].createdName", Value: userName}}}},
+		opts)
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return nil
+}
+
+// GetModerationSample randomly chooses pending or flagged comments and replies (Sampler)
+func (m CommentModel) GetModerationSample(size int) ([]ReviewItem, error) {
+
+	review := bson.D{{Key: "$in", Value: bson.A{lookups.CommentStatusPending, lookups.CommentStatusFlagged}}}
+
+	// comments and their replies are flattened to one list of items
+	// replies reference their comment as the parent, comments their profile
+	flattened := bson.D{{Key: "$concatArrays", Value: bson.A{
+		bson.A{bson.D{
+			{Key: "_id", Value: "$_id"},
+			{Key: "createdID", Value: "$createdID"},
+			{Key: "createdName", Value: "$createdName"},
+			{Key: "statusCD", Value: "$statusCD"},
+			{Key: "statusTS", Value: "$statusTS"},
+			{Key: "comment", Value: "$comment"},
+			{Key: "parentID", Value: "$profileId"},
+			{Key: "parentType", Value: "$profileType"},
+		}},
+		bson.D{{Key: "$map", Value: bson.D{
+			{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$replies", bson.A{}}}}},
+			{Key: "as", Value: "r"},
+			{Key: "in", Value: bson.D{
+				{Key: "_id", Value: "$$r._id"},
+				{Key: "createdID", Value: "$$r.createdID"},
+				{Key: "createdName", Value: "$$r.createdName"},
+				{Key: "statusCD", Value: "$$r.statusCD"},
+				{Key: "statusTS", Value: "$$r.statusTS"},
+				{Key: "comment", Value: "$$r.comment"},
+				{Key: "parentID", Value: "$_id"},
+				{Key: "parentType", Value: ContentTypeComment},
+			}},
+		}}},
+	}}}
+
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: "statusCD", Value: review}},
+				bson.D{{Key: "replies.statusCD", Value: review}},
+			}},
+		}}},
+		bson.D{{Key: "$project", Value: bson.D{{Key: "items", Value: flattened}}}},
+		bson.D{{Key: "$unwind", Value: "$items"}},
+		bson.D{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: "$items"}}}},
+		bson.D{{Key: "$match", Value: bson.D{{Key: "statusCD", Value: review}}}},
+		bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: size}}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := m.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var sample []struct {
+		ID          primitive.ObjectID `bson:"_id"`
+		CreatedID   primitive.ObjectID `bson:"createdID"`
+		CreatedName string             `bson:"createdName"`
+		StatusCode  int32              `bson:"statusCD"`
+		StatusTS    time.Time          `bson:"statusTS"`
+		Comment     string             `bson:"comment"`
+		ParentID    primitive.ObjectID `bson:"parentID"`
+		ParentType  string             `bson:"parentType"`
+	}
+
+	err = cursor.All(ctx, &sample)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	items := make([]ReviewItem, len(sample))
+	for i, s := range sample {
+		parentID, parentType := s.ParentID, s.ParentType
+		items[i] = ReviewItem{
+			ParentID:    &parentID,
+			ParentType:  &parentType,
+			ContentID:   s.ID.Hex(),
+			ContentType: ContentTypeComment,
+			Content:     s.Comment,
+			StatusCode:  s.StatusCode,
+			StatusTS:    s.StatusTS,
+			CreatorID:   s.CreatedID,
+			CreatorName: s.CreatedName,
+		}
+	}
+
+	return items, nil
+}
+
+// GetModerationStatus returns the status of a comment or reply (Sampler)
+func (m CommentModel) GetModerationStatus(contentID string) (int32, error) {
+
+	commentOID, err := primitive.ObjectIDFromHex(contentID)
+	if err != nil {
+		return 0, apperror.ErrNoData
+	}
+
+	_, target, err := m.findThread(commentOID)
+	if err != nil {
+		return 0, err
+	}
+
+	return target.StatusCode, nil
+}
+
+// SetModerationStatus applies a moderator's decision to a comment or reply (Sampler)
+func (m CommentModel) SetModerationStatus(contentID string, statusCode int32, executiveOID primitive.ObjectID, executiveName string) error {
+
+	commentOID, err := primitive.ObjectIDFromHex(contentID)
+	if err != nil {
+		return apperror.ErrNoData
+	}
+
+	thread, target, err := m.findThread(commentOID)
+	if err != nil {
+		return err
+	}
+
+	set := bson.D{
+		{Key: "statusCD", Value: statusCode},
+		{Key: "statusTS", Value: time.Now()},
+		{Key: "statusID", Value: executiveOID},
+		{Key: "statusName", Value: executiveName},
+	}
+
+	// mentions are notified once the content is visible
+	target.StatusCode = statusCode
+	mentioned := markMentions(target)
+	if len(mentioned) > 0 {
+		set = append(set, bson.E{Key: "mentions", Value: target.Mentions})
+	}
+
+	err = m.updateThread(thread, target, set, nil)
+	if err != nil {
+		return err
+	}
+
+	parentID, parentType := parentOf(thread, target)
+	m.notifyMentions(target, mentioned, parentID, parentType)
+
+	return nil
+}
+
+// internal helpers
+
+// pending/blocked content is never listed
+var excludedStatus = bson.A{lookups.CommentStatusBlocked, lookups.CommentStatusPending}
+
+// builds the expression filtering a comment's visible replies (optionally older than a cursor)
+func visibleReplies(cursorOID *primitive.ObjectID) bson.D {
+
+	cond := bson.A{
+		bson.D{{Key: "$not", Value: bson.A{
+			bson.D{{Key: "$in", Value: bson.A{"$$r.statusCD", excludedStatus}}},
+		}}},
+	}
+	if cursorOID != nil {
+		cond = append(cond, bson.D{{Key: "$lt", Value: bson.A{"$$r._id", *cursorOID}}})
+	}
+
+	return bson.D{{Key: "$filter", Value: bson.D{
+		{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$replies", bson.A{}}}}},
+		{Key: "as", Value: "r"},
+		{Key: "cond", Value: bson.D{{Key: "$and", Value: cond}}},
+	}}}
+}
+
+// copies a comment or reply to the reduced list-struct (without replies)
+func toListItem(c *Comment) CommentListItem {
+
+	// comments written before markup was supported are escaped only
+	if c.CommentHTML == "" {
+		c.CommentHTML = markup.Render(c.Comment, markup.Resolver{}).HTML
+	}
+
+	return CommentListItem{
+		ID:          c.ID,
+		CreatedTS:   primitive.ObjectID.Timestamp(c.ID),
+		CreatedID:   c.CreatedID,
+		CreatedName: c.CreatedName,
+		Modified:    (c.ModifiedTS != nil),
+		Deleted:     c.Deleted,
+		UpVotes:     c.UpVotes,
+		DownVotes:   c.DownVotes,
+		Rating:      c.Rating,
+		Comment:     c.Comment,
+		CommentHTML: c.CommentHTML,
+		Mentions:    c.Mentions,
+		ReplyCount:  c.ReplyCount,
+	}
+}
+
+// renders the markup of a text and resolves its mentions and course references
+func (m CommentModel) render(text string) (string, []CommentMention) {
+
+	resolver := markup.Resolver{
+		User: func(loginName string) (string, bool) {
+			userOID, err := m.GetUserIDByName(loginName)
+			return userOID.Hex(), err == nil
+		},
+		Course: func(sharingCode int32) (string, bool) {
+			courseOID, err := m.GetCourseIDBySharing(sharingCode)
+			return courseOID.Hex(), err == nil
+		},
+	}
+
+	result := markup.Render(text, resolver)
+
+	var mentions []CommentMention
+	for _, r := range result.Mentions {
+		mentions = append(mentions, CommentMention{UserID: helpers.ObjectID(r.ID), UserName: r.Name})
+	}
+
+	return result.HTML, mentions
+}
+
+// marks the mentions of visible content as notified and returns those to notify
+// (authors mentioning themselves are not notified)
+func markMentions(comment *Comment) []CommentMention {
+
+	if comment.StatusCode == lookups.CommentStatusPending || comment.StatusCode == lookups.CommentStatusBlocked {
+		return nil
+	}
+
+	var mentioned []CommentMention
+	for i := range comment.Mentions {
+		if comment.Mentions[i].Notified {
+			continue
+		}
+		comment.Mentions[i].Notified = true
+		if comment.Mentions[i].UserID != comment.CreatedID {
+			mentioned = append(mentioned, comment.Mentions[i])
+		}
+	}
+
+	return mentioned
+}
+
+// notifies mentioned users of a comment (profile as parent) or reply (comment as parent)
+func (m CommentModel) notifyMentions(comment *Comment, mentioned []CommentMention, parentID primitive.ObjectID, parentType *string) {
+	for _, mention := range mentioned {
+		// notifications are not essential, the comment is saved anyway
+		_ = m.Notify(Notification{
+			UserID:      mention.UserID,
+			Type:        NotificationMention,
+			ActorID:     comment.CreatedID,
+			ActorName:   comment.CreatedName,
+			ContentType: ContentTypeComment,
+			ContentID:   comment.ID.Hex(),
+			ParentID:    &parentID,
+			ParentType:  parentType,
+		})
+	}
+}
+
+// merges a user's votes into a list of comments and their replies
+func (m CommentModel) mergeUserVotes(commentList []CommentListItem, userID string) {
+
+	if userID == "" {
+		return
+	}
+
+	// only the votes of the listed comments and replies are read
+	var ids []primitive.ObjectID
+	for _, c := range commentList {
+		ids = append(ids, c.ID)
+		for _, r := range c.Replies {
+			ids = append(ids, r.ID)
+		}
+	}
+
+	// fehler kann hier ignoriert werden, teilresultat reicht auch
+	uv, _ := m.GetUserVotes(userID, ids)
+	if uv == nil {
+		return
+	}
+
+	// https://yourbasic.org/golang/gotcha-change-value-range/
+	for i := range commentList {
+		// process comments
+		for _, v := range uv {
+			if commentList[i].ID == v.ProfileID {
+				commentList[i].UserVote = v.UserVote
+			}
+		}
+		// process replies
+		for j := range commentList[i].Replies {
+			for _, v := range uv {
+				if commentList[i].Replies[j].ID == v.ProfileID {
+					commentList[i].Replies[j].UserVote = v.UserVote
+				}
+			}
+		}
+	}
+}
@@ -2,10 +2,11 @@ package models
 
 import (
 	"context"
-	"fmt"
 	"forza-garage/apperror"
 	"forza-garage/database"
+	"forza-garage/filter"
 	"forza-garage/helpers"
+	"forza-garage/logging"
 	"forza-garage/lookups"
 	"strconv"
 	"strings"
@@ -64,6 +65,7 @@ type CourseListItem struct {
 	StyleCode    int32              `json:"styleCode"`
 	StyleText    string             `json:"styleText"`
 	CarClasses   []Lookup           `json:"carClasses" bson:"carClasses"`
+	CoverURL     string             `json:"coverUrl,omitempty"` // thumbnail of the gallery's cover
 }
 
 const (
@@ -98,7 +100,10 @@ type CourseModel struct {
 	GetUserName func(ID string) (string, error)
 	// ToDo: halt umbennen GetCredentials
 	CredentialsReader func(userId string, loadFriendlist bool) *Credentials
-	GetUserVote       func(profileID string, userID string) (int32, error) // injected from vote model
+	GetUserVote       func(profileID string, userID string) (int32, error)                          // injected from vote model
+	GetCovers         func(profileOIDs []primitive.ObjectID) (map[primitive.ObjectID]string, error) // injected from upload model
+	Filter            filter.ContentFilter
+	Log               *logging.Logger
 }
 
 // Models do not change original values passed by the controllers, but return new structures
@@ -120,6 +125,16 @@ func (m CourseModel) Validate(course Course) (*Course, error) {
 		return nil, ErrCourseNameMissing
 	}
 
+	// courses have no moderation status, hence held texts are rejected
+	// (the author's language is unknown here)
+	for _, text := range []*string{&cleaned.Name, &cleaned.Description} {
+		checked := m.Filter.Check(*text, filter.AllLanguages)
+		if checked.Verdict == filter.Hold {
+			return nil, ErrContentRejected
+		}
+		*text = checked.Text
+	}
+
 	return &cleaned, nil
 }
 
@@ -150,6 +165,27 @@ func (m CourseModel) ForzaSharingExists(sharingCode int32) (bool, error) {
 	return true, nil
 }
 
+// GetCourseIDBySharing returns the OID of a course by its "Sharing Code" (course references in comments)
+func (m CourseModel) GetCourseIDBySharing(sharingCode int32) (primitive.ObjectID, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	data := struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}{}
+
+	err := m.Collection.FindOne(ctx, bson.M{"forzaSharing": sharingCode}, options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}})).Decode(&data)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return primitive.NilObjectID, apperror.ErrNoData
+		}
+		return primitive.NilObjectID, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return data.ID, nil
+}
+
 // CreateCourse adds a new route - validated by controller
 func (m CourseModel) CreateCourse(course *Course, userID string) (string, error) {
 
@@ -207,6 +243,7 @@ func (m CourseModel) SearchCourses(searchSpecs *CourseSearchParams, userID strin
 		{Key: "seriesCD", Value: 1},
 		{Key: "styleCD", Value: 1},
 		{Key: "carClasses", Value: 1},
+		{Key: "visibilityCD", Value: 1}, // cover URLs
 	}
 
 	sort := bson.D{
@@ -256,7 +293,7 @@ func (m CourseModel) SearchCourses(searchSpecs *CourseSearchParams, userID strin
 				}},
 				{Key: "visibilityCD", Value: lookups.VisibilityAll},
 			}
-			fmt.Println(filter)
+			m.Log.Debug("search filter", logging.Fields{"filter": filter})
 		} else {
 			filter = bson.D{
 				// every next field is AND
@@ -390,6 +427,16 @@ func (m CourseModel) SearchCourses(searchSpecs *CourseSearchParams, userID strin
 	var courseList []CourseListItem
 	var course CourseListItem
 
+	// the covers are optional, errors are logged only
+	courseOIDs := make([]primitive.ObjectID, len(courses))
+	for i, c := range courses {
+		courseOIDs[i] = c.ID
+	}
+	covers, err := m.GetCovers(courseOIDs)
+	if err != nil {
+		m.Log.Err(err)
+	}
+
 	for _, c := range courses {
 		course.ID = c.ID
 		course.CreatedTS = primitive.ObjectID.Timestamp(c.ID)
@@ -411,6 +458,7 @@ func (m CourseModel) SearchCourses(searchSpecs *CourseSearchParams, userID strin
 				course.CarClasses[i].Text = database.GetLookupText(lookups.LookupType(lookups.LTcarClass), v.Value)
 			}
 		}
+		course.CoverURL = covers[c.ID]
 
 		courseList = append(courseList, course)
 	}
@@ -564,24 +612,55 @@ func (m CourseModel) UpdateCourse(course *Course, userID string) error {
 	return nil
 }
 
+// IncVotes is called by the voting model to adjust the counters of a course
+func (m CourseModel) IncVotes(ctx context.Context, profileOID primitive.ObjectID, up int32, down int32) (*Social, error) {
+
+	update := bson.D{{Key: "$inc", Value: bson.D{
+		{Key: "metaInfo.upVotes", Value: up},
+		{Key: "metaInfo.downVotes", Value: down},
+	}}}
+
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.D{{Key: "metaInfo.upVotes", Value: 1}, {Key: "metaInfo.downVotes", Value: 1}})
+
+	var data struct {
+		MetaInfo struct {
+			UpVotes   int32 `bson:"upVotes"`
+			DownVotes int32 `bson:"downVotes"`
+		} `bson:"metaInfo"`
+	}
+
+	err := m.Collection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: profileOID}}, update, opts).Decode(&data)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, apperror.ErrNoData // document might have been deleted
+		}
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return &Social{
+		ProfileOID: profileOID,
+		UpVotes:    data.MetaInfo.UpVotes,
+		DownVotes:  data.MetaInfo.DownVotes,
+	}, nil
+}
+
 // SetRating is called by the voting model
-func (m CourseModel) SetRating(social *Social) error {
+func (m CourseModel) SetRating(ctx context.Context, social *Social) error {
 
 	// set fields to be possibily updated
-	fields := bson.D{
+	fields := bson.D{{Key: "$set", Value: bson.D{
 		// systemfields
-		{Key: "$set", Value: bson.D{{Key: "metaInfo.rating", Value: social.Rating}}},
-		{Key: "$set", Value: bson.D{{Key: "metaInfo.ratingSort", Value: social.SortOrder}}},
-		{Key: "$set", Value: bson.D{{Key: "metaInfo.upVotes", Value: social.UpVotes}}},
-		{Key: "$set", Value: bson.D{{Key: "metaInfo.downVotes", Value: social.DownVotes}}},
-		{Key: "$set", Value: bson.D{{Key: "metaInfo.touchedTS", Value: social.TouchedTS}}},
-	}
+		{Key: "metaInfo.rating", Value: social.Rating},
+		{Key: "metaInfo.ratingSort", Value: social.SortOrder},
+		{Key: "metaInfo.upVotes", Value: social.UpVotes},
+		{Key: "metaInfo.downVotes", Value: social.DownVotes},
+		{Key: "metaInfo.touchedTS", Value: social.TouchedTS},
+	}}}
 
 	filter := bson.D{{Key: "_id", Value: social.ProfileOID}}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel() // nach 10 Sekunden abbrechen
-
 	result, err := m.Collection.UpdateOne(ctx, filter, fields)
 	if err != nil {
 		return helpers.WrapError(err, helpers.FuncName())
@@ -594,6 +673,148 @@ func (m CourseModel) SetRating(social *Social) error {
 	return nil
 }
 
+// ListUserCourses returns all courses created by a user (data export)
+func (m CourseModel) ListUserCourses(userOID primitive.ObjectID) ([]Course, error) {
+
+	filter := bson.D{{Key: "metaInfo.createdID", Value: userOID}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := m.Collection.Find(ctx, filter)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var courses []Course
+
+	err = cursor.All(ctx, &courses)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	for i := range courses {
+		courses[i].MetaInfo.CreatedTS = primitive.ObjectID(courses[i].ID).Timestamp()
+		m.addLookups(&courses[i])
+	}
+
+	return courses, nil
+}
+
+// AnonymizeCourses replaces the creator's name of a deleted account
+// the courses remain, since they're used by others (eg. in championships)
+func (m CourseModel) AnonymizeCourses(userOID primitive.ObjectID, userName string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	_, err := m.Collection.UpdateMany(ctx,
+		bson.D{{Key: "metaInfo.createdID", Value: userOID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "metaInfo.createdName", Value: userName}}}})
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	_, err = m.Collection.UpdateMany(ctx,
+		bson.D{{Key: "metaInfo.modifiedID", Value: userOID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "metaInfo.modifiedName", Value: userName}}}})
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return nil
+}
+
+// GetCreatorID returns the owner of a course (used for permissions of other domains, eg. pinning comments)
+func (m CourseModel) GetCreatorID(courseOID primitive.ObjectID) (primitive.ObjectID, error) {
+
+	opts := options.FindOne().SetProjection(bson.D{{Key: "metaInfo.createdID", Value: 1}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	var course Course
+
+	err := m.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: courseOID}}, opts).Decode(&course)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return primitive.NilObjectID, apperror.ErrNoData
+		}
+		return primitive.NilObjectID, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return course.MetaInfo.CreatedID, nil
+}
+
+// GetVoteProfile resolves a course as a voted profile (visibility rules of courses)
+func (m CourseModel) GetVoteProfile(courseOID primitive.ObjectID, userID string) (*VoteProfile, error) {
+
+	opts := options.FindOne().SetProjection(bson.D{
+		{Key: "visibilityCD", Value: 1},
+		{Key: "metaInfo.createdID", Value: 1},
+		{Key: "metaInfo.upVotes", Value: 1},
+		{Key: "metaInfo.downVotes", Value: 1},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	var course Course
+
+	err := m.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: courseOID}}, opts).Decode(&course)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, apperror.ErrNoData
+		}
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	err = GrantPermissions(course.VisibilityCode, course.MetaInfo.CreatedID, m.CredentialsReader(userID, true))
+	if err != nil {
+		return nil, err
+	}
+
+	return &VoteProfile{
+		OwnerID:   course.MetaInfo.CreatedID,
+		UpVotes:   course.MetaInfo.UpVotes,
+		DownVotes: course.MetaInfo.DownVotes,
+	}, nil
+}
+
+// GetCommentProfile resolves a course as a commentable profile (visibility rules of courses)
+func (m CourseModel) GetCommentProfile(courseID string, userID string) (*CommentProfile, error) {
+
+	courseOID, err := primitive.ObjectIDFromHex(courseID)
+	if err != nil {
+		return nil, apperror.ErrNoData
+	}
+
+	opts := options.FindOne().SetProjection(bson.D{
+		{Key: "visibilityCD", Value: 1},
+		{Key: "metaInfo.createdID", Value: 1},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	var course Course
+
+	err = m.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: courseOID}}, opts).Decode(&course)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, apperror.ErrNoData
+		}
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	err = GrantPermissions(course.VisibilityCode, course.MetaInfo.CreatedID, m.CredentialsReader(userID, true))
+	if err != nil {
+		return nil, err
+	}
+
+	return &CommentProfile{ProfileID: courseOID, OwnerID: course.MetaInfo.CreatedID}, nil
+}
+
 // internal helpers (private methods)
 
 // actually that's not immutable, but ok here
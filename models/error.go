@@ -14,6 +14,9 @@ var (
 	ErrInvalidUser          = errors.New("invalid user name or password")
 	ErrInvalidPassword      = errors.New("password does not meet rules")
 	ErrInvalidFriend        = errors.New("could not add/remove friend")
+	ErrInvalidRole          = errors.New("invalid user role")
+	ErrInvalidSuspension    = errors.New("suspension must end in the future")
+	ErrUserSuspended        = errors.New("user account is suspended")
 )
 
 // course
@@ -21,16 +24,40 @@ var (
 var (
 	ErrForzaSharingCodeMissing = errors.New("sharing code is required")
 	ErrCourseNameMissing       = errors.New("course name is required")
+	ErrContentRejected         = errors.New("text rejected by content filter")
 	ErrForzaSharingCodeTaken   = errors.New("forza sharing code already used")
 )
 
 // comment
 // transformed by controllers to respective Unprocessable Entity (422)
 var (
-	ErrCommentEmpty = errors.New("comment is required")
+	ErrCommentEmpty     = errors.New("comment is required")
+	ErrCommentDeleted   = errors.New("comment was deleted")
+	ErrCommentsDisabled = errors.New("comments are disabled by the profile owner")
+	ErrInvalidProfile   = errors.New("invalid profile to comment")
+)
+
+// moderation
+var (
+	ErrInvalidDecision = errors.New("invalid moderation decision")
+	ErrInvalidReport   = errors.New("invalid report")
+	ErrAlreadyReported = errors.New("item already reported by user")
+)
+
+// votes
+var (
+	ErrVoteLimitExceeded = errors.New("too many votes")
+	ErrInvalidDateRange  = errors.New("invalid date range")
 )
 
 // uploads
 var (
-	ErrMaximumFilesReached = errors.New("file limit exceeded")
+	ErrMaximumFilesReached  = errors.New("file limit exceeded")
+	ErrUnsupportedFile      = errors.New("unsupported file type")
+	ErrFileTooLarge         = errors.New("file too large")
+	ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
+	ErrInvalidOrder         = errors.New("file names don't match the slots")
+	ErrUploadOffset         = errors.New("upload offset doesn't match")
+	ErrClipTooLong          = errors.New("video clip too long")
+	ErrInvalidUploadProfile = errors.New("invalid profile to upload to")
 )
@@ -6,6 +6,17 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// types of the profiles and their content (comments, votes, uploads, reports, moderation, audit log)
+// courses and users can only be reported, comments and uploads are moderated as well
+const (
+	ContentTypeCourse  = "course"
+	ContentTypeUser    = "user"
+	ContentTypeComment = "comment"
+	ContentTypeReply   = "reply" // replies share the comments' IDs and domain (embedded)
+	ContentTypeUpload  = "upload"
+	ContentTypeProfile = "profile" // review items of reported courses and users
+)
+
 // Header is used as an embedded type for an object's meta-info
 // no required bindings (binding:"required") since the CRUD-Operations have different meanings
 type Header struct {
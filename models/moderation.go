@@ -1,50 +1,271 @@
 package models
 
 import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/database"
+	"forza-garage/helpers"
+	"forza-garage/lookups"
+	"sort"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Sampler is implemented by every domain containing moderated content
+// (collections have different formats, unknown to the moderation type)
 type Sampler interface {
-	GetSample() *ReviewItem
+	// GetModerationSample randomly chooses pending or flagged content ($match + $sample)
+	GetModerationSample(size int) ([]ReviewItem, error)
+	// GetModerationStatus returns the current status of an item
+	GetModerationStatus(contentID string) (int32, error)
+	// SetModerationStatus applies a moderator's decision
+	SetModerationStatus(contentID string, statusCode int32, executiveOID primitive.ObjectID, executiveName string) error
 }
 
+// Moderation provides the review queue across all domains
 type Moderation struct {
-	collections map[string]*mongo.Collection
+	collections    map[string]*mongo.Collection
+	Samplers       map[string]Sampler // per content type
+	GetCredentials func(userId string, loadFriendlist bool) *Credentials
+	CountReports   func(contentType string, contentID string) (int32, error) // injected from report model
+	ResolveReports func(contentType string, contentID string) error
 }
 
 // ReviewItem represent user content to review
 // sent only - not directly saved
 type ReviewItem struct {
-	ParentID        *primitive.ObjectID // profile, comment...
-	ParentType      *string
-	ContentID       primitive.ObjectID // profile, upload, comment/reply...
-	ContentType     string
-	StatusCode      int32
-	StatusText      string
-	CreatorID       primitive.ObjectID
-	CreatorName     string
-	CreatorJoinedAt time.Time // have client display "member since...
+	ParentID        *primitive.ObjectID `json:"parentId,omitempty"` // profile, comment...
+	ParentType      *string             `json:"parentType,omitempty"`
+	ContentID       string              `json:"contentId"` // comment/reply ID, file name of uploads
+	ContentType     string              `json:"contentType"`
+	Content         string              `json:"content"` // text of comments, URL of uploads
+	StatusCode      int32               `json:"statusCode"`
+	StatusText      string              `json:"statusText"`
+	StatusTS        time.Time           `json:"statusTS"`
+	CreatorID       primitive.ObjectID  `json:"creatorId"`
+	CreatorName     string              `json:"creatorName"`
+	CreatorJoinedAt time.Time           `json:"creatorJoinedTS"` // have client display "member since...
+	CreatorPosts    int32               `json:"creatorPosts"`    // comments and replies
+	CreatorBlocked  int32               `json:"creatorBlocked"`  // rejected comments and replies
+	PostReports     int32               `json:"postReports"`     // open reports of the item
 	// ToDO: überlegen, how many...
-	// CreatorPosts int
 	// CreatorReports int
-	// PostReports int
 }
 
+// SystemUserName is shown as the executive of automatic status changes
+const SystemUserName = "[system]"
+
+// moderator's decisions
+const (
+	ModerationApprove = "approve"
+	ModerationReject  = "reject"
+	ModerationFlag    = "flag"
+)
+
 // SetConnections initializes the instance
 func (m *Moderation) SetConnections(mongoCollections map[string]*mongo.Collection) {
 	m.collections = mongoCollections
 }
 
 // GetModerationItem randomly chooses some pending or reported content for review
-func (m *Moderation) GetModerationItem() *ReviewItem {
+// flagged content is listed first, ordered by the number of reports (admins only)
+func (m *Moderation) GetModerationItem(size int, executiveUserID string) ([]ReviewItem, error) {
+
+	_, err := m.grantModerator(executiveUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if size <= 0 || size > 50 {
+		size = 10
+	}
 
 	// get samples from every collection
 	// https://docs.mongodb.com/manual/reference/operator/aggregation-pipeline/#aggregation-pipeline-operator-reference
+	var items []ReviewItem
+	for _, s := range m.Samplers {
+		sample, err := s.GetModerationSample(size)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, sample...)
+	}
+
+	if items == nil {
+		return nil, apperror.ErrNoData
+	}
+
+	// profiles' items are counted by their sampler
+	for i := range items {
+		if items[i].ContentType != ContentTypeProfile {
+			items[i].PostReports, err = m.CountReports(items[i].ContentType, items[i].ContentID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		flaggedI := items[i].StatusCode == lookups.CommentStatusFlagged
+		flaggedJ := items[j].StatusCode == lookups.CommentStatusFlagged
+		if flaggedI != flaggedJ {
+			return flaggedI
+		}
+		return items[i].PostReports > items[j].PostReports
+	})
+
+	// creators are looked-up once per queue
+	stats := make(map[primitive.ObjectID]*ReviewItem)
+
+	for i := range items {
+		items[i].StatusText = database.GetLookupText(lookups.LookupType(lookups.LTcommentStatus), items[i].StatusCode)
+
+		creator, ok := stats[items[i].CreatorID]
+		if !ok {
+			creator = &ReviewItem{}
+			err = m.getCreatorStats(items[i].CreatorID, creator)
+			if err != nil {
+				return nil, err
+			}
+			stats[items[i].CreatorID] = creator
+		}
+		items[i].CreatorJoinedAt = creator.CreatorJoinedAt
+		items[i].CreatorPosts = creator.CreatorPosts
+		items[i].CreatorBlocked = creator.CreatorBlocked
+	}
+
+	return items, nil
+}
+
+// Moderate applies a moderator's decision (approve, reject, flag) to an item
+func (m *Moderation) Moderate(contentType string, contentID string, decision string, executiveUserID string) error {
+
+	executive, err := m.grantModerator(executiveUserID)
+	if err != nil {
+		return err
+	}
+
+	sampler, ok := m.Samplers[contentType]
+	if !ok {
+		return apperror.ErrNoData
+	}
+
+	var statusCode int32
+	switch decision {
+	case ModerationApprove:
+		statusCode = lookups.CommentStatusVisible
+	case ModerationReject:
+		statusCode = lookups.CommentStatusBlocked
+	case ModerationFlag:
+		statusCode = lookups.CommentStatusFlagged
+	default:
+		return ErrInvalidDecision
+	}
+
+	err = sampler.SetModerationStatus(contentID, statusCode, executive.UserID, executive.LoginName)
+	if err != nil {
+		return err
+	}
+
+	// the decision covers all reports received so far
+	return m.ResolveReports(contentType, contentID)
+}
+
+// Flag marks content for review, used when enough users reported it (system action)
+// only visible content is flagged, pending and blocked content awaits or has the moderator's decision
+func (m *Moderation) Flag(contentType string, contentID string) error {
+
+	sampler, ok := m.Samplers[contentType]
+	if !ok {
+		return apperror.ErrNoData
+	}
+
+	statusCode, err := sampler.GetModerationStatus(contentID)
+	if err != nil {
+		return err
+	}
+	if statusCode != lookups.CommentStatusVisible {
+		return nil
+	}
+
+	return sampler.SetModerationStatus(contentID, lookups.CommentStatusFlagged, primitive.NilObjectID, SystemUserName)
+}
+
+// internal helpers
+
+// checks if the executive user is allowed to moderate (admins)
+func (m *Moderation) grantModerator(executiveUserID string) (*Credentials, error) {
+	credentials := m.GetCredentials(executiveUserID, false)
+	if credentials.RoleCode != lookups.UserRoleAdmin {
+		return nil, apperror.ErrDenied
+	}
+	return credentials, nil
+}
+
+// reads the creator's account age and comment statistics
+func (m *Moderation) getCreatorStats(creatorOID primitive.ObjectID, item *ReviewItem) error {
+
+	// deleted accounts have no document anymore, the OID still holds the creation date
+	item.CreatorJoinedAt = creatorOID.Timestamp()
+
+	// comments and replies are counted in one go
+	ownReplies := func(status interface{}) bson.D {
+		cond := bson.A{bson.D{{Key: "$eq", Value: bson.A{"$$r.createdID", creatorOID}}}}
+		if status != nil {
+			cond = append(cond, bson.D{{Key: "$eq", Value: bson.A{"$$r.statusCD", status}}})
+		}
+		return bson.D{{Key: "$size", Value: bson.D{{Key: "$filter", Value: bson.D{
+			{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$replies", bson.A{}}}}},
+			{Key: "as", Value: "r"},
+			{Key: "cond", Value: bson.D{{Key: "$and", Value: cond}}},
+		}}}}}
+	}
+	ownComment := bson.D{{Key: "$cond", Value: bson.A{bson.D{{Key: "$eq", Value: bson.A{"$createdID", creatorOID}}}, 1, 0}}}
+	ownBlocked := bson.D{{Key: "$cond", Value: bson.A{
+		bson.D{{Key: "$and", Value: bson.A{
+			bson.D{{Key: "$eq", Value: bson.A{"$createdID", creatorOID}}},
+			bson.D{{Key: "$eq", Value: bson.A{"$statusCD", lookups.CommentStatusBlocked}}},
+		}}}, 1, 0}}}
+
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: "createdID", Value: creatorOID}},
+				bson.D{{Key: "replies.createdID", Value: creatorOID}},
+			}},
+		}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "posts", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$add", Value: bson.A{ownComment, ownReplies(nil)}}}}}},
+			{Key: "blocked", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$add", Value: bson.A{ownBlocked, ownReplies(lookups.CommentStatusBlocked)}}}}}},
+		}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := m.collections["comments"].Aggregate(ctx, pipeline)
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var result []struct {
+		Posts   int32 `bson:"posts"`
+		Blocked int32 `bson:"blocked"`
+	}
+
+	err = cursor.All(ctx, &result)
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
 
-	// use injected functions since collections have different formats, uknown to the moderation type
+	if len(result) > 0 {
+		item.CreatorPosts = result[0].Posts
+		item.CreatorBlocked = result[0].Blocked
+	}
 
 	return nil
 }
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/helpers"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// notification types
+const (
+	NotificationMention = "mention" // user was mentioned in a comment or reply
+)
+
+// Notification informs a user about actions of other users concerning them
+type Notification struct {
+	ID          primitive.ObjectID  `json:"id" bson:"_id"`
+	CreatedTS   time.Time           `json:"createdTS" bson:"-"` // extracted from OID
+	UserID      primitive.ObjectID  `json:"-" bson:"userID"`    // recipient
+	Type        string              `json:"type" bson:"type"`
+	ActorID     primitive.ObjectID  `json:"actorID" bson:"actorID"`
+	ActorName   string              `json:"actorName" bson:"actorName"`
+	ContentType string              `json:"contentType" bson:"contentType"`
+	ContentID   string              `json:"contentId" bson:"contentId"`
+	ParentID    *primitive.ObjectID `json:"parentId,omitempty" bson:"parentId,omitempty"` // commented profile
+	ParentType  *string             `json:"parentType,omitempty" bson:"parentType,omitempty"`
+	Read        bool                `json:"read" bson:"read"`
+}
+
+// NotificationModel provides the logic to the interface and access to the database
+type NotificationModel struct {
+	Collection *mongo.Collection
+}
+
+// Notify saves a notification for its recipient
+func (m NotificationModel) Notify(notification Notification) error {
+
+	notification.ID = primitive.NewObjectID()
+	notification.Read = false
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	_, err := m.Collection.InsertOne(ctx, notification)
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return nil
+}
+
+// ListNotifications returns the latest notifications of a user (limited)
+func (m NotificationModel) ListNotifications(userID string, unreadOnly bool) ([]Notification, error) {
+
+	filter := bson.D{{Key: "userID", Value: helpers.ObjectID(userID)}}
+	if unreadOnly {
+		filter = append(filter, bson.E{Key: "read", Value: false})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := m.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var notifications []Notification
+	err = cursor.All(ctx, &notifications)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	if notifications == nil {
+		return nil, apperror.ErrNoData
+	}
+
+	for i := range notifications {
+		notifications[i].CreatedTS = notifications[i].ID.Timestamp()
+	}
+
+	return notifications, nil
+}
+
+// MarkRead marks a user's notification as read (all of them if no ID is given)
+func (m NotificationModel) MarkRead(userID string, notificationID string) error {
+
+	filter := bson.D{
+		{Key: "userID", Value: helpers.ObjectID(userID)},
+		{Key: "read", Value: false},
+	}
+
+	if notificationID != "" {
+		notificationOID, err := primitive.ObjectIDFromHex(notificationID)
+		if err != nil {
+			return apperror.ErrNoData
+		}
+		filter = append(filter, bson.E{Key: "_id", Value: notificationOID})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	_, err := m.Collection.UpdateMany(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "read", Value: true}}}})
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return nil
+}
+
+// DeleteNotifications removes all notifications of a user (account purge)
+func (m NotificationModel) DeleteNotifications(userOID primitive.ObjectID) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	_, err := m.Collection.DeleteMany(ctx, bson.D{{Key: "userID", Value: userOID}})
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return nil
+}
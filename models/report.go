@@ -0,0 +1,277 @@
+package models
+
+import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/database"
+	"forza-garage/helpers"
+	"forza-garage/lookups"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Report is a user's complaint about some content
+type Report struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id"`
+	ReportedTS   time.Time          `json:"reportedTS" bson:"-"` // extracted from OID
+	ContentType  string             `json:"contentType" bson:"contentType" binding:"required"`
+	ContentID    string             `json:"contentId" bson:"contentId" binding:"required"` // OID or file name of uploads
+	ReasonCode   int32              `json:"reasonCode" bson:"reasonCD"`
+	ReasonText   string             `json:"reasonText" bson:"-"`
+	Remark       string             `json:"remark,omitempty" bson:"remark,omitempty"`
+	ReporterID   primitive.ObjectID `json:"-" bson:"reporterID"`
+	ReporterName string             `json:"-" bson:"reporterName"`
+	Resolved     bool               `json:"resolved" bson:"resolved"` // set after the moderator's decision
+}
+
+// ReportModel provides the logic to the interface and access to the database
+type ReportModel struct {
+	Collection     *mongo.Collection
+	GetUserNameOID func(userID primitive.ObjectID) (string, error)
+	Flag           func(contentType string, contentID string) error                // injected from moderation
+	CanView        func(contentType string, contentID string, userID string) error // injected from comments (profiles' visibility rules)
+	// owners of reported profiles per profile type (creator of review items)
+	ProfileOwners map[string]func(profileOID primitive.ObjectID) (primitive.ObjectID, error)
+}
+
+// Validate checks given values and sets defaults where applicable (immutable)
+func (m ReportModel) Validate(report Report) (*Report, error) {
+
+	cleaned := report
+
+	cleaned.ContentType = strings.ToLower(strings.TrimSpace(cleaned.ContentType))
+	cleaned.ContentID = strings.TrimSpace(cleaned.ContentID)
+	cleaned.Remark = strings.TrimSpace(cleaned.Remark)
+
+	// replies share the comments' IDs
+	if cleaned.ContentType == ContentTypeReply {
+		cleaned.ContentType = ContentTypeComment
+	}
+
+	switch cleaned.ContentType {
+	case ContentTypeComment, ContentTypeCourse, ContentTypeUser:
+		if _, err := primitive.ObjectIDFromHex(cleaned.ContentID); err != nil {
+			return nil, ErrInvalidReport
+		}
+	case ContentTypeUpload:
+		if cleaned.ContentID == "" {
+			return nil, ErrInvalidReport
+		}
+	default:
+		return nil, ErrInvalidReport
+	}
+
+	if cleaned.ReasonCode < lookups.ReportReasonSpam || cleaned.ReasonCode > lookups.ReportReasonOther {
+		return nil, ErrInvalidReport
+	}
+
+	return &cleaned, nil
+}
+
+// CreateReport saves a report (one per user and item)
+// content is flagged automatically once the threshold (REPORT_THRESHOLD) of open reports is reached
+func (m ReportModel) CreateReport(report *Report) error {
+
+	// Validate called by controller
+
+	// content is reported as it is shown to the reporter (not pending, blocked or hidden by the profile)
+	err := m.CanView(report.ContentType, report.ContentID, report.ReporterID.Hex())
+	if err != nil {
+		return err
+	}
+
+	report.ID = primitive.NewObjectID()
+	report.ReporterName, _ = m.GetUserNameOID(report.ReporterID)
+	report.Resolved = false
+
+	// upsert does not change an existing report of the same user
+	filter := bson.D{
+		{Key: "contentType", Value: report.ContentType},
+		{Key: "contentId", Value: report.ContentID},
+		{Key: "reporterID", Value: report.ReporterID},
+	}
+
+	update := bson.D{{Key: "$setOnInsert", Value: report}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	result, err := m.Collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	if result.UpsertedCount == 0 {
+		return ErrAlreadyReported
+	}
+
+	count, err := m.CountReports(report.ContentType, report.ContentID)
+	if err != nil {
+		return err
+	}
+
+	// visible content is flagged (again, if it failed before) until the moderator's decision resolves the reports
+	if count >= reportThreshold() && report.ContentType != ContentTypeCourse && report.ContentType != ContentTypeUser {
+		err = m.Flag(report.ContentType, report.ContentID)
+		if err != nil && err != apperror.ErrNoData {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CountReports returns the number of open reports of an item
+func (m ReportModel) CountReports(contentType string, contentID string) (int32, error) {
+
+	filter := bson.D{
+		{Key: "contentType", Value: contentType},
+		{Key: "contentId", Value: contentID},
+		{Key: "resolved", Value: false},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	count, err := m.Collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return int32(count), nil
+}
+
+// ResolveReports closes the open reports of an item after the moderator's decision
+func (m ReportModel) ResolveReports(contentType string, contentID string) error {
+
+	// profiles are identified by type and ID (see GetModerationSample)
+	if contentType == ContentTypeProfile {
+		contentType, contentID = splitProfileKey(contentID)
+	}
+
+	filter := bson.D{
+		{Key: "contentType", Value: contentType},
+		{Key: "contentId", Value: contentID},
+		{Key: "resolved", Value: false},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	_, err := m.Collection.UpdateMany(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "resolved", Value: true}}}})
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return nil
+}
+
+// GetModerationSample randomly chooses reported courses and users (Sampler)
+// these have no status of their own, hence the reports are reviewed
+func (m ReportModel) GetModerationSample(size int) ([]ReviewItem, error) {
+
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "contentType", Value: bson.D{{Key: "$in", Value: bson.A{ContentTypeCourse, ContentTypeUser}}}},
+			{Key: "resolved", Value: false},
+		}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{
+				{Key: "contentType", Value: "$contentType"},
+				{Key: "contentId", Value: "$contentId"},
+			}},
+			{Key: "reports", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "lastReport", Value: bson.D{{Key: "$max", Value: "$_id"}}},
+			{Key: "reasons", Value: bson.D{{Key: "$addToSet", Value: "$reasonCD"}}},
+		}}},
+		bson.D{{Key: "$match", Value: bson.D{{Key: "reports", Value: bson.D{{Key: "$gte", Value: reportThreshold()}}}}}},
+		bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: size}}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := m.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var sample []struct {
+		Key struct {
+			ContentType string `bson:"contentType"`
+			ContentID   string `bson:"contentId"`
+		} `bson:"_id"`
+		Reports    int32              `bson:"reports"`
+		LastReport primitive.ObjectID `bson:"lastReport"`
+		Reasons    []int32            `bson:"reasons"`
+	}
+
+	err = cursor.All(ctx, &sample)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	items := make([]ReviewItem, 0, len(sample))
+	for _, s := range sample {
+		profileOID := helpers.ObjectID(s.Key.ContentID)
+		profileType := s.Key.ContentType
+
+		// the reasons are shown instead of the content
+		reasons := make([]string, len(s.Reasons))
+		for i, r := range s.Reasons {
+			reasons[i] = database.GetLookupText(lookups.LookupType(lookups.LTreportReason), r)
+		}
+
+		item := ReviewItem{
+			ParentID:    &profileOID,
+			ParentType:  &profileType,
+			ContentID:   profileType + "_" + s.Key.ContentID,
+			ContentType: ContentTypeProfile,
+			Content:     strings.Join(reasons, ", "),
+			StatusCode:  lookups.CommentStatusFlagged,
+			StatusTS:    s.LastReport.Timestamp(),
+			PostReports: s.Reports,
+		}
+
+		if getOwner, ok := m.ProfileOwners[profileType]; ok {
+			item.CreatorID, _ = getOwner(profileOID)
+			item.CreatorName, _ = m.GetUserNameOID(item.CreatorID)
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// GetModerationStatus is not applicable, profiles have no status (Sampler)
+func (m ReportModel) GetModerationStatus(contentID string) (int32, error) {
+	return 0, apperror.ErrNoData
+}
+
+// SetModerationStatus applies a moderator's decision to a reported profile (Sampler)
+// profiles can't be blocked, any decision closes the reports (see ResolveReports)
+func (m ReportModel) SetModerationStatus(contentID string, statusCode int32, executiveOID primitive.ObjectID, executiveName string) error {
+	return nil
+}
+
+// internal helpers
+
+// number of open reports to flag an item
+func reportThreshold() int32 {
+	return int32(helpers.IntSetting("REPORT_THRESHOLD", 3, 1))
+}
+
+// splits the key of a profile's review item (type_id)
+func splitProfileKey(key string) (profileType string, profileID string) {
+	i := strings.LastIndex(key, "_")
+	if i < 0 {
+		return "", key
+	}
+	return key[:i], key[i+1:]
+}
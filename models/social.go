@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -18,3 +19,21 @@ type Social = struct {
 	DownVotes  int32
 	TouchedTS  time.Time // a vote updates the "touched" info, not the "modified"
 }
+
+// VoteProfile is a voted profile as seen by the voting model
+type VoteProfile struct {
+	OwnerID   primitive.ObjectID
+	UpVotes   int32 // counters maintained by the voting model
+	DownVotes int32
+}
+
+// Votable is implemented by the domains whose profiles can be voted (courses, comments)
+// the counter functions are called within the voting model's transaction, hence they use the passed context
+type Votable interface {
+	// GetVoteProfile applies the domain's visibility rules and returns the profile's owner and counters
+	GetVoteProfile(profileOID primitive.ObjectID, userID string) (*VoteProfile, error)
+	// IncVotes adjusts the vote counters of a profile ($inc) and returns their new values
+	IncVotes(ctx context.Context, profileOID primitive.ObjectID, up int32, down int32) (*Social, error)
+	// SetRating saves the rating calculated from the counters (and the counters of recounts)
+	SetRating(ctx context.Context, social *Social) error
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"forza-garage/helpers"
+	"forza-garage/logging"
+	"forza-garage/storage"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// deduplication of uploaded files
+// blobs are named by the SHA-256 of their content, hence identical files (eg. the same screenshot
+// uploaded to several courses) share a blob. the uploads referencing a blob are counted in the
+// "blobs" collection; a blob is deleted when its last reference is released.
+// staged and active blobs are counted separately (key incl. prefix).
+
+// blobRef is the reference count of a blob
+type blobRef struct {
+	Key       string    `bson:"_id"` // blob key
+	Refs      int32     `bson:"refs"`
+	CreatedTS time.Time `bson:"createdTS"`
+	UpdatedTS time.Time `bson:"updatedTS"` // last change of the count (garbage collector)
+}
+
+// adds a reference to a blob, the content is only saved if it's not stored yet
+func (m UploadModel) acquireBlob(key string, content io.Reader, size int64, contentType string) error {
+
+	created, err := m.incRefs(key)
+	if err != nil {
+		return err
+	}
+
+	// the blob might have been lost (eg. removed by hand)
+	if !created {
+		_, err = m.Blobs.Stat(key)
+		if err == nil {
+			return nil
+		}
+		if err != storage.ErrNotFound {
+			m.releaseBlob(key)
+			return helpers.WrapError(err, helpers.FuncName())
+		}
+	}
+
+	err = m.Blobs.Put(key, content, size, contentType)
+	if err != nil {
+		m.releaseBlob(key)
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return nil
+}
+
+// moves a reference from a staged blob to the active one, the content is copied if necessary
+func (m UploadModel) promoteBlob(name string) error {
+
+	srcKey, dstKey := blobKey(flStage, name), blobKey(flActive, name)
+
+	created, err := m.incRefs(dstKey)
+	if err != nil {
+		return err
+	}
+
+	if !created {
+		_, err = m.Blobs.Stat(dstKey)
+	}
+	if created || err == storage.ErrNotFound {
+		// the staged blob may still be referenced by other uploads, hence it's copied
+		err = m.copyBlob(srcKey, dstKey)
+	}
+	if err != nil {
+		m.releaseBlob(dstKey)
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	m.releaseBlob(srcKey)
+
+	return nil
+}
+
+// removes a reference from a blob and deletes the blob if it was the last one
+// errors are logged only, leftovers are removed by the garbage collector
+func (m UploadModel) releaseBlob(key string) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	var ref blobRef
+	err := m.Refs.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: key}},
+		bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "refs", Value: -1}}},
+			{Key: "$set", Value: bson.D{{Key: "updatedTS", Value: time.Now()}}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&ref)
+	if err != nil {
+		m.Log.Err(helpers.WrapError(err, helpers.FuncName()), logging.Fields{"key": key})
+		return
+	}
+
+	if ref.Refs > 0 {
+		return
+	}
+
+	// the blob is deleted first, a concurrent upload of the same content puts it again (see acquireBlob)
+	err = m.Blobs.Delete(key)
+	if err != nil {
+		m.Log.Err(helpers.WrapError(err, helpers.FuncName()), logging.Fields{"key": key})
+		return
+	}
+
+	_, err = m.Refs.DeleteOne(ctx, bson.D{
+		{Key: "_id", Value: key},
+		{Key: "refs", Value: bson.D{{Key: "$lte", Value: 0}}},
+	})
+	if err != nil {
+		m.Log.Err(helpers.WrapError(err, helpers.FuncName()), logging.Fields{"key": key})
+	}
+}
+
+// increments the reference count, created is true for new blobs
+func (m UploadModel) incRefs(key string) (created bool, err error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	now := time.Now()
+	err = m.Refs.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: key}},
+		bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "refs", Value: 1}}},
+			{Key: "$set", Value: bson.D{{Key: "updatedTS", Value: now}}},
+			{Key: "$setOnInsert", Value: bson.D{{Key: "createdTS", Value: now}}},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)).Err()
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return true, nil // upserted
+		}
+		return false, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return false, nil
+}
+
+func (m UploadModel) copyBlob(srcKey string, dstKey string) error {
+
+	content, info, err := m.Blobs.Get(srcKey)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return m.Blobs.Put(dstKey, content, info.Size, info.ContentType)
+}
+
+// SHA-256 of a file (hex)
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// same hash, the content is read in parts
+func readerHash(content io.Reader) (string, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, content)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,273 @@
+package models
+
+import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/filter"
+	"forza-garage/helpers"
+	"forza-garage/lookups"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// gallery of a profile's uploads (eg. course screenshots)
+// the profile's owner (and admins) may reorder the slots and choose the cover, uploaders may edit their descriptions.
+// a slot is identified by the file name of its active or staged file
+
+// SortUploads arranges the slots in the order of the given file names (all slots must be listed)
+func (m UploadModel) SortUploads(profileID string, fileNames []string, executiveUserID string) error {
+
+	header, err := m.getHeader(helpers.ObjectID(profileID))
+	if err != nil {
+		return err
+	}
+
+	err = m.checkOwner(header, executiveUserID)
+	if err != nil {
+		return err
+	}
+
+	if len(fileNames) != len(header.Slots) {
+		return ErrInvalidOrder
+	}
+
+	slots := make([]Slot, 0, len(header.Slots))
+	used := make(map[int]bool)
+	for _, name := range fileNames {
+		index, location, _ := m.findFile(header.Slots, name)
+		if location == flUndefined || used[index] {
+			return ErrInvalidOrder
+		}
+		used[index] = true
+		slots = append(slots, header.Slots[index])
+	}
+
+	return m.replaceSlots(header, slots)
+}
+
+// SetCover marks a slot as the profile's cover (shown in lists)
+func (m UploadModel) SetCover(profileID string, fileName string, executiveUserID string) error {
+
+	header, err := m.getHeader(helpers.ObjectID(profileID))
+	if err != nil {
+		return err
+	}
+
+	err = m.checkOwner(header, executiveUserID)
+	if err != nil {
+		return err
+	}
+
+	index, location, _ := m.findFile(header.Slots, fileName)
+	if location == flUndefined {
+		return apperror.ErrNoData
+	}
+
+	slots := make([]Slot, len(header.Slots))
+	copy(slots, header.Slots)
+	for i := range slots {
+		slots[i].Cover = i == index
+	}
+
+	return m.replaceSlots(header, slots)
+}
+
+// SetDescription changes the description of a file (uploader & admins)
+// held texts are reviewed by the moderators: active files are flagged (and remain visible), staged ones are pending anyway
+func (m UploadModel) SetDescription(profileID string, fileName string, description string, executiveUserID string) error {
+
+	header, err := m.getHeader(helpers.ObjectID(profileID))
+	if err != nil {
+		return err
+	}
+
+	index, location, file := m.findFile(header.Slots, fileName)
+	if location == flUndefined {
+		return apperror.ErrNoData
+	}
+
+	executiveUserOID := helpers.ObjectID(executiveUserID)
+	cred := m.GetCredentials(executiveUserOID, false)
+	if cred == nil || !(file.UploadedID == executiveUserOID || cred.RoleCode == lookups.UserRoleAdmin) {
+		return apperror.ErrDenied
+	}
+
+	area := "slots." + strconv.Itoa(index) + ".active"
+	if location == flStage {
+		area = "slots." + strconv.Itoa(index) + ".staged"
+	}
+
+	values := bson.D{}
+	if description != "" {
+		checked := m.Filter.Check(description, cred.LanguageCode)
+		description = checked.Text
+		if checked.Verdict == filter.Hold && location == flActive && file.StatusCode == lookups.CommentStatusVisible {
+			values = append(values,
+				bson.E{Key: area + ".statusCD", Value: lookups.CommentStatusFlagged},
+				bson.E{Key: area + ".statusTS", Value: time.Now()},
+			)
+		}
+	}
+	values = append(values, bson.E{Key: area + ".description", Value: description})
+
+	// the file name is part of the filter, in case the slots were changed in the meantime
+	filter := bson.D{
+		{Key: "_id", Value: header.ID},
+		{Key: area + ".fileName", Value: fileName},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	result, err := m.Collection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: values}})
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrRecordChanged
+	}
+
+	return nil
+}
+
+// GetCovers returns the URLs of the profiles' cover thumbnails (lists)
+// the first approved file is used if no cover was chosen
+func (m UploadModel) GetCovers(profileOIDs []primitive.ObjectID) (map[primitive.ObjectID]string, error) {
+
+	fields := bson.D{
+		{Key: "profileID", Value: 1},
+		{Key: "slots", Value: 1},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := m.Collection.Find(ctx,
+		bson.D{{Key: "profileID", Value: bson.D{{Key: "$in", Value: profileOIDs}}}},
+		options.Find().SetProjection(fields))
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var headers []UploadHeader
+	err = cursor.All(ctx, &headers)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	covers := make(map[primitive.ObjectID]string, len(headers))
+	for _, h := range headers {
+		var cover *UploadInfo
+		for _, s := range h.Slots {
+			if s.Active == nil || s.Active.StatusCode == lookups.CommentStatusBlocked {
+				continue
+			}
+			if cover == nil || s.Cover {
+				cover = s.Active
+			}
+			if s.Cover {
+				break
+			}
+		}
+		if cover == nil {
+			continue
+		}
+
+		// files uploaded before the renditions were introduced have no thumbnail (clips without poster neither)
+		if mediaType(cover) == MediaTypeImage {
+			covers[h.ProfileID] = m.fileURL(flActive, blobName(cover))
+		}
+		for _, r := range cover.Renditions {
+			if r.Name == thumbnailSpec.Name {
+				covers[h.ProfileID] = m.fileURL(flActive, renditionBlobName(r))
+			}
+		}
+	}
+
+	return covers, nil
+}
+
+// internal helpers
+
+func (m UploadModel) getHeader(profileOID primitive.ObjectID) (*UploadHeader, error) {
+
+	var header UploadHeader
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	err := m.Collection.FindOne(ctx, bson.D{{Key: "profileID", Value: profileOID}}).Decode(&header)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, apperror.ErrNoData
+		}
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return &header, nil
+}
+
+// the executive user must be the owner of the profile or an admin
+func (m UploadModel) checkOwner(header *UploadHeader, executiveUserID string) error {
+
+	cred := m.GetCredentials(helpers.ObjectID(executiveUserID), false)
+	if cred == nil {
+		return apperror.ErrDenied
+	}
+	if cred.RoleCode == lookups.UserRoleAdmin {
+		return nil
+	}
+
+	access, ok := m.Profiles[header.ProfileType]
+	if !ok {
+		return apperror.ErrDenied
+	}
+
+	profile, err := access(header.ProfileID.Hex(), executiveUserID)
+	if err != nil {
+		return err
+	}
+	if profile.OwnerID != cred.UserID {
+		return apperror.ErrDenied
+	}
+
+	return nil
+}
+
+// saves the rearranged slots, unless the files were changed in the meantime
+func (m UploadModel) replaceSlots(header *UploadHeader, slots []Slot) error {
+
+	filter := bson.D{
+		{Key: "_id", Value: header.ID},
+		{Key: "slots", Value: bson.D{{Key: "$size", Value: len(header.Slots)}}},
+	}
+	for i, s := range header.Slots {
+		slot := "slots." + strconv.Itoa(i)
+		if s.Active != nil {
+			filter = append(filter, bson.E{Key: slot + ".active.fileName", Value: s.Active.SysFileName})
+		}
+		if s.Staged != nil {
+			filter = append(filter, bson.E{Key: slot + ".staged.fileName", Value: s.Staged.SysFileName})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	result, err := m.Collection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "slots", Value: slots}}}})
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrRecordChanged
+	}
+
+	return nil
+}
@@ -0,0 +1,292 @@
+package models
+
+import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/helpers"
+	"forza-garage/logging"
+	"forza-garage/lookups"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// garbage collection of the blob store
+// blobs which aren't referenced by any upload (orphans) are deleted or moved to "quarantine/" (UPLOAD_GC_ACTION).
+// they're left by failed uploads, crashes or blobs that couldn't be deleted.
+// blobs and reference counts changed within the grace period (UPLOAD_GC_GRACE_HOURS) are skipped,
+// they might belong to uploads in progress (blobs are saved before their metadata)
+// ToDo: purge quarantined blobs after some time
+
+// actions applied to orphans
+const (
+	GarbageQuarantine = "quarantine"
+	GarbageDelete     = "delete"
+)
+
+// key prefix of quarantined blobs
+const blobQuarantine = "quarantine/"
+
+// GarbageReport is the result of a garbage collection
+type GarbageReport struct {
+	DryRun      bool         `json:"dryRun"` // nothing was changed
+	Action      string       `json:"action"`
+	StartedTS   time.Time    `json:"startedTS"`
+	Blobs       int          `json:"blobs"`      // scanned
+	Referenced  int          `json:"referenced"` // by uploads
+	Orphans     []OrphanBlob `json:"orphans"`
+	OrphanBytes int64        `json:"orphanBytes"`
+	Missing     []string     `json:"missing"`   // referenced, but not found in the store
+	RefsFixed   int          `json:"refsFixed"` // corrected reference counts
+}
+
+// OrphanBlob is a blob without upload
+type OrphanBlob struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// GetGarbageReport lists the orphans without removing them (admins only)
+func (m UploadModel) GetGarbageReport(executiveUserID string) (*GarbageReport, error) {
+
+	cred := m.GetCredentials(helpers.ObjectID(executiveUserID), false)
+	if cred == nil || cred.RoleCode != lookups.UserRoleAdmin {
+		return nil, apperror.ErrDenied
+	}
+
+	return m.CollectGarbage(true)
+}
+
+// CollectGarbage reconciles the blob store and the reference counts against the uploads (scheduled)
+func (m UploadModel) CollectGarbage(dryRun bool) (*GarbageReport, error) {
+
+	report := GarbageReport{
+		DryRun:    dryRun,
+		Action:    GarbageQuarantine,
+		StartedTS: time.Now(),
+		Orphans:   []OrphanBlob{},
+		Missing:   []string{},
+	}
+	if os.Getenv("UPLOAD_GC_ACTION") == GarbageDelete {
+		report.Action = GarbageDelete
+	}
+
+	graceHours := helpers.IntSetting("UPLOAD_GC_GRACE_HOURS", 24, 1)
+	graceTS := report.StartedTS.Add(-time.Duration(graceHours) * time.Hour)
+
+	// 1. references of the uploads (older uploads aren't counted)
+	referenced, counted, err := m.collectReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. reference counts
+	refs, err := m.collectRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. blobs
+	stored := make(map[string]bool)
+	for _, prefix := range []string{blobStaged, blobActive} {
+		blobs, err := m.Blobs.List(prefix)
+		if err != nil {
+			return nil, helpers.WrapError(err, helpers.FuncName())
+		}
+
+		for _, b := range blobs {
+			report.Blobs++
+			stored[b.Key] = true
+
+			if referenced[b.Key] {
+				report.Referenced++
+				continue
+			}
+			if b.LastModified.After(graceTS) {
+				continue
+			}
+			if ref, ok := refs[b.Key]; ok && ref.UpdatedTS.After(graceTS) {
+				continue
+			}
+
+			report.Orphans = append(report.Orphans, OrphanBlob{Key: b.Key, Size: b.Size, LastModified: b.LastModified})
+			report.OrphanBytes += b.Size
+		}
+	}
+
+	for key := range referenced {
+		if !stored[key] {
+			report.Missing = append(report.Missing, key)
+		}
+	}
+
+	// 4. counts which don't match the uploads (orphans' counts are removed with them)
+	var fixes []blobRef
+	for key, ref := range refs {
+		if ref.Refs != counted[key] && ref.UpdatedTS.Before(graceTS) && (referenced[key] || !stored[key]) {
+			fixes = append(fixes, ref)
+		}
+	}
+	for key := range counted {
+		if _, ok := refs[key]; !ok && stored[key] {
+			fixes = append(fixes, blobRef{Key: key, Refs: -1}) // missing count
+		}
+	}
+	report.RefsFixed = len(fixes)
+
+	if dryRun {
+		return &report, nil
+	}
+
+	for _, orphan := range report.Orphans {
+		err = m.removeOrphan(orphan.Key, refs, report.Action)
+		if err != nil {
+			m.Log.Err(helpers.WrapError(err, helpers.FuncName()), logging.Fields{"key": orphan.Key})
+		}
+	}
+
+	for _, fix := range fixes {
+		err = m.fixRefs(fix, counted[fix.Key])
+		if err != nil {
+			m.Log.Err(helpers.WrapError(err, helpers.FuncName()), logging.Fields{"key": fix.Key})
+		}
+	}
+
+	return &report, nil
+}
+
+// internal helpers
+
+// keys of all blobs referenced by uploads and the number of references of the content-addressed ones
+func (m UploadModel) collectReferences() (map[string]bool, map[string]int32, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel() // nach 60 Sekunden abbrechen
+
+	cursor, err := m.Collection.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, nil, helpers.WrapError(err, helpers.FuncName())
+	}
+	defer cursor.Close(ctx)
+
+	referenced := make(map[string]bool)
+	counted := make(map[string]int32)
+	for cursor.Next(ctx) {
+		var header UploadHeader
+		err = cursor.Decode(&header)
+		if err != nil {
+			return nil, nil, helpers.WrapError(err, helpers.FuncName())
+		}
+
+		for _, s := range header.Slots {
+			for location, info := range map[int]*UploadInfo{flStage: s.Staged, flActive: s.Active} {
+				if info == nil {
+					continue
+				}
+				for _, name := range blobNames(info) {
+					key := blobKey(location, name)
+					referenced[key] = true
+					if info.BlobName != "" {
+						counted[key]++
+					}
+				}
+			}
+		}
+	}
+
+	return referenced, counted, cursor.Err()
+}
+
+func (m UploadModel) collectRefs() (map[string]blobRef, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel() // nach 60 Sekunden abbrechen
+
+	cursor, err := m.Refs.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var data []blobRef
+	err = cursor.All(ctx, &data)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	refs := make(map[string]blobRef, len(data))
+	for _, r := range data {
+		refs[r.Key] = r
+	}
+
+	return refs, nil
+}
+
+// the count is removed first; if it was changed in the meantime, the blob is in use again
+func (m UploadModel) removeOrphan(key string, refs map[string]blobRef, action string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	if ref, ok := refs[key]; ok {
+		result, err := m.Refs.DeleteOne(ctx, bson.D{
+			{Key: "_id", Value: key},
+			{Key: "refs", Value: ref.Refs},
+			{Key: "updatedTS", Value: ref.UpdatedTS},
+		})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return nil
+		}
+	}
+
+	if action == GarbageDelete {
+		return m.Blobs.Delete(key)
+	}
+	return m.Blobs.Move(key, blobQuarantine+key)
+}
+
+// sets the count of the uploads, unless it was changed in the meantime
+func (m UploadModel) fixRefs(ref blobRef, count int32) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	// missing count, unless it was created by an upload in the meantime
+	if ref.Refs < 0 {
+		now := time.Now()
+		_, err := m.Refs.UpdateOne(ctx,
+			bson.D{{Key: "_id", Value: ref.Key}},
+			bson.D{{Key: "$setOnInsert", Value: bson.D{
+				{Key: "refs", Value: count},
+				{Key: "createdTS", Value: now},
+				{Key: "updatedTS", Value: now},
+			}}},
+			options.Update().SetUpsert(true))
+		return err
+	}
+
+	filter := bson.D{
+		{Key: "_id", Value: ref.Key},
+		{Key: "refs", Value: ref.Refs},
+		{Key: "updatedTS", Value: ref.UpdatedTS},
+	}
+
+	// blob is neither referenced nor stored
+	if count == 0 {
+		_, err := m.Refs.DeleteOne(ctx, filter)
+		return err
+	}
+
+	_, err := m.Refs.UpdateOne(ctx, filter, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "refs", Value: count},
+			{Key: "updatedTS", Value: time.Now()},
+		}},
+	})
+	return err
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"forza-garage/helpers"
+	"forza-garage/imaging"
+	"forza-garage/video"
+	"io"
+	"time"
+)
+
+// media types of the uploads
+// images are re-encoded into renditions; clips are stored as uploaded, their poster (if the container
+// embeds one) is processed like an image. the first rendition is the uploaded file (SysFileName).
+const (
+	MediaTypeImage = "image"
+	MediaTypeVideo = "video"
+)
+
+// display size of a clip's poster frame
+var posterSpec = imaging.Spec{Name: "poster", Width: 1920, Height: 1920}
+
+func processImage(data []byte, profileType string, quota StorageQuota, uploadInfo *UploadInfo) ([]imaging.Rendition, error) {
+
+	if quota.MaxFileSize > 0 && int64(len(data)) > quota.MaxFileSize {
+		return nil, ErrFileTooLarge
+	}
+
+	specs := []imaging.Spec{displaySpec, thumbnailSpec}
+	if profileType == ContentTypeUser {
+		specs = append(specs, avatarSpec)
+	}
+
+	renditions, err := imaging.Process(data, specs)
+	if err != nil {
+		switch err {
+		case imaging.ErrUnsupported:
+			return nil, ErrUnsupportedFile
+		case imaging.ErrTooLarge:
+			return nil, ErrFileTooLarge
+		}
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	uploadInfo.MediaType = MediaTypeImage
+	uploadInfo.Width = int32(renditions[0].Width)
+	uploadInfo.Height = int32(renditions[0].Height)
+	uploadInfo.Duration = 0
+	uploadInfo.Codec = ""
+
+	return renditions, nil
+}
+
+// clips are limited by UPLOAD_MAX_VIDEO_MB_<ROLE> and UPLOAD_MAX_VIDEO_SECONDS
+// ToDo: metadata of the containers (eg. GPS of phone recordings) is stored as uploaded
+// the clip itself isn't loaded (no data in the first rendition), it's stored from the content
+func processClip(content io.ReaderAt, size int64, profileType string, quota StorageQuota, uploadInfo *UploadInfo) ([]imaging.Rendition, error) {
+
+	// profile pictures are images only
+	if profileType == ContentTypeUser {
+		return nil, ErrUnsupportedFile
+	}
+
+	if quota.MaxVideoSize > 0 && size > quota.MaxVideoSize {
+		return nil, ErrFileTooLarge
+	}
+
+	info, err := video.Probe(content, size)
+	if err != nil {
+		return nil, ErrUnsupportedFile
+	}
+
+	if maxDuration := maxClipDuration(); maxDuration > 0 && info.Duration > maxDuration {
+		return nil, ErrClipTooLong
+	}
+
+	renditions := []imaging.Rendition{{
+		Name:        MediaTypeVideo,
+		Width:       info.Width,
+		Height:      info.Height,
+		ContentType: info.ContentType,
+		Ext:         info.Ext,
+	}}
+
+	// clips without (valid) poster are shown with a placeholder by the client
+	if info.Poster != nil {
+		posters, err := imaging.Process(info.Poster, []imaging.Spec{posterSpec, thumbnailSpec})
+		if err == nil {
+			renditions = append(renditions, posters...)
+		}
+	}
+
+	uploadInfo.MediaType = MediaTypeVideo
+	uploadInfo.Width = int32(info.Width)
+	uploadInfo.Height = int32(info.Height)
+	uploadInfo.Duration = info.Duration.Seconds()
+	uploadInfo.Codec = info.Codec
+
+	return renditions, nil
+}
+
+// the media data of a file returned to the client
+func setMedia(fileInfo *FileInfo, info *UploadInfo) {
+	fileInfo.MediaType = mediaType(info)
+	fileInfo.Width = info.Width
+	fileInfo.Height = info.Height
+	fileInfo.Duration = info.Duration
+}
+
+// uploads saved before clips were introduced are images
+func mediaType(info *UploadInfo) string {
+	if info.MediaType == "" {
+		return MediaTypeImage
+	}
+	return info.MediaType
+}
+
+func maxClipDuration() time.Duration {
+	return time.Duration(helpers.IntSetting("UPLOAD_MAX_VIDEO_SECONDS", 120, 0)) * time.Second
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/helpers"
+	"forza-garage/lookups"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// storage quotas of the users (per role)
+// the usage is kept on the user document (storage.bytes, storage.files) and changed atomically
+// by the upload model: reserved before a file is saved, released when it's deleted.
+// every upload counts as one file, its bytes are the sum of all renditions
+// ToDo: uploads saved before the quotas were introduced are not counted (no size in their metadata)
+
+// StorageQuota contains the limits of a role, zero means unlimited
+type StorageQuota struct {
+	MaxBytes     int64 `json:"maxBytes"`
+	MaxFiles     int32 `json:"maxFiles"`
+	MaxFileSize  int64 `json:"maxFileSize"`  // uploaded image (before processing)
+	MaxVideoSize int64 `json:"maxVideoSize"` // uploaded clip
+}
+
+// StorageUsage is what's reported to the user
+type StorageUsage struct {
+	UsedBytes int64        `json:"usedBytes"`
+	Files     int32        `json:"files"`
+	Quota     StorageQuota `json:"quota"`
+}
+
+// GetStorageQuota returns the limits of a role, set by UPLOAD_QUOTA_MB_<ROLE>, UPLOAD_QUOTA_FILES_<ROLE>,
+// UPLOAD_MAX_MB_<ROLE> and UPLOAD_MAX_VIDEO_MB_<ROLE> (GUEST, MEMBER, ADMIN)
+func GetStorageQuota(roleCode int32) StorageQuota {
+
+	// defaults
+	var role string
+	var quota StorageQuota
+	switch roleCode {
+	case lookups.UserRoleAdmin:
+		role = "ADMIN"
+		quota = StorageQuota{MaxBytes: 5000 << 20, MaxFiles: 5000, MaxFileSize: 50 << 20, MaxVideoSize: 500 << 20}
+	case lookups.UserRoleMember:
+		role = "MEMBER"
+		quota = StorageQuota{MaxBytes: 500 << 20, MaxFiles: 500, MaxFileSize: 20 << 20, MaxVideoSize: 100 << 20}
+	default:
+		role = "GUEST"
+		quota = StorageQuota{MaxBytes: 20 << 20, MaxFiles: 20, MaxFileSize: 5 << 20, MaxVideoSize: 20 << 20}
+	}
+
+	// the former global limit is used as default for the file size
+	quota.MaxFileSize = helpers.Int64Setting("UPLOAD_MAX_BYTES", quota.MaxFileSize, 1)
+
+	quota.MaxBytes = quotaSetting("UPLOAD_QUOTA_MB_"+role, quota.MaxBytes, 1<<20)
+	quota.MaxFiles = int32(quotaSetting("UPLOAD_QUOTA_FILES_"+role, int64(quota.MaxFiles), 1))
+	quota.MaxFileSize = quotaSetting("UPLOAD_MAX_MB_"+role, quota.MaxFileSize, 1<<20)
+	quota.MaxVideoSize = quotaSetting("UPLOAD_MAX_VIDEO_MB_"+role, quota.MaxVideoSize, 1<<20)
+
+	return quota
+}
+
+// ReserveStorage adds an upload to a user's storage usage, if it doesn't exceed the quota
+// the quota is part of the filter, hence concurrent uploads can't exceed it
+func (m UserModel) ReserveStorage(userOID primitive.ObjectID, size int64, quota StorageQuota) error {
+
+	if quota.MaxBytes > 0 && size > quota.MaxBytes {
+		return ErrStorageQuotaExceeded
+	}
+
+	// $not also matches users without usage (missing fields)
+	filter := bson.D{{Key: "_id", Value: userOID}}
+	if quota.MaxBytes > 0 {
+		filter = append(filter, bson.E{Key: "storage.bytes", Value: bson.D{
+			{Key: "$not", Value: bson.D{{Key: "$gt", Value: quota.MaxBytes - size}}},
+		}})
+	}
+	if quota.MaxFiles > 0 {
+		filter = append(filter, bson.E{Key: "storage.files", Value: bson.D{
+			{Key: "$not", Value: bson.D{{Key: "$gte", Value: quota.MaxFiles}}},
+		}})
+	}
+
+	fields := bson.D{
+		{Key: "$inc", Value: bson.D{
+			{Key: "storage.bytes", Value: size},
+			{Key: "storage.files", Value: 1},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	result, err := m.Collection.UpdateOne(ctx, filter, fields)
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrStorageQuotaExceeded // or the user doesn't exist (anymore)
+	}
+
+	return nil
+}
+
+// ReleaseStorage removes a deleted upload from a user's storage usage
+func (m UserModel) ReleaseStorage(userOID primitive.ObjectID, size int64) error {
+
+	fields := bson.D{
+		{Key: "$inc", Value: bson.D{
+			{Key: "storage.bytes", Value: -size},
+			{Key: "storage.files", Value: -1},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	_, err := m.Collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: userOID}}, fields)
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return nil
+}
+
+// GetStorageUsage returns a user's storage usage and the quota of the role
+func (m UserModel) GetStorageUsage(userID string) (*StorageUsage, error) {
+
+	data := struct {
+		RoleCode int32 `bson:"roleCD"`
+		Storage  struct {
+			Bytes int64 `bson:"bytes"`
+			Files int32 `bson:"files"`
+		} `bson:"storage"`
+	}{}
+
+	fields := bson.D{
+		{Key: "_id", Value: 0},
+		{Key: "roleCD", Value: 1},
+		{Key: "storage", Value: 1},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	err := m.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: helpers.ObjectID(userID)}}, options.FindOne().SetProjection(fields)).Decode(&data)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, apperror.ErrNoData
+		}
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return &StorageUsage{
+		UsedBytes: data.Storage.Bytes,
+		Files:     data.Storage.Files,
+		Quota:     GetStorageQuota(data.RoleCode),
+	}, nil
+}
+
+// internal helpers
+
+// the larger of the file size limits (the type of an upload isn't known before it's read)
+func (q StorageQuota) maxUploadSize() int64 {
+	if q.MaxFileSize == 0 || q.MaxVideoSize == 0 {
+		return 0
+	}
+	if q.MaxVideoSize > q.MaxFileSize {
+		return q.MaxVideoSize
+	}
+	return q.MaxFileSize
+}
+
+// settings are given in units (MiB), defaults in bytes
+func quotaSetting(name string, defaultValue int64, unit int64) int64 {
+	value := helpers.Int64Setting(name, -1, 0) // missing or invalid
+	if value < 0 {
+		return defaultValue
+	}
+	return value * unit
+}
@@ -0,0 +1,390 @@
+package models
+
+import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/helpers"
+	"forza-garage/lookups"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// resumable uploads (tus-style protocol)
+// large files or uploads over flaky connections are sent in chunks: the upload is created with its length,
+// the chunks are appended at the current offset, which the client may query to resume after an interruption.
+// the content is collected in a file of UPLOAD_STAGE (server-local, the blob store can't append) and passed
+// to StoreFile when it's complete. uploads which aren't continued within UPLOAD_RESUMABLE_HOURS expire.
+
+// ResumableUpload is an upload in progress
+type ResumableUpload struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id"` // also the name of the file in UPLOAD_STAGE
+	ProfileID    string             `json:"profileId" bson:"profileID"`
+	ProfileType  string             `json:"profileType" bson:"profileType"`
+	UploadedID   primitive.ObjectID `json:"-" bson:"uploadedID"`
+	SysFileName  string             `json:"-" bson:"fileName"` // without extension (see StoreFile)
+	OrigFileName string             `json:"fileName" bson:"origFileName"`
+	Description  string             `json:"description" bson:"description,omitempty"`
+	Length       int64              `json:"length" bson:"length"`
+	Offset       int64              `json:"offset" bson:"offset"` // bytes received
+	CreatedTS    time.Time          `json:"createdTS" bson:"createdTS"`
+	ExpiresTS    time.Time          `json:"expiresTS" bson:"expiresTS"`  // extended by every chunk
+	LockedTS     *time.Time         `json:"-" bson:"lockedTS,omitempty"` // a chunk is being written
+}
+
+// open uploads per user
+const maxResumables = 5
+
+// a lock older than this is left by a crashed request
+const resumableLockTimeout = 30 * time.Minute
+
+// CreateResumable registers an upload of the given length, the metadata is prepared like for StoreFile
+// the metadata is sent by the client, hence the profile is checked before anything is saved:
+// the type must be one of the galleries, the uploader must be the profile's owner (or an admin)
+func (m UploadModel) CreateResumable(profileID string, profileType string, uploadInfo *UploadInfo, length int64) (*ResumableUpload, error) {
+
+	cred := m.GetCredentials(uploadInfo.UploadedID, false)
+	if cred == nil {
+		return nil, apperror.ErrDenied
+	}
+
+	access, ok := m.Profiles[profileType]
+	if !ok {
+		return nil, ErrInvalidUploadProfile
+	}
+	profile, err := access(profileID, uploadInfo.UploadedID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if profile.OwnerID != cred.UserID && cred.RoleCode != lookups.UserRoleAdmin {
+		return nil, apperror.ErrDenied
+	}
+
+	if length <= 0 {
+		return nil, ErrUnsupportedFile
+	}
+	quota := GetStorageQuota(cred.RoleCode)
+	if maxSize := quota.maxUploadSize(); maxSize > 0 && length > maxSize {
+		return nil, ErrFileTooLarge
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	count, err := m.Resumables.CountDocuments(ctx, bson.D{
+		{Key: "uploadedID", Value: uploadInfo.UploadedID},
+		{Key: "expiresTS", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+	})
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+	if count >= maxResumables {
+		return nil, ErrMaximumFilesReached
+	}
+
+	now := time.Now()
+	upload := ResumableUpload{
+		ID:           primitive.NewObjectID(),
+		ProfileID:    profileID,
+		ProfileType:  profileType,
+		UploadedID:   uploadInfo.UploadedID,
+		SysFileName:  uploadInfo.SysFileName,
+		OrigFileName: uploadInfo.OrigFileName,
+		Description:  uploadInfo.Description,
+		Length:       length,
+		CreatedTS:    now,
+		ExpiresTS:    now.Add(resumableTTL()),
+	}
+
+	// the file is created first, hence every registered upload has one
+	dir := resumableDir()
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, upload.ID.Hex()), nil, 0600)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	_, err = m.Resumables.InsertOne(ctx, upload)
+	if err != nil {
+		os.Remove(filepath.Join(dir, upload.ID.Hex()))
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return &upload, nil
+}
+
+// GetResumable returns the state of an upload (uploader only)
+func (m UploadModel) GetResumable(uploadID string, userID string) (*ResumableUpload, error) {
+
+	var upload ResumableUpload
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	err := m.Resumables.FindOne(ctx, bson.D{{Key: "_id", Value: helpers.ObjectID(uploadID)}}).Decode(&upload)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, apperror.ErrNoData
+		}
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// expired uploads are purged regularly
+	if upload.ExpiresTS.Before(time.Now()) {
+		return nil, apperror.ErrNoData
+	}
+	if upload.UploadedID != helpers.ObjectID(userID) {
+		return nil, apperror.ErrDenied
+	}
+
+	return &upload, nil
+}
+
+// WriteResumable appends a chunk at the given offset (size is -1 if unknown)
+// the received bytes are kept even if the transfer is interrupted; the upload is stored when it's complete
+// (uploadInfo is returned). if that fails for a temporary reason, it's repeated by an empty chunk at the end.
+func (m UploadModel) WriteResumable(uploadID string, userID string, offset int64, size int64, content io.Reader) (*ResumableUpload, *UploadInfo, error) {
+
+	upload, err := m.GetResumable(uploadID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offset != upload.Offset {
+		return upload, nil, ErrUploadOffset
+	}
+	if size > upload.Length-upload.Offset {
+		return upload, nil, ErrFileTooLarge
+	}
+
+	// only one chunk is written at a time
+	err = m.lockResumable(upload)
+	if err != nil {
+		return upload, nil, err
+	}
+
+	written, err := writeChunk(upload, content)
+	upload.Offset += written
+	upload.ExpiresTS = time.Now().Add(resumableTTL())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	// the lock is kept while the upload is stored
+	_, updErr := m.Resumables.UpdateOne(ctx, bson.D{{Key: "_id", Value: upload.ID}}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "offset", Value: upload.Offset},
+			{Key: "expiresTS", Value: upload.ExpiresTS},
+		}},
+	})
+	if err == nil {
+		err = updErr
+	}
+	if err != nil || upload.Offset < upload.Length {
+		m.unlockResumable(upload)
+		if err != nil {
+			return upload, nil, helpers.WrapError(err, helpers.FuncName())
+		}
+		return upload, nil, nil
+	}
+
+	uploadInfo, err := m.storeResumable(upload)
+	if err != nil {
+		// files which can't be stored at all are removed with the upload
+		switch err {
+		case ErrUnsupportedFile, ErrFileTooLarge, ErrStorageQuotaExceeded, ErrMaximumFilesReached, apperror.ErrDenied:
+			m.removeResumable(upload.ID)
+		default:
+			m.unlockResumable(upload)
+		}
+		return upload, nil, err
+	}
+
+	m.removeResumable(upload.ID)
+
+	return upload, uploadInfo, nil
+}
+
+// DeleteResumable cancels an upload (uploader only)
+func (m UploadModel) DeleteResumable(uploadID string, userID string) error {
+
+	upload, err := m.GetResumable(uploadID, userID)
+	if err != nil {
+		return err
+	}
+
+	m.removeResumable(upload.ID)
+
+	return nil
+}
+
+// PurgeResumables removes the expired uploads and files without upload (scheduled)
+func (m UploadModel) PurgeResumables() {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel() // nach 60 Sekunden abbrechen
+
+	cursor, err := m.Resumables.Find(ctx, bson.D{{Key: "expiresTS", Value: bson.D{{Key: "$lt", Value: time.Now()}}}})
+	if err != nil {
+		m.Log.Err(helpers.WrapError(err, helpers.FuncName()))
+		return
+	}
+
+	var expired []ResumableUpload
+	err = cursor.All(ctx, &expired)
+	if err != nil {
+		m.Log.Err(helpers.WrapError(err, helpers.FuncName()))
+		return
+	}
+
+	for _, upload := range expired {
+		m.removeResumable(upload.ID)
+	}
+
+	// files are left if the server crashed during CreateResumable
+	files, err := ioutil.ReadDir(resumableDir())
+	if err != nil {
+		return // nothing uploaded yet
+	}
+
+	graceTS := time.Now().Add(-resumableTTL())
+	for _, file := range files {
+		uploadOID, err := primitive.ObjectIDFromHex(file.Name())
+		if err != nil || file.ModTime().After(graceTS) {
+			continue
+		}
+
+		count, err := m.Resumables.CountDocuments(ctx, bson.D{{Key: "_id", Value: uploadOID}})
+		if err == nil && count == 0 {
+			os.Remove(filepath.Join(resumableDir(), file.Name()))
+		}
+	}
+}
+
+// internal helpers
+
+// the content is passed to StoreFile (and SaveMetaData) like a form upload
+func (m UploadModel) storeResumable(upload *ResumableUpload) (*UploadInfo, error) {
+
+	file, err := os.Open(filepath.Join(resumableDir(), upload.ID.Hex()))
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+	defer file.Close()
+
+	uploadInfo := new(UploadInfo)
+	uploadInfo.UploadedID = upload.UploadedID
+	uploadInfo.SysFileName = upload.SysFileName
+	uploadInfo.OrigFileName = upload.OrigFileName
+	uploadInfo.Description = upload.Description
+
+	err = m.StoreFile(upload.ProfileID, upload.ProfileType, uploadInfo, file, upload.Length)
+	if err != nil {
+		return nil, err
+	}
+
+	return uploadInfo, nil
+}
+
+// bytes beyond the offset were written by an interrupted request which couldn't save the offset, they're discarded
+func writeChunk(upload *ResumableUpload, content io.Reader) (int64, error) {
+
+	file, err := os.OpenFile(filepath.Join(resumableDir(), upload.ID.Hex()), os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	err = file.Truncate(upload.Offset)
+	if err == nil {
+		_, err = file.Seek(upload.Offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+
+	written, err := io.Copy(file, io.LimitReader(content, upload.Length-upload.Offset))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return written, err
+}
+
+// the offset is part of the filter, hence a chunk sent twice (eg. by a retry) is rejected
+func (m UploadModel) lockResumable(upload *ResumableUpload) error {
+
+	now := time.Now()
+	filter := bson.D{
+		{Key: "_id", Value: upload.ID},
+		{Key: "offset", Value: upload.Offset},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "lockedTS", Value: bson.D{{Key: "$exists", Value: false}}}},
+			bson.D{{Key: "lockedTS", Value: bson.D{{Key: "$lt", Value: now.Add(-resumableLockTimeout)}}}},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	result, err := m.Resumables.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "lockedTS", Value: now}}}})
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+	if result.MatchedCount == 0 {
+		return ErrUploadOffset
+	}
+
+	return nil
+}
+
+func (m UploadModel) unlockResumable(upload *ResumableUpload) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	_, err := m.Resumables.UpdateOne(ctx, bson.D{{Key: "_id", Value: upload.ID}}, bson.D{{Key: "$unset", Value: bson.D{{Key: "lockedTS", Value: ""}}}})
+	if err != nil {
+		m.Log.Err(helpers.WrapError(err, helpers.FuncName()))
+	}
+}
+
+// errors are logged only, leftovers are removed by PurgeResumables
+func (m UploadModel) removeResumable(uploadOID primitive.ObjectID) {
+
+	err := os.Remove(filepath.Join(resumableDir(), uploadOID.Hex()))
+	if err != nil && !os.IsNotExist(err) {
+		m.Log.Err(helpers.WrapError(err, helpers.FuncName()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	_, err = m.Resumables.DeleteOne(ctx, bson.D{{Key: "_id", Value: uploadOID}})
+	if err != nil {
+		m.Log.Err(helpers.WrapError(err, helpers.FuncName()))
+	}
+}
+
+// directory of the uploads in progress
+func resumableDir() string {
+	dir := os.Getenv("UPLOAD_STAGE")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "forza-uploads")
+	}
+	return dir
+}
+
+// uploads expire if they aren't continued within UPLOAD_RESUMABLE_HOURS
+func resumableTTL() time.Duration {
+	return time.Duration(helpers.IntSetting("UPLOAD_RESUMABLE_HOURS", 24, 1)) * time.Hour
+}
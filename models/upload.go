@@ -1,13 +1,20 @@
 package models
 
 import (
+	"bytes"
 	"context"
-	"fmt"
 	"forza-garage/apperror"
 	"forza-garage/authorization"
 	"forza-garage/database"
+	"forza-garage/filter"
 	"forza-garage/helpers"
+	"forza-garage/imaging"
+	"forza-garage/logging"
 	"forza-garage/lookups"
+	"forza-garage/storage"
+	"forza-garage/video"
+	"io"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"time"
@@ -20,13 +27,23 @@ import (
 
 // File Name Convention at Destination:
 // otype_uuid.ext
+// files are kept in a blob store; files under review are staged (key prefix) until they're approved
+// the blobs are named by the SHA-256 of their content, identical files are stored once (see upload-blob.go)
+// ToDo: files uploaded before the blob store was introduced must be moved to "active/"
 
 // FileInfo is what's embedded in profiles and returned to the client
 type FileInfo struct {
-	URL         string `json:"url"` // built by controller from SysFileName
-	Description string `json:"description,omitempty"`
-	StatusCode  int32  `json:"statusCode"`
-	StatusText  string `json:"statusText"`
+	FileName    string            `json:"fileName"`             // identifies the file (delete, gallery)
+	URL         string            `json:"url"`                  // built by the blob store (display rendition)
+	Renditions  map[string]string `json:"renditions,omitempty"` // URLs of the smaller renditions by name (thumbnail, avatar)
+	Description string            `json:"description,omitempty"`
+	StatusCode  int32             `json:"statusCode"`
+	StatusText  string            `json:"statusText"`
+	Cover       bool              `json:"cover,omitempty"` // shown in lists
+	MediaType   string            `json:"mediaType"`       // image, video
+	Width       int32             `json:"width,omitempty"`
+	Height      int32             `json:"height,omitempty"`
+	Duration    float64           `json:"duration,omitempty"` // seconds (clips)
 }
 
 // API-internal data structures
@@ -43,6 +60,7 @@ type UploadHeader = struct {
 type Slot = struct {
 	Staged *UploadInfo `json:"-" bson:"staged,omitempty"`
 	Active *UploadInfo `json:"-" bson:"active,omitempty"`
+	Cover  bool        `json:"-" bson:"cover,omitempty"` // chosen by the profile's owner
 }
 
 // UploadInfo contains the meta data of an uploaded file
@@ -59,6 +77,25 @@ type UploadInfo = struct {
 	StatusID     *primitive.ObjectID `json:"statusID" bson:"statusID,omitempty"`     // not set for system
 	StatusName   *string             `json:"statusName" bson:"statusName,omitempty"` // not set for system
 	URL          string              `json:"url" bson:"-"`
+	Renditions   []UploadRendition   `json:"renditions,omitempty" bson:"renditions,omitempty"`
+	Size         int64               `json:"size" bson:"size,omitempty"`           // bytes of all renditions (storage quota)
+	Hash         string              `json:"-" bson:"sha256,omitempty"`            // of the uploaded file
+	BlobName     string              `json:"-" bson:"blobName,omitempty"`          // content-addressed, SysFileName if missing (older uploads)
+	MediaType    string              `json:"mediaType" bson:"mediaType,omitempty"` // missing for older uploads (images)
+	Width        int32               `json:"width" bson:"width,omitempty"`
+	Height       int32               `json:"height" bson:"height,omitempty"`
+	Duration     float64             `json:"duration,omitempty" bson:"duration,omitempty"` // seconds (clips)
+	Codec        string              `json:"codec,omitempty" bson:"codec,omitempty"`       // clips
+}
+
+// UploadRendition is a smaller version of an uploaded image
+type UploadRendition = struct {
+	Name     string `json:"name" bson:"name"`
+	FileName string `json:"-" bson:"fileName"`
+	BlobName string `json:"-" bson:"blobName,omitempty"`
+	Width    int32  `json:"width" bson:"width"`
+	Height   int32  `json:"height" bson:"height"`
+	URL      string `json:"url" bson:"-"`
 }
 
 /*
@@ -75,11 +112,19 @@ type UploadData = struct {
 type UploadModel struct {
 	Client     *mongo.Client
 	Collection *mongo.Collection
+	Refs       *mongo.Collection // reference counts of the blobs
+	Resumables *mongo.Collection // uploads in progress (chunks)
 	// Gewisse Informationen kommen vom User-Model, die werden hier referenziert
 	// somit muss das nicht der Controller machen
 	GetUserNameOID func(userID primitive.ObjectID) (string, error)
 	GetCredentials func(userOID primitive.ObjectID, loadFriendlist bool) *authorization.Credentials
 	GetUserVote    func(profileID string, userID string) (int32, error) // injected from vote model
+	Filter         filter.ContentFilter
+	Blobs          storage.BlobStore
+	ReserveStorage func(userOID primitive.ObjectID, size int64, quota StorageQuota) error // injected from user model
+	ReleaseStorage func(userOID primitive.ObjectID, size int64) error
+	Profiles       map[string]ProfileAccess // owners of the profiles by type (gallery)
+	Log            *logging.Logger
 }
 
 // file locations are used internally to make functions independent of moderation status
@@ -89,6 +134,140 @@ const (
 	flActive
 )
 
+// key prefixes of the file locations in the blob store
+const (
+	blobStaged = "staged/"
+	blobActive = "active/"
+)
+
+// renditions of uploaded images
+// the display rendition is the file itself (SysFileName), the others are listed in its metadata
+var (
+	displaySpec   = imaging.Spec{Name: "display", Width: 1920, Height: 1920}
+	thumbnailSpec = imaging.Spec{Name: "thumbnail", Width: 400, Height: 400}
+	avatarSpec    = imaging.Spec{Name: "avatar", Width: 256, Height: 256, Crop: true}
+)
+
+// StoreFile processes an uploaded image or clip and saves its renditions and metadata
+// the SysFileName is passed without extension (set by the detected format)
+// the files are staged first and promoted if they don't need to be reviewed
+// the uploader's storage quota (role) limits the file size and the stored bytes/files
+// the content (form file or staged upload) is read in parts, clips are stored without loading them
+func (m UploadModel) StoreFile(profileID string, profileType string, uploadInfo *UploadInfo, content io.ReaderAt, size int64) error {
+
+	cred := m.GetCredentials(uploadInfo.UploadedID, false)
+	if cred == nil {
+		return apperror.ErrDenied
+	}
+	quota := GetStorageQuota(cred.RoleCode)
+
+	// the limit depends on the type, which is checked by processing
+	if maxSize := quota.maxUploadSize(); maxSize > 0 && size > maxSize {
+		return ErrFileTooLarge
+	}
+
+	hash, err := readerHash(io.NewSectionReader(content, 0, size))
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	head := make([]byte, 512)
+	n, _ := content.ReadAt(head, 0)
+
+	// images are decoded, hence they're read completely (limited by the quota)
+	var renditions []imaging.Rendition
+	clip := false
+	if _, ok := video.Sniff(head[:n]); ok {
+		clip = true
+		renditions, err = processClip(content, size, profileType, quota, uploadInfo)
+	} else {
+		var data []byte
+		data, err = ioutil.ReadAll(io.NewSectionReader(content, 0, size))
+		if err != nil {
+			return helpers.WrapError(err, helpers.FuncName())
+		}
+		renditions, err = processImage(data, profileType, quota, uploadInfo)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the clip is the uploaded file, the other renditions are encoded
+	blob := func(i int, r imaging.Rendition) (string, io.Reader, int64) {
+		if clip && i == 0 {
+			return hash, io.NewSectionReader(content, 0, size), size
+		}
+		return contentHash(r.Data), bytes.NewReader(r.Data), int64(len(r.Data))
+	}
+
+	// the usage is reserved before saving, deleteFiles releases it
+	uploadInfo.Size = 0
+	for i, r := range renditions {
+		_, _, blobSize := blob(i, r)
+		uploadInfo.Size += blobSize
+	}
+	err = m.ReserveStorage(uploadInfo.UploadedID, uploadInfo.Size, quota)
+	if err != nil {
+		return err
+	}
+
+	// file names: otype_uuid.ext, otype_uuid_name.ext
+	// blob names: sha256.ext
+	uploadInfo.Hash = hash
+	baseName := uploadInfo.SysFileName
+	uploadInfo.Renditions = nil
+	var acquired []string
+	for i, r := range renditions {
+		blobHash, blobContent, blobSize := blob(i, r)
+		name := blobHash + r.Ext
+		if i == 0 {
+			uploadInfo.SysFileName = baseName + r.Ext
+			uploadInfo.BlobName = name
+		} else {
+			uploadInfo.Renditions = append(uploadInfo.Renditions, UploadRendition{
+				Name:     r.Name,
+				FileName: baseName + "_" + r.Name + r.Ext,
+				BlobName: name,
+				Width:    int32(r.Width),
+				Height:   int32(r.Height),
+			})
+		}
+
+		err = m.acquireBlob(blobKey(flStage, name), blobContent, blobSize, r.ContentType)
+		if err != nil {
+			// release the blobs acquired so far
+			for _, key := range acquired {
+				m.releaseBlob(key)
+			}
+			m.releaseStorage(uploadInfo)
+			return err
+		}
+		acquired = append(acquired, blobKey(flStage, name))
+	}
+
+	err = m.SaveMetaData(profileID, profileType, uploadInfo)
+	if err != nil {
+		m.deleteFiles(flStage, uploadInfo)
+		return err
+	}
+
+	location := flStage
+	if uploadInfo.StatusCode == lookups.CommentStatusVisible {
+		location = flActive
+		err = m.moveFiles(uploadInfo)
+		if err != nil {
+			return err
+		}
+	}
+
+	uploadInfo.URL = m.fileURL(location, blobName(uploadInfo))
+	for i, r := range uploadInfo.Renditions {
+		uploadInfo.Renditions[i].URL = m.fileURL(location, renditionBlobName(r))
+	}
+
+	return nil
+}
+
 func (m UploadModel) SaveMetaData(profileID string, profileType string, uploadInfo *UploadInfo) error {
 
 	profileOID := helpers.ObjectID(profileID)
@@ -100,6 +279,16 @@ func (m UploadModel) SaveMetaData(profileID string, profileType string, uploadIn
 	uploadInfo.UploadedTS = now
 	uploadInfo.UploadedName, _ = m.GetUserNameOID(uploadInfo.UploadedID)
 	uploadInfo.StatusTS = now
+
+	// descriptions are filtered, held ones are reviewed even if moderation is disabled
+	moderated := os.Getenv("UPLOAD_MODERATION") == "YES"
+	if uploadInfo.Description != "" {
+		checked := m.Filter.Check(uploadInfo.Description, m.GetCredentials(uploadInfo.UploadedID, false).LanguageCode)
+		uploadInfo.Description = checked.Text
+		if checked.Verdict == filter.Hold {
+			moderated = true
+		}
+	}
 	// status depends on moderation feature toggle and is set in IF block below
 
 	// MongoDB's upsert operation can not be used here, because additional entries will go into an array
@@ -114,7 +303,7 @@ func (m UploadModel) SaveMetaData(profileID string, profileType string, uploadIn
 
 		// user profiles are handled differently because there can be only one avatar
 		// which is always saved in slot 0
-		if profileType == "user" {
+		if profileType == ContentTypeUser {
 			var fields bson.D
 			filter := bson.D{{Key: "profileID", Value: profileOID}}
 
@@ -139,7 +328,7 @@ func (m UploadModel) SaveMetaData(profileID string, profileType string, uploadIn
 			}
 
 			// if moderation is enabled, the current profile picture will be left intact until the new one is approved
-			if os.Getenv("UPLOAD_MODERATION") == "YES" {
+			if moderated {
 				uploadInfo.StatusCode = lookups.CommentStatusPending
 				fields = bson.D{
 					{Key: "$set", Value: bson.D{{Key: "slots.0.staged", Value: &uploadInfo}}},
@@ -165,16 +354,11 @@ func (m UploadModel) SaveMetaData(profileID string, profileType string, uploadIn
 			}
 
 			// delete the old file right away if everything was okay
-			oldFile := ""
-			if os.Getenv("UPLOAD_MODERATION") == "YES" {
-				oldFile = os.Getenv("UPLOAD_TARGET") + "/" + data.Slots[0].Staged.SysFileName
-			} else {
-				oldFile = os.Getenv("UPLOAD_TARGET") + "/" + data.Slots[0].Active.SysFileName
+			if moderated && data.Slots[0].Staged != nil {
+				m.deleteFiles(flStage, data.Slots[0].Staged)
 			}
-			err = os.Remove(oldFile)
-			if err != nil {
-				// ToDO: log
-				fmt.Println(err)
+			if !moderated && data.Slots[0].Active != nil {
+				m.deleteFiles(flActive, data.Slots[0].Active)
 			}
 
 			return nil
@@ -202,17 +386,13 @@ func (m UploadModel) SaveMetaData(profileID string, profileType string, uploadIn
 				return apperror.ErrNoData // internal data error
 			}
 
-			maxFiles, err := strconv.Atoi(os.Getenv("UPLOAD_MAX_FILES"))
-			if err != nil {
-				// ToDO: Log/Panic: Invalid COnfig
-				maxFiles = 5
-			}
-			if len(data.Slots) > maxFiles {
+			maxFiles := helpers.IntSetting("UPLOAD_MAX_FILES", 5, 1)
+			if len(data.Slots) >= maxFiles {
 				return ErrMaximumFilesReached
 			}
 
 			// if moderation is enabled, the current profile file will be left intact until the new one is approved
-			if os.Getenv("UPLOAD_MODERATION") == "YES" {
+			if moderated {
 				uploadInfo.StatusCode = lookups.CommentStatusPending
 				fields = bson.D{
 					{Key: "$push", Value: bson.D{
@@ -257,7 +437,7 @@ func (m UploadModel) SaveMetaData(profileID string, profileType string, uploadIn
 		metaData.Slots = make([]Slot, 1)
 
 		// if moderation is enabled, the current profile picture will be left intact until the new one is approved
-		if os.Getenv("UPLOAD_MODERATION") == "YES" {
+		if moderated {
 			uploadInfo.StatusCode = lookups.CommentStatusPending
 			metaData.Slots[0].Staged = uploadInfo
 		} else {
@@ -312,33 +492,44 @@ func (m UploadModel) GetMetaData(profileOID primitive.ObjectID, executiveUserID
 		}
 
 		for _, s := range data.Slots {
-			// creators see their pending content
-			if s.Staged != nil {
-				if (s.Staged.UploadedID == executiveUserOID) || (cred.RoleCode == lookups.UserRoleAdmin) {
-					//if s.Staged.UploadedID == executiveUserOID {
-					fileInfo.Description = s.Staged.Description
-					fileInfo.StatusCode = s.Staged.StatusCode
-					fileInfo.StatusText = database.GetLookupText(lookups.LookupType(lookups.LTcommentStatus), s.Staged.StatusCode)
-					fileInfo.URL = s.Staged.SysFileName
-					fileInfos = append(fileInfos, fileInfo)
-				}
+			// creators see their pending content, others the active (approved) file
+			if s.Staged != nil && ((s.Staged.UploadedID == executiveUserOID) || (cred.RoleCode == lookups.UserRoleAdmin)) {
+				//if s.Staged.UploadedID == executiveUserOID {
+				fileInfo.FileName = s.Staged.SysFileName
+				fileInfo.Cover = s.Cover
+				fileInfo.Description = s.Staged.Description
+				fileInfo.StatusCode = s.Staged.StatusCode
+				fileInfo.StatusText = database.GetLookupText(lookups.LookupType(lookups.LTcommentStatus), s.Staged.StatusCode)
+				fileInfo.URL = m.fileURL(flStage, blobName(s.Staged))
+				fileInfo.Renditions = m.renditionURLs(flStage, s.Staged)
+				setMedia(&fileInfo, s.Staged)
+				fileInfos = append(fileInfos, fileInfo)
 			} else {
-				if s.Active != nil {
+				// rejected files are hidden
+				if s.Active != nil && s.Active.StatusCode != lookups.CommentStatusBlocked {
+					fileInfo.FileName = s.Active.SysFileName
+					fileInfo.Cover = s.Cover
 					fileInfo.Description = s.Active.Description
 					fileInfo.StatusCode = s.Active.StatusCode
 					fileInfo.StatusText = database.GetLookupText(lookups.LookupType(lookups.LTcommentStatus), s.Active.StatusCode)
-					fileInfo.URL = s.Active.SysFileName
+					fileInfo.URL = m.fileURL(flActive, blobName(s.Active))
+					fileInfo.Renditions = m.renditionURLs(flActive, s.Active)
+					setMedia(&fileInfo, s.Active)
 					fileInfos = append(fileInfos, fileInfo)
 				}
 			}
 		}
 	} else {
 		for _, s := range data.Slots {
-			if s.Active != nil {
+			if s.Active != nil && s.Active.StatusCode != lookups.CommentStatusBlocked {
+				fileInfo.FileName = s.Active.SysFileName
+				fileInfo.Cover = s.Cover
 				fileInfo.Description = s.Active.Description
 				fileInfo.StatusCode = s.Active.StatusCode
 				fileInfo.StatusText = database.GetLookupText(lookups.LookupType(lookups.LTcommentStatus), s.Active.StatusCode)
-				fileInfo.URL = s.Active.SysFileName
+				fileInfo.URL = m.fileURL(flActive, blobName(s.Active))
+				fileInfo.Renditions = m.renditionURLs(flActive, s.Active)
+				setMedia(&fileInfo, s.Active)
 				fileInfos = append(fileInfos, fileInfo)
 			}
 		}
@@ -447,30 +638,262 @@ func (m UploadModel) DeleteUpload(profileID primitive.ObjectID, fileName string,
 	}
 
 	// delete file
-	// files currently under review (db/slot file location "staged") still resides in the target directory on the file system
-	fullPath := os.Getenv("UPLOAD_TARGET") + "/" + fileName
-	err = os.Remove(fullPath)
+	m.deleteFiles(location, area)
+
+	return nil
+
+}
+
+// UserUpload references an uploaded file and the profile it's attached to (data export)
+type UserUpload struct {
+	ProfileID   primitive.ObjectID `json:"profileId"`
+	ProfileType string             `json:"profileType"`
+	Staged      bool               `json:"staged"` // pending review
+	File        *UploadInfo        `json:"file"`
+}
+
+// ListUserUploads returns the metadata of all files uploaded by a user (data export)
+func (m UploadModel) ListUserUploads(userOID primitive.ObjectID) ([]UserUpload, error) {
+
+	filter := bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "slots.active.uploadedID", Value: userOID}},
+			bson.D{{Key: "slots.staged.uploadedID", Value: userOID}},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := m.Collection.Find(ctx, filter)
 	if err != nil {
-		fmt.Println("err: ", err)
+		return nil, helpers.WrapError(err, helpers.FuncName())
 	}
-	fmt.Println("file: ", fullPath)
-	return nil
 
+	var headers []UploadHeader
+
+	err = cursor.All(ctx, &headers)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// a profile's slots may contain files of other users
+	var uploads []UserUpload
+	for _, h := range headers {
+		for _, s := range h.Slots {
+			if s.Active != nil && s.Active.UploadedID == userOID {
+				uploads = append(uploads, UserUpload{h.ProfileID, h.ProfileType, false, s.Active})
+			}
+			if s.Staged != nil && s.Staged.UploadedID == userOID {
+				uploads = append(uploads, UserUpload{h.ProfileID, h.ProfileType, true, s.Staged})
+			}
+		}
+	}
+
+	return uploads, nil
 }
 
-// GetModerationSample is called my the Moderation Model if this feature is enabled
-func (m UploadModel) GetModerationSample() *ReviewItem {
+// DeleteUserUploads removes all files uploaded by a user (deleted account)
+func (m UploadModel) DeleteUserUploads(userOID primitive.ObjectID) error {
+
+	uploads, err := m.ListUserUploads(userOID)
+	if err != nil {
+		return err
+	}
 
-	// use aggregation framework
-	// 1. filter ($match)
+	for _, u := range uploads {
+		// uploader is the executive user, hence permissions are granted
+		err = m.DeleteUpload(u.ProfileID, u.File.SysFileName, userOID)
+		if err != nil && err != apperror.ErrNoData {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetModerationSample randomly chooses pending or flagged files (Sampler)
+// staged files are pending by convention, active ones may have been flagged
+func (m UploadModel) GetModerationSample(size int) ([]ReviewItem, error) {
+
+	review := bson.D{{Key: "$in", Value: bson.A{lookups.CommentStatusPending, lookups.CommentStatusFlagged}}}
+	filter := bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "slots.staged.statusCD", Value: review}},
+			bson.D{{Key: "slots.active.statusCD", Value: review}},
+		}},
+	}
+
+	// 1. filter ($match), 2. sample
 	// https://stackoverflow.com/questions/38576172/mongodb-sample-after-filtering
-	// 2. sample
 	// https://docs.mongodb.com/manual/reference/operator/aggregation/sample/
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: filter}},
+		bson.D{{Key: "$unwind", Value: "$slots"}},
+		bson.D{{Key: "$match", Value: filter}},
+		bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: size}}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := m.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// slots are unwound to single documents
+	var sample []struct {
+		ProfileID   primitive.ObjectID `bson:"profileID"`
+		ProfileType string             `bson:"profileType"`
+		Slot        Slot               `bson:"slots"`
+	}
+
+	err = cursor.All(ctx, &sample)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	items := make([]ReviewItem, 0, len(sample))
+	for _, s := range sample {
+		profileID, profileType := s.ProfileID, s.ProfileType
+
+		for _, file := range []*UploadInfo{s.Slot.Staged, s.Slot.Active} {
+			if file == nil || (file.StatusCode != lookups.CommentStatusPending && file.StatusCode != lookups.CommentStatusFlagged) {
+				continue
+			}
+			location := flActive
+			if file == s.Slot.Staged {
+				location = flStage
+			}
+			items = append(items, ReviewItem{
+				ParentID:    &profileID,
+				ParentType:  &profileType,
+				ContentID:   file.SysFileName,
+				ContentType: ContentTypeUpload,
+				Content:     m.fileURL(location, blobName(file)),
+				StatusCode:  file.StatusCode,
+				StatusTS:    file.StatusTS,
+				CreatorID:   file.UploadedID,
+				CreatorName: file.UploadedName,
+			})
+		}
+	}
+
+	return items, nil
+}
+
+// GetModerationStatus returns the status of a file (Sampler)
+func (m UploadModel) GetModerationStatus(contentID string) (int32, error) {
 
-	// db.uploads.find({"slots.staged.statusCD": {$in: [0, 1]}});
-	// https://docs.mongodb.com/manual/tutorial/query-array-of-documents/
+	var data UploadHeader
 
-	// if mod==yes: 1. staged 2. active else active
+	filter := bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "slots.staged.fileName", Value: contentID}},
+			bson.D{{Key: "slots.active.fileName", Value: contentID}},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	err := m.Collection.FindOne(ctx, filter).Decode(&data)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, apperror.ErrNoData
+		}
+		return 0, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	_, location, file := m.findFile(data.Slots, contentID)
+	if location == flUndefined {
+		return 0, apperror.ErrNoData
+	}
+
+	return file.StatusCode, nil
+}
+
+// SetModerationStatus applies a moderator's decision to a file (Sampler)
+// approved files are promoted from the staged to the active slot, replacing (and deleting) the previous one
+func (m UploadModel) SetModerationStatus(contentID string, statusCode int32, executiveOID primitive.ObjectID, executiveName string) error {
+
+	var data UploadHeader
+
+	filter := bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "slots.staged.fileName", Value: contentID}},
+			bson.D{{Key: "slots.active.fileName", Value: contentID}},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	err := m.Collection.FindOne(ctx, filter).Decode(&data)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return apperror.ErrNoData
+		}
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	index, location, file := m.findFile(data.Slots, contentID)
+	if location == flUndefined {
+		return apperror.ErrNoData
+	}
+
+	file.StatusCode = statusCode
+	file.StatusTS = time.Now()
+	file.StatusID = &executiveOID
+	file.StatusName = &executiveName
+
+	slot := "slots." + strconv.Itoa(index)
+	area := slot + ".active"
+	if location == flStage {
+		area = slot + ".staged"
+	}
+
+	var replaced *UploadInfo
+
+	var fields bson.D
+	if location == flStage && statusCode == lookups.CommentStatusVisible {
+		replaced = data.Slots[index].Active
+		fields = bson.D{
+			{Key: "$set", Value: bson.D{{Key: slot + ".active", Value: file}}},
+			{Key: "$unset", Value: bson.D{{Key: area, Value: ""}}},
+		}
+	} else {
+		fields = bson.D{
+			{Key: "$set", Value: bson.D{{Key: area, Value: file}}},
+		}
+	}
+
+	// the file name is part of the filter, in case the slots were changed in the meantime
+	filter = bson.D{
+		{Key: "_id", Value: data.ID},
+		{Key: area + ".fileName", Value: contentID},
+	}
+
+	result, err := m.Collection.UpdateOne(ctx, filter, fields)
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrNoData // document might have been changed
+	}
+
+	// promote the approved file and delete the replaced one (metadata is already updated)
+	if location == flStage && statusCode == lookups.CommentStatusVisible {
+		err = m.moveFiles(file)
+		if err != nil {
+			m.Log.Err(err, logging.Fields{"fileName": file.SysFileName})
+		}
+	}
+	if replaced != nil {
+		m.deleteFiles(flActive, replaced)
+	}
 
 	return nil
 }
@@ -503,6 +926,150 @@ func (m UploadModel) uploadsExists(profileID primitive.ObjectID) (bool, error) {
 	return true, nil
 }
 
+// GetCommentProfile resolves an uploaded file as a commentable profile (screenshots)
+// only approved (active) files can be commented, their visibility is the one of the profile they belong to
+func (m UploadModel) GetCommentProfile(fileName string, userID string) (*CommentProfile, error) {
+
+	var data UploadHeader
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	err := m.Collection.FindOne(ctx, bson.D{{Key: "slots.active.fileName", Value: fileName}}).Decode(&data)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, apperror.ErrNoData
+		}
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	_, location, info := m.findFile(data.Slots, fileName)
+	if location != flActive || info.StatusCode == lookups.CommentStatusBlocked {
+		return nil, apperror.ErrNoData
+	}
+
+	// permissions are checked by the comment model (parent profile)
+	return &CommentProfile{ProfileID: data.ProfileID, OwnerID: info.UploadedID, ParentType: data.ProfileType}, nil
+}
+
+// OpenFile reads an uploaded file (data export), the caller must close it
+func (m UploadModel) OpenFile(upload UserUpload) (io.ReadCloser, error) {
+
+	location := flActive
+	if upload.Staged {
+		location = flStage
+	}
+
+	content, _, err := m.Blobs.Get(blobKey(location, blobName(upload.File)))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, apperror.ErrNoData
+		}
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return content, nil
+}
+
+// key of a file in the blob store
+func blobKey(location int, fileName string) string {
+	if location == flStage {
+		return blobStaged + fileName
+	}
+	return blobActive + fileName
+}
+
+// name of an upload in the blob store, older uploads are stored by their file names
+func blobName(info *UploadInfo) string {
+	if info.BlobName != "" {
+		return info.BlobName
+	}
+	return info.SysFileName
+}
+
+func renditionBlobName(r UploadRendition) string {
+	if r.BlobName != "" {
+		return r.BlobName
+	}
+	return r.FileName
+}
+
+// the URLs of all files expire, hence a profile's visibility applies to the URLs issued before its change
+func (m UploadModel) fileURL(location int, name string) string {
+	return m.Blobs.SignedURL(blobKey(location, name), urlExpiry())
+}
+
+// signed URLs are valid for UPLOAD_URL_MINUTES at least
+// the expiration is rounded, so the URLs don't change with every request (browser caches)
+func urlExpiry() time.Time {
+	ttl := time.Duration(helpers.IntSetting("UPLOAD_URL_MINUTES", 60, 1)) * time.Minute
+	return time.Now().Truncate(ttl).Add(2 * ttl)
+}
+
+// names of an upload's files in the blob store (the file itself and its renditions)
+func blobNames(info *UploadInfo) []string {
+	names := []string{blobName(info)}
+	for _, r := range info.Renditions {
+		names = append(names, renditionBlobName(r))
+	}
+	return names
+}
+
+// URLs of the renditions by name
+func (m UploadModel) renditionURLs(location int, info *UploadInfo) map[string]string {
+	if len(info.Renditions) == 0 {
+		return nil
+	}
+	urls := make(map[string]string, len(info.Renditions))
+	for _, r := range info.Renditions {
+		urls[r.Name] = m.fileURL(location, renditionBlobName(r))
+	}
+	return urls
+}
+
+// promotes an approved upload's files
+func (m UploadModel) moveFiles(info *UploadInfo) error {
+	for _, name := range blobNames(info) {
+		var err error
+		if info.BlobName != "" {
+			err = m.promoteBlob(name)
+		} else {
+			err = m.Blobs.Move(blobKey(flStage, name), blobKey(flActive, name))
+		}
+		if err != nil {
+			return helpers.WrapError(err, helpers.FuncName())
+		}
+	}
+	return nil
+}
+
+// files are removed after their metadata, hence errors are logged only (the garbage collector removes leftovers)
+// the uploader's storage usage is released
+func (m UploadModel) deleteFiles(location int, info *UploadInfo) {
+	for _, name := range blobNames(info) {
+		if info.BlobName != "" {
+			m.releaseBlob(blobKey(location, name))
+			continue
+		}
+		err := m.Blobs.Delete(blobKey(location, name))
+		if err != nil {
+			m.Log.Err(helpers.WrapError(err, helpers.FuncName()))
+		}
+	}
+
+	m.releaseStorage(info)
+}
+
+// uploads without size were never counted
+func (m UploadModel) releaseStorage(info *UploadInfo) {
+	if info.Size > 0 {
+		err := m.ReleaseStorage(info.UploadedID, info.Size)
+		if err != nil {
+			m.Log.Err(err, logging.Fields{"userID": info.UploadedID.Hex(), "size": info.Size})
+		}
+	}
+}
+
 // find a file in a document's slots
 // returns position, -1 if not found
 func (m UploadModel) findFile(slots []Slot, value string) (int, int, *UploadInfo) {
@@ -0,0 +1,194 @@
+package models
+
+import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/audit"
+	"forza-garage/helpers"
+	"forza-garage/logging"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// account deletion & data export (GDPR)
+
+// DeletedUserName replaces the creator's name of content which remains after an account was purged
+const DeletedUserName = "[deleted]"
+
+// ScheduleDeletion marks an account to be purged after the grace period (ACCOUNT_DELETION_DAYS)
+// the password is checked again, since this can't be undone after the grace period
+func (m UserModel) ScheduleDeletion(userID string, password string) (*time.Time, error) {
+
+	user, err := m.GetUserByID(userID, userID)
+	if err != nil {
+		if err == apperror.ErrNoData {
+			return nil, ErrInvalidUser
+		}
+		return nil, err
+	}
+
+	if !m.CheckPassword(password, *user) {
+		return nil, ErrInvalidUser
+	}
+
+	graceDays := helpers.IntSetting("ACCOUNT_DELETION_DAYS", 14, 0)
+	deletionTS := time.Now().AddDate(0, 0, graceDays)
+
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "deletionTS", Value: deletionTS}}}}
+
+	err = m.updateAccount(user.ID, update)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deletionTS, nil
+}
+
+// RestoreAccount cancels a scheduled deletion during the grace period
+func (m UserModel) RestoreAccount(userID string) error {
+
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrInvalidUser
+	}
+
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "deletionTS", Value: ""}}}}
+
+	return m.updateAccount(userOID, update)
+}
+
+// PurgeAccounts removes all accounts whose grace period has expired
+// usually called by a GO-routine that runs in a ticker
+func (m UserModel) PurgeAccounts() {
+
+	fields := bson.D{
+		{Key: "_id", Value: 1},
+	}
+
+	filter := bson.D{
+		{Key: "deletionTS", Value: bson.D{
+			{Key: "$lte", Value: time.Now()},
+		}},
+	}
+
+	opts := options.Find().SetProjection(fields).SetLimit(50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := m.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		m.Log.Err(helpers.WrapError(err, helpers.FuncName()))
+		return
+	}
+
+	var users []User
+
+	err = cursor.All(ctx, &users)
+	if err != nil {
+		m.Log.Err(helpers.WrapError(err, helpers.FuncName()))
+		return
+	}
+
+	for _, u := range users {
+		err = m.purgeAccount(u.ID)
+		if err != nil {
+			// account is left intact and processed again by the next run
+			m.Log.Err(err, logging.Fields{"userID": u.ID.Hex()})
+		}
+	}
+}
+
+// ExportReferences returns all relations a user is part of (friends, followers, blocks...)
+func (m UserModel) ExportReferences(userOID primitive.ObjectID) ([]UserRef, error) {
+
+	filter := bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "userID", Value: userOID}},
+			bson.D{{Key: "refID", Value: userOID}},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := m.Social.Find(ctx, filter, options.Find().SetProjection(bson.D{{Key: "_id", Value: 0}}))
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var references []UserRef
+
+	err = cursor.All(ctx, &references)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return references, nil
+}
+
+// removes a user's data; content which is of interest to others (courses, comments) remains anonymized
+// the account document is deleted last, so a failed purge will be repeated
+func (m UserModel) purgeAccount(userOID primitive.ObjectID) error {
+
+	// sessions first, so the user can't interfere
+	_, err := m.RevokeSessions(userOID.Hex())
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// votes are revoked to recalculate the ratings of the voted profiles
+	err = m.RevokeVotes(userOID)
+	if err != nil {
+		return err
+	}
+
+	err = m.DeleteUploads(userOID)
+	if err != nil {
+		return err
+	}
+
+	err = m.AnonymizeComments(userOID, DeletedUserName)
+	if err != nil {
+		return err
+	}
+
+	err = m.AnonymizeCourses(userOID, DeletedUserName)
+	if err != nil {
+		return err
+	}
+
+	err = m.DeleteNotifications(userOID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	// relations of both directions (friends, followers, blocks)
+	_, err = m.Social.DeleteMany(ctx, bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "userID", Value: userOID}},
+			bson.D{{Key: "refID", Value: userOID}},
+		}},
+	})
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// visits are kept in the analytics store, which expires them by its retention policy
+
+	_, err = m.Collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: userOID}})
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// performed by the system (no actor)
+	m.Audit(context.Background(), "", audit.ActionAccountPurge, audit.Target(ContentTypeUser, userOID.Hex()), nil)
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"forza-garage/apperror"
+	"forza-garage/database"
+	"forza-garage/helpers"
+	"forza-garage/lookups"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// user management functions, restricted to admins
+
+// UserSearchParams is passed as the search params (admin only)
+type UserSearchParams struct {
+	SearchTerm string // looked-up in login name, e-mail address and xbox tag
+	RoleCode   *int32 // optional filter
+	Page       int    // starts with 0
+	PageSize   int
+}
+
+// UserListItem is the reduced data structure used for admin lists
+type UserListItem struct {
+	ID             primitive.ObjectID `json:"id"`
+	LoginName      string             `json:"loginName"`
+	EMailAddress   string             `json:"eMail"`
+	XBoxTag        string             `json:"XBoxTag"`
+	RoleCode       int32              `json:"roleCode"`
+	RoleText       string             `json:"roleText"`
+	Joined         time.Time          `json:"joinedTS"`
+	LastSeenTS     *time.Time         `json:"lastSeen,omitempty"`
+	SuspendedUntil *time.Time         `json:"suspendedUntil,omitempty"`
+	PasswordReset  bool               `json:"passwordReset"`
+}
+
+// Suspended checks if a user account is currently suspended
+func (u User) Suspended() bool {
+	return u.SuspendedUntil != nil && u.SuspendedUntil.After(time.Now())
+}
+
+// SearchUsers lists users by (parts of) their login name, e-mail address or xbox tag
+func (m UserModel) SearchUsers(searchSpecs *UserSearchParams, executiveUserID string) ([]UserListItem, error) {
+
+	_, err := m.grantAdmin(executiveUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := bson.D{
+		{Key: "_id", Value: 1},
+		{Key: "loginName", Value: 1},
+		{Key: "eMail", Value: 1},
+		{Key: "XBoxTag", Value: 1},
+		{Key: "roleCD", Value: 1},
+		{Key: "lastSeen", Value: 1},
+		{Key: "suspendedUntil", Value: 1},
+		{Key: "pwdReset", Value: 1},
+	}
+
+	sort := bson.D{
+		{Key: "loginName", Value: 1},
+	}
+
+	if searchSpecs.PageSize <= 0 || searchSpecs.PageSize > 100 {
+		searchSpecs.PageSize = 20
+	}
+	if searchSpecs.Page < 0 {
+		searchSpecs.Page = 0
+	}
+
+	opts := options.Find().
+		SetProjection(fields).
+		SetSort(sort).
+		SetSkip(int64(searchSpecs.Page * searchSpecs.PageSize)).
+		SetLimit(int64(searchSpecs.PageSize))
+
+	filter := bson.D{}
+	if searchSpecs.SearchTerm != "" {
+		// user input must not be interpreted as regular expression
+		pattern := regexp.QuoteMeta(searchSpecs.SearchTerm)
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "loginName", Value: primitive.Regex{Pattern: pattern, Options: "i"}}},
+			bson.D{{Key: "eMail", Value: primitive.Regex{Pattern: pattern, Options: "i"}}},
+			bson.D{{Key: "XBoxTag", Value: primitive.Regex{Pattern: pattern, Options: "i"}}},
+		}})
+	}
+	if searchSpecs.RoleCode != nil {
+		filter = append(filter, bson.E{Key: "roleCD", Value: *searchSpecs.RoleCode})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := m.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// receive results (full structure)
+	var users []User
+
+	err = cursor.All(ctx, &users)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// check for empty result set (no error raised by find)
+	if users == nil {
+		return nil, apperror.ErrNoData
+	}
+
+	// copy data to reduced list-struct
+	userList := make([]UserListItem, len(users))
+	for i, u := range users {
+		userList[i].ID = u.ID
+		userList[i].LoginName = u.LoginName
+		userList[i].EMailAddress = u.EMailAddress
+		userList[i].XBoxTag = u.XBoxTag
+		userList[i].RoleCode = u.RoleCode
+		userList[i].RoleText = database.GetLookupText(lookups.LookupType(lookups.LTuserRole), u.RoleCode)
+		userList[i].Joined = primitive.ObjectID(u.ID).Timestamp()
+		if len(u.LastSeenTS) > 0 {
+			// most recent log-in is stored last
+			userList[i].LastSeenTS = &u.LastSeenTS[len(u.LastSeenTS)-1]
+		}
+		userList[i].SuspendedUntil = u.SuspendedUntil
+		userList[i].PasswordReset = u.PasswordReset
+	}
+
+	return userList, nil
+}
+
+// SetRole changes a user's role
+func (m UserModel) SetRole(userID string, roleCode int32, executiveUserID string) error {
+
+	executive, err := m.grantAdmin(executiveUserID)
+	if err != nil {
+		return err
+	}
+
+	if roleCode != lookups.UserRoleGuest && roleCode != lookups.UserRoleMember && roleCode != lookups.UserRoleAdmin {
+		return ErrInvalidRole
+	}
+
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrInvalidUser
+	}
+
+	// admins can't demote themselves (there might be no admin left)
+	if userOID == executive.UserID {
+		return apperror.ErrDenied
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "roleCD", Value: roleCode}}}}
+
+	err = m.updateAccount(userOID, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SuspendUser locks a user's account until the given time and revokes their current sessions (by the controller)
+func (m UserModel) SuspendUser(userID string, until time.Time, executiveUserID string) error {
+
+	executive, err := m.grantAdmin(executiveUserID)
+	if err != nil {
+		return err
+	}
+
+	if !until.After(time.Now()) {
+		return ErrInvalidSuspension
+	}
+
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrInvalidUser
+	}
+
+	if userOID == executive.UserID {
+		return apperror.ErrDenied
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "suspendedUntil", Value: until}}}}
+
+	err = m.updateAccount(userOID, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UnsuspendUser lifts a suspension before it expires
+func (m UserModel) UnsuspendUser(userID string, executiveUserID string) error {
+
+	_, err := m.grantAdmin(executiveUserID)
+	if err != nil {
+		return err
+	}
+
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrInvalidUser
+	}
+
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "suspendedUntil", Value: ""}}}}
+
+	err = m.updateAccount(userOID, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ResetPassword replaces a user's password by a temporary one, which is returned to the admin.
+// The user is required to change it after the next log-in (SetPassword clears the flag)
+func (m UserModel) ResetPassword(userID string, executiveUserID string) (string, error) {
+
+	_, err := m.grantAdmin(executiveUserID)
+	if err != nil {
+		return "", err
+	}
+
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return "", ErrInvalidUser
+	}
+
+	// 12 random bytes result in 16 characters
+	b := make([]byte, 12)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", helpers.WrapError(err, helpers.FuncName())
+	}
+	tempPassword := base64.RawURLEncoding.EncodeToString(b)
+
+	pwdHash, err := helpers.GenerateHash(tempPassword)
+	if err != nil {
+		return "", helpers.WrapError(err, helpers.FuncName())
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "password", Value: pwdHash},
+		{Key: "pwdReset", Value: true},
+	}}}
+
+	err = m.updateAccount(userOID, update)
+	if err != nil {
+		return "", err
+	}
+
+	return tempPassword, nil
+}
+
+// internal helpers
+
+// checks if the executive user is an admin and returns their credentials
+func (m UserModel) grantAdmin(executiveUserID string) (*Credentials, error) {
+	credentials := m.GetCredentials(executiveUserID, false)
+	if credentials.RoleCode != lookups.UserRoleAdmin {
+		return nil, apperror.ErrDenied
+	}
+	return credentials, nil
+}
+
+// applies an update to a user's account document
+func (m UserModel) updateAccount(userOID primitive.ObjectID, update bson.D) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	result, err := m.Collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: userOID}}, update)
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrNoData // account might have been deleted
+	}
+
+	return nil
+}
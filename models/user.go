@@ -5,6 +5,7 @@ import (
 	"forza-garage/apperror"
 	"forza-garage/database"
 	"forza-garage/helpers"
+	"forza-garage/logging"
 	"forza-garage/lookups"
 	"sort"
 	"time"
@@ -30,11 +31,14 @@ type User struct {
 	PrivacyCode    int32              `json:"privacyCode" bson:"privacyCD"`
 	PrivacyText    string             `json:"privacyText" bson:"-"` // what to show to others in profile (usr-name vs xbox-tag)
 	Joined         time.Time          `json:"joinedTS" bson:"-"`
-	LastSeenTS     []time.Time        `json:"lastSeen" bson:"lastSeen,omitempty"` // limited to 5 in DB-Query (setLastSeen)
-	Friends        []UserRef          `json:"friends" bson:"-"`                   // loaded from diff. collection, at request
-	Following      []UserRef          `json:"following" bson:"-"`                 // loaded from diff. collection, at request
-	Followers      []UserRef          `json:"followers" bson:"-"`                 // loaded from diff. collection, at request
-	ProfilePicture *FileInfo          `json:"profilePicture,omitempty" bson:"-"`  // set by func
+	LastSeenTS     []time.Time        `json:"lastSeen" bson:"lastSeen,omitempty"`                       // limited to 5 in DB-Query (setLastSeen)
+	Friends        []UserRef          `json:"friends" bson:"-"`                                         // loaded from diff. collection, at request
+	Following      []UserRef          `json:"following" bson:"-"`                                       // loaded from diff. collection, at request
+	Followers      []UserRef          `json:"followers" bson:"-"`                                       // loaded from diff. collection, at request
+	ProfilePicture *FileInfo          `json:"profilePicture,omitempty" bson:"-"`                        // set by func
+	SuspendedUntil *time.Time         `json:"suspendedUntil,omitempty" bson:"suspendedUntil,omitempty"` // set by admins
+	PasswordReset  bool               `json:"passwordReset" bson:"pwdReset,omitempty"`                  // forced by admins, cleared by SetPassword
+	DeletionTS     *time.Time         `json:"deletionTS,omitempty" bson:"deletionTS,omitempty"`         // account is purged after this date
 
 	// ToDo: []LastPasswords - check for 90 days or 10 entries
 }
@@ -68,6 +72,15 @@ type UserModel struct {
 	Collection        *mongo.Collection
 	Social            *mongo.Collection
 	GetProfilePicture func(profileOID primitive.ObjectID, userID string) ([]FileInfo, error) // injected from upload model
+	// injected to purge deleted accounts
+	AnonymizeCourses    func(userOID primitive.ObjectID, userName string) error
+	AnonymizeComments   func(userOID primitive.ObjectID, userName string) error
+	RevokeVotes         func(userOID primitive.ObjectID) error
+	DeleteUploads       func(userOID primitive.ObjectID) error
+	RevokeSessions      func(userID string) (int64, error)
+	DeleteNotifications func(userOID primitive.ObjectID) error
+	Audit               func(ctx context.Context, actor string, action string, target string, details interface{})
+	Log                 *logging.Logger
 }
 
 // UserExists checks if a User Name is available - used in client for in-type error checking
@@ -114,6 +127,9 @@ func (m UserModel) CreateUser(user User) (string, error) {
 	user.ID = primitive.NewObjectID()
 	user.Password = pwdHash
 	user.RoleCode = lookups.UserRoleGuest
+	user.SuspendedUntil = nil // can't be passed by the client
+	user.PasswordReset = false
+	user.DeletionTS = nil
 	user.LastSeenTS = append(user.LastSeenTS, time.Now())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -192,7 +208,8 @@ func (m UserModel) GetUserByID(executiveUserID string, effectiveUserID string) (
 		user.ProfilePicture.Description = pp[0].Description
 		user.ProfilePicture.StatusCode = pp[0].StatusCode
 		user.ProfilePicture.StatusText = pp[0].StatusText
-		user.ProfilePicture.URL = pp[0].URL // filename only - URL is built by controller
+		user.ProfilePicture.URL = pp[0].URL
+		user.ProfilePicture.Renditions = pp[0].Renditions
 	}
 
 	// add look-up text
@@ -264,6 +281,123 @@ func (m UserModel) GetUserNameOID(userID primitive.ObjectID) (string, error) {
 	return data.LoginName, nil
 }
 
+// GetUserIDByName returns the OID of a login name (mentions)
+// accounts scheduled for deletion are not found
+func (m UserModel) GetUserIDByName(loginName string) (primitive.ObjectID, error) {
+
+	data := struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	filter := bson.D{
+		{Key: "loginName", Value: loginName},
+		{Key: "deletionTS", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+
+	err := m.Collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}})).Decode(&data)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return primitive.NilObjectID, ErrInvalidUser
+		}
+		return primitive.NilObjectID, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return data.ID, nil
+}
+
+// GetDisplayNames returns the names shown to other users (login name or XBox tag, see PrivacyCode)
+// accounts scheduled for deletion are omitted
+func (m UserModel) GetDisplayNames(userOIDs []primitive.ObjectID) (map[primitive.ObjectID]string, error) {
+
+	filter := bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$in", Value: userOIDs}}},
+		{Key: "deletionTS", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+
+	fields := bson.D{
+		{Key: "loginName", Value: 1},
+		{Key: "XBoxTag", Value: 1},
+		{Key: "privacyCD", Value: 1},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := m.Collection.Find(ctx, filter, options.Find().SetProjection(fields))
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var users []User
+
+	err = cursor.All(ctx, &users)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	names := make(map[primitive.ObjectID]string, len(users))
+	for _, u := range users {
+		if u.PrivacyCode == lookups.PrivacyXboxTag && u.XBoxTag != "" {
+			names[u.ID] = u.XBoxTag
+		} else {
+			names[u.ID] = u.LoginName
+		}
+	}
+
+	return names, nil
+}
+
+// GetCommentProfile resolves a user's profile as a commentable profile
+// profiles are shown to members only; users who were blocked by the owner are denied
+func (m UserModel) GetCommentProfile(profileUserID string, userID string) (*CommentProfile, error) {
+
+	profileOID, err := primitive.ObjectIDFromHex(profileUserID)
+	if err != nil {
+		return nil, apperror.ErrNoData
+	}
+
+	credentials := m.GetCredentials(userID, false)
+	if credentials.RoleCode == lookups.UserRoleGuest {
+		return nil, apperror.ErrGuest
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	// accounts scheduled for deletion are not shown anymore
+	filter := bson.D{
+		{Key: "_id", Value: profileOID},
+		{Key: "deletionTS", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+
+	count, err := m.Collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+	if count == 0 {
+		return nil, apperror.ErrNoData
+	}
+
+	if credentials.RoleCode != lookups.UserRoleAdmin {
+		blocked, err := m.Social.CountDocuments(ctx, bson.D{
+			{Key: "relType", Value: "blocking"},
+			{Key: "userID", Value: profileOID},
+			{Key: "refID", Value: credentials.UserID},
+		})
+		if err != nil {
+			return nil, helpers.WrapError(err, helpers.FuncName())
+		}
+		if blocked > 0 {
+			return nil, apperror.ErrDenied
+		}
+	}
+
+	return &CommentProfile{ProfileID: profileOID, OwnerID: profileOID}, nil
+}
+
 // CheckPassword tests if a login's password matches
 // (kein DB-Zugriff nötig)
 func (m UserModel) CheckPassword(givenPassword string, userInfo User) bool {
@@ -309,7 +443,10 @@ func (m UserModel) SetPassword(userID primitive.ObjectID, newPassword string) er
 	}
 
 	filter := bson.D{{Key: "_id", Value: userID}}
-	update := bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: pwdHash}}}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "password", Value: pwdHash}}},
+		{Key: "$unset", Value: bson.D{{Key: "pwdReset", Value: ""}}}, // a forced reset is completed
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel() // nach 10 Sekunden abbrechen
@@ -430,7 +567,7 @@ func (m UserModel) BlockUser(userID string, blockedUserID string) error {
 		UserName:      userName,
 		ReferenceID:   blockedUserInfo.UserID,
 		ReferenceName: blockedUserInfo.LoginName,
-		ReferenceType: "user",
+		ReferenceType: ContentTypeUser,
 		RelationType:  "blocking"}
 
 	// nil or wrapped error
@@ -505,7 +642,7 @@ func (m UserModel) AddFriend(userID string, friendUserID string) error {
 		UserName:      userName,
 		ReferenceID:   friendOID,
 		ReferenceName: friendInfo.LoginName,
-		ReferenceType: "user",
+		ReferenceType: ContentTypeUser,
 		RelationType:  "friend"}
 
 	// nil or wrapped error
@@ -553,7 +690,7 @@ func (m UserModel) FollowUser(userID string, followUserID string) error {
 		UserName:      userName,
 		ReferenceID:   followOID,
 		ReferenceName: followInfo.LoginName,
-		ReferenceType: "user",
+		ReferenceType: ContentTypeUser,
 		RelationType:  "following"}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -703,7 +840,7 @@ func (m UserModel) getReferences(userID string, relationType string) ([]UserRef,
 				reference.ReferenceID = r.UserID
 				reference.ReferenceName = r.UserName
 			}
-			reference.ReferenceType = "user"
+			reference.ReferenceType = ContentTypeUser
 			reference.ReferenceType = relationType
 
 			references = append(references, reference)
@@ -720,7 +857,7 @@ func (m UserModel) getReferences(userID string, relationType string) ([]UserRef,
 			reference.UserName = r.UserName
 			reference.ReferenceID = r.ReferenceID
 			reference.ReferenceName = r.ReferenceName
-			reference.ReferenceType = "user"
+			reference.ReferenceType = ContentTypeUser
 			reference.RelationType = relationType
 
 			references = append(references, reference)
@@ -733,7 +870,7 @@ func (m UserModel) getReferences(userID string, relationType string) ([]UserRef,
 			reference.UserName = r.ReferenceName
 			reference.ReferenceID = r.UserID
 			reference.ReferenceName = r.UserName
-			reference.ReferenceType = "user"
+			reference.ReferenceType = ContentTypeUser
 			reference.RelationType = relationType
 
 			references = append(references, reference)
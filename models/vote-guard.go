@@ -0,0 +1,201 @@
+package models
+
+import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/helpers"
+	"forza-garage/lookups"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// protection of the ratings against manipulation:
+// - rate limits per user and per user & profile (flipping votes)
+// - votes of new accounts are saved, but not counted before a minimum account age
+// - a detector lists clusters of votes from the same IP or from new accounts (admin report)
+
+// kinds of vote clusters
+const (
+	VoteClusterIP          = "ip"          // many votes from the same address
+	VoteClusterNewAccounts = "newAccounts" // many votes from recently registered accounts
+)
+
+// VoteCluster is a suspicious group of votes on a profile
+type VoteCluster struct {
+	Kind        string             `json:"kind"`
+	ProfileID   primitive.ObjectID `json:"profileId"`
+	ProfileType string             `json:"profileType"`
+	IP          string             `json:"ip,omitempty"` // kind ip only
+	Votes       int32              `json:"votes"`
+	UpVotes     int32              `json:"upVotes"`
+	UserNames   []string           `json:"userNames"`
+	FirstVoteTS time.Time          `json:"firstVoteTS"`
+	LastVoteTS  time.Time          `json:"lastVoteTS"`
+}
+
+// Throttle checks the rate limits of a user's votes (VOTE_RATE_LIMIT per minute, VOTE_CHANGE_LIMIT per profile and hour)
+// revokes of deleted accounts are done by the system and not throttled (see RevokeUserVotes)
+func (v VoteModel) Throttle(userID string, profileOID primitive.ObjectID) error {
+
+	if !v.Allow("vote_"+userID, voteSetting("VOTE_RATE_LIMIT", 10), time.Minute) {
+		return ErrVoteLimitExceeded
+	}
+
+	if !v.Allow("vote_"+userID+"_"+profileOID.Hex(), voteSetting("VOTE_CHANGE_LIMIT", 3), time.Hour) {
+		return ErrVoteLimitExceeded
+	}
+
+	return nil
+}
+
+// DetectClusters lists suspicious groups of votes cast within the last hours (admins only)
+// a cluster consists of at least VOTE_CLUSTER_SIZE votes on one profile
+func (v VoteModel) DetectClusters(hours int, executiveUserID string) ([]VoteCluster, error) {
+
+	if v.GetCredentials(executiveUserID, false).RoleCode != lookups.UserRoleAdmin {
+		return nil, apperror.ErrDenied
+	}
+
+	if hours <= 0 || hours > 24*30 {
+		hours = 24
+	}
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+
+	// same address
+	byIP, err := v.findClusters(VoteClusterIP,
+		bson.D{
+			{Key: "voteTS", Value: bson.D{{Key: "$gte", Value: since}}},
+			{Key: "ip", Value: bson.D{{Key: "$exists", Value: true}, {Key: "$ne", Value: ""}}},
+		},
+		bson.D{
+			{Key: "profileID", Value: "$profileID"},
+			{Key: "ip", Value: "$ip"},
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// accounts registered shortly before (the account's age is part of its OID)
+	newAccounts := primitive.NewObjectIDFromTimestamp(time.Now().AddDate(0, 0, -voteSetting("VOTE_NEW_ACCOUNT_DAYS", 7)))
+	byAge, err := v.findClusters(VoteClusterNewAccounts,
+		bson.D{
+			{Key: "voteTS", Value: bson.D{{Key: "$gte", Value: since}}},
+			{Key: "userID", Value: bson.D{{Key: "$gt", Value: newAccounts}}},
+		},
+		bson.D{
+			{Key: "profileID", Value: "$profileID"},
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := append(byIP, byAge...)
+	if len(clusters) == 0 {
+		return nil, apperror.ErrNoData
+	}
+
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].Votes > clusters[j].Votes
+	})
+
+	return clusters, nil
+}
+
+// internal helpers
+
+// groups the matching votes and returns the groups reaching the cluster size
+func (v VoteModel) findClusters(kind string, match bson.D, group bson.D) ([]VoteCluster, error) {
+
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: match}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: group},
+			{Key: "profileType", Value: bson.D{{Key: "$first", Value: "$profileType"}}},
+			{Key: "votes", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "upVotes", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$eq", Value: bson.A{"$vote", VoteUp}}}, 1, 0}}}}}},
+			{Key: "userNames", Value: bson.D{{Key: "$addToSet", Value: "$userName"}}},
+			{Key: "firstVoteTS", Value: bson.D{{Key: "$min", Value: "$voteTS"}}},
+			{Key: "lastVoteTS", Value: bson.D{{Key: "$max", Value: "$voteTS"}}},
+		}}},
+		bson.D{{Key: "$match", Value: bson.D{{Key: "votes", Value: bson.D{{Key: "$gte", Value: voteSetting("VOTE_CLUSTER_SIZE", 5)}}}}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "votes", Value: -1}}}},
+		bson.D{{Key: "$limit", Value: 100}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := v.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var result []struct {
+		Key struct {
+			ProfileID primitive.ObjectID `bson:"profileID"`
+			IP        string             `bson:"ip"`
+		} `bson:"_id"`
+		ProfileType string    `bson:"profileType"`
+		Votes       int32     `bson:"votes"`
+		UpVotes     int32     `bson:"upVotes"`
+		UserNames   []string  `bson:"userNames"`
+		FirstVoteTS time.Time `bson:"firstVoteTS"`
+		LastVoteTS  time.Time `bson:"lastVoteTS"`
+	}
+
+	err = cursor.All(ctx, &result)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	clusters := make([]VoteCluster, len(result))
+	for i, r := range result {
+		clusters[i] = VoteCluster{
+			Kind:        kind,
+			ProfileID:   r.Key.ProfileID,
+			ProfileType: r.ProfileType,
+			IP:          r.Key.IP,
+			Votes:       r.Votes,
+			UpVotes:     r.UpVotes,
+			UserNames:   r.UserNames,
+			FirstVoteTS: r.FirstVoteTS,
+			LastVoteTS:  r.LastVoteTS,
+		}
+	}
+
+	return clusters, nil
+}
+
+// votes of accounts younger than VOTE_MIN_ACCOUNT_DAYS are not counted
+// (the account's creation date is part of its OID)
+func countedVoters() bson.D {
+	minAge := primitive.NewObjectIDFromTimestamp(time.Now().AddDate(0, 0, -voteSetting("VOTE_MIN_ACCOUNT_DAYS", 1)))
+	return bson.D{{Key: "userID", Value: bson.D{{Key: "$lt", Value: minAge}}}}
+}
+
+// votes included in the counters: the flag is set when a vote is cast and when its account
+// reaches the minimum age (Reconcile); votes saved before the flag was stored follow the age rule
+func countedVotes() bson.D {
+	return bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "counted", Value: true}},
+		bson.D{{Key: "$and", Value: bson.A{
+			bson.D{{Key: "counted", Value: bson.D{{Key: "$exists", Value: false}}}},
+			countedVoters(),
+		}}},
+	}}}
+}
+
+// same rule as countedVoters, applied to a single voter
+func voteCounted(userOID primitive.ObjectID) bool {
+	return userOID.Timestamp().Before(time.Now().AddDate(0, 0, -voteSetting("VOTE_MIN_ACCOUNT_DAYS", 1)))
+}
+
+// reads a numeric setting of the voting system
+func voteSetting(name string, defaultValue int) int {
+	return helpers.IntSetting(name, defaultValue, 0)
+}
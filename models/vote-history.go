@@ -0,0 +1,233 @@
+package models
+
+import (
+	"context"
+	"forza-garage/apperror"
+	"forza-garage/helpers"
+	"forza-garage/lookups"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// history of the votes
+// a vote document keeps the latest state only, hence every change is recorded as an event
+// (within the transaction of CastVote). The events are used to show the reception of a profile.
+// votes of new accounts are added to the counters when the accounts reach the minimum age (Reconcile),
+// which is recorded as an event too, hence the deltas of the events add up to the counters.
+
+// VoteEvent is a change of a user's vote (internal)
+type VoteEvent struct {
+	ProfileID   primitive.ObjectID `bson:"profileID"`
+	ProfileType string             `bson:"profileType"`
+	UserID      primitive.ObjectID `bson:"userID,omitempty"` // removed when the account is purged
+	Vote        int32              `bson:"vote"`
+	Previous    int32              `bson:"previous"`
+	UpDelta     int32              `bson:"upDelta"` // change of the profile's counters (0 for votes not counted)
+	DownDelta   int32              `bson:"downDelta"`
+	Activated   bool               `bson:"activated,omitempty"` // pending vote added to the counters (no change of the vote)
+	EventTS     time.Time          `bson:"eventTS"`
+}
+
+// VoteHistoryDay is the reception of a profile on a day
+type VoteHistoryDay struct {
+	Date      string  `json:"date"`      // YYYY-MM-DD (UTC)
+	UpVotes   int32   `json:"upVotes"`   // cast on this day
+	DownVotes int32   `json:"downVotes"` // cast on this day
+	Revokes   int32   `json:"revokes"`
+	TotalUp   int32   `json:"totalUp"` // counters at the end of the day
+	TotalDown int32   `json:"totalDown"`
+	Rating    float32 `json:"rating"`
+}
+
+// GetVoteHistory returns the daily votes and rating of a profile within a date range (creator & admins)
+// the totals are derived backwards from the current counters, so votes cast before the history was recorded are included
+// (votes of new accounts are included from the day they're counted)
+func (v VoteModel) GetVoteHistory(profileType string, profileID string, from time.Time, to time.Time, executiveUserID string) ([]VoteHistoryDay, error) {
+
+	profileOID, err := primitive.ObjectIDFromHex(profileID)
+	if err != nil {
+		return nil, apperror.ErrNoData
+	}
+
+	profile, ok := v.Profiles[profileType]
+	if !ok {
+		return nil, apperror.ErrNoData
+	}
+
+	voteProfile, err := profile.GetVoteProfile(profileOID, executiveUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := v.GetCredentials(executiveUserID, false)
+	if voteProfile.OwnerID != credentials.UserID && credentials.RoleCode != lookups.UserRoleAdmin {
+		return nil, apperror.ErrDenied
+	}
+
+	// whole days (UTC)
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	if !to.After(from) || to.Sub(from) > 366*24*time.Hour {
+		return nil, ErrInvalidDateRange
+	}
+
+	// current counters
+	up, down := voteProfile.UpVotes, voteProfile.DownVotes
+
+	// activations are no votes cast
+	isVote := func(value int32) bson.D {
+		return bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$and", Value: bson.A{
+				bson.D{{Key: "$eq", Value: bson.A{"$vote", value}}},
+				bson.D{{Key: "$ne", Value: bson.A{"$activated", true}}},
+			}}}, 1, 0}}}}}
+	}
+
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "profileID", Value: profileOID},
+			{Key: "eventTS", Value: bson.D{{Key: "$gte", Value: from}}},
+		}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "$dateToString", Value: bson.D{
+				{Key: "format", Value: "%Y-%m-%d"},
+				{Key: "date", Value: "$eventTS"},
+			}}}},
+			{Key: "upVotes", Value: isVote(VoteUp)},
+			{Key: "downVotes", Value: isVote(VoteDown)},
+			{Key: "revokes", Value: isVote(VoteNeutral)},
+			{Key: "upDelta", Value: bson.D{{Key: "$sum", Value: "$upDelta"}}},
+			{Key: "downDelta", Value: bson.D{{Key: "$sum", Value: "$downDelta"}}},
+		}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := v.History.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var result []struct {
+		Date      string `bson:"_id"`
+		UpVotes   int32  `bson:"upVotes"`
+		DownVotes int32  `bson:"downVotes"`
+		Revokes   int32  `bson:"revokes"`
+		UpDelta   int32  `bson:"upDelta"`
+		DownDelta int32  `bson:"downDelta"`
+	}
+
+	err = cursor.All(ctx, &result)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// counters at the beginning of the range: current count less the changes since then
+	days := make(map[string]int)
+	for i, r := range result {
+		days[r.Date] = i
+		up -= r.UpDelta
+		down -= r.DownDelta
+	}
+
+	// every day of the range is returned (continuous charts); changes after the range are skipped
+	var history []VoteHistoryDay
+	for day := from; day.Before(to) && !day.After(time.Now()); day = day.AddDate(0, 0, 1) {
+		entry := VoteHistoryDay{Date: day.Format("2006-01-02")}
+
+		if i, ok := days[entry.Date]; ok {
+			entry.UpVotes = result[i].UpVotes
+			entry.DownVotes = result[i].DownVotes
+			entry.Revokes = result[i].Revokes
+			up += result[i].UpDelta
+			down += result[i].DownDelta
+		}
+
+		social := &Social{UpVotes: up, DownVotes: down}
+		rate(social)
+
+		entry.TotalUp = up
+		entry.TotalDown = down
+		entry.Rating = social.Rating
+
+		history = append(history, entry)
+	}
+
+	if len(history) == 0 {
+		return nil, apperror.ErrNoData
+	}
+
+	return history, nil
+}
+
+// internal helpers
+
+// records the change of a vote (called within the transaction of CastVote)
+func (v VoteModel) recordEvent(ctx context.Context, vote Vote, previous int32, up int32, down int32) error {
+
+	// re-casting the same vote is not an event
+	if previous == vote.Vote {
+		return nil
+	}
+
+	event := VoteEvent{
+		ProfileID:   vote.ProfileID,
+		ProfileType: vote.ProfileType,
+		UserID:      vote.UserID,
+		Vote:        vote.Vote,
+		Previous:    previous,
+		UpDelta:     up,
+		DownDelta:   down,
+		EventTS:     time.Now(),
+	}
+
+	_, err := v.History.InsertOne(ctx, event)
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return nil
+}
+
+// records a pending vote added to the counters (called within the transaction of Reconcile)
+func (v VoteModel) recordActivation(ctx context.Context, vote pendingVote, up int32, down int32) error {
+
+	event := VoteEvent{
+		ProfileID:   vote.ProfileID,
+		ProfileType: vote.ProfileType,
+		UserID:      vote.UserID,
+		Vote:        vote.Vote,
+		Previous:    vote.Vote,
+		UpDelta:     up,
+		DownDelta:   down,
+		Activated:   true,
+		EventTS:     time.Now(),
+	}
+
+	_, err := v.History.InsertOne(ctx, event)
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return nil
+}
+
+// removes the user from the events of a purged account (the counts are kept)
+func (v VoteModel) anonymizeEvents(userOID primitive.ObjectID) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	_, err := v.History.UpdateMany(ctx,
+		bson.D{{Key: "userID", Value: userOID}},
+		bson.D{{Key: "$unset", Value: bson.D{{Key: "userID", Value: ""}}}})
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return nil
+}
@@ -4,13 +4,18 @@ import (
 	"context"
 	"forza-garage/apperror"
 	"forza-garage/helpers"
+	"forza-garage/logging"
+	"forza-garage/lookups"
 	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
 )
 
 // vote (action) type
@@ -33,6 +38,8 @@ type Vote struct {
 	UserName    string             `json:"userName" bson:"-"`
 	VoteTS      time.Time          `json:"voteTS" bson:"voteTS"`                 // stored separately because users can change their vote
 	Vote        int32              `json:"vote" bson:"vote" validate:"required"` // https://github.com/go-playground/validator/issues/290
+	IP          string             `json:"-" bson:"ip,omitempty"`                // set by controller (cluster detection)
+	Counted     bool               `json:"-" bson:"counted"`                     // included in the profile's counters (age of the account)
 }
 
 // ProfileVotes represents the current state of votes related to a profile
@@ -45,126 +52,116 @@ type ProfileVotes struct {
 // UserVote represents a user's vote actions to a profile
 // usually used as a slice type for lists
 type UserVote struct {
-	ProfileID primitive.ObjectID `json:"profileId"`
+	ProfileID primitive.ObjectID `json:"profileId" bson:"profileID"`
 	UserVote  int32              `json:"userVote" bson:"vote"` // primitive values need bson tag
 }
 
+// Voter is a user who voted for a profile (shown to the profile's owner)
+type Voter struct {
+	UserID primitive.ObjectID `json:"userId"`
+	Name   string             `json:"name"` // login name or XBox tag (privacy settings of the voter)
+	VoteTS time.Time          `json:"voteTS"`
+}
+
+// VoterList is a page of a profile's voters
+type VoterList struct {
+	Voters     []Voter `json:"voters"`
+	NextCursor string  `json:"nextCursor,omitempty"` // passed to read the next page (none if this is the last one)
+}
+
 // VoteModel provides the logics to the data type
 type VoteModel struct {
+	Client     *mongo.Client // transactions
 	Collection *mongo.Collection
+	History    *mongo.Collection // vote events
 	// Gewisse Informationen kommen vom User-Model, die werden hier referenziert
 	// somit muss das nicht der Controller machen
 	GetUserNameOID func(ID primitive.ObjectID) (string, error)
+	GetCredentials func(userId string, loadFriendlist bool) *Credentials
+	Allow          func(key string, limit int, window time.Duration) bool // injected rate limiter
+	Profiles       map[string]Votable                                     // voted domains by profile type
+	GetVoterNames  func(userOIDs []primitive.ObjectID) (map[primitive.ObjectID]string, error)
+	Log            *logging.Logger
 }
 
 // CastVotes is used to vote for/against something (a profile, eg. Course/Championship)
 // It also calcalutes the new rating and lower boundary to sort the profiles
-func (v VoteModel) CastVote(
-	vote Vote,
-	SetRating func(social *Social) error) (profileVotes *ProfileVotes, err error) {
+// the vote and the profile's counters are changed in one transaction (requires a replica set)
+func (v VoteModel) CastVote(vote Vote, profile Votable) (profileVotes *ProfileVotes, err error) {
 
 	// Positive | Negative votes will be Upserts
 	// Revokes will be Deletes
 
 	// Keine Prüfung, ob das ObjectID gültig ist. (dann braucht's alle COllections :-/)
 
-	// 1. save or delete vote
+	var userName string
 	if vote.Vote != VoteNeutral {
-		usr, err := v.GetUserNameOID(vote.UserID)
+		userName, err = v.GetUserNameOID(vote.UserID)
 		if err != nil {
 			return nil, ErrInvalidUser
 		}
+	}
 
-		filter := bson.D{
-			{Key: "profileID", Value: vote.ProfileID},
-			{Key: "userID", Value: vote.UserID},
-		}
-
-		fields := bson.D{
-			{Key: "$set", Value: bson.D{{Key: "profileID", Value: vote.ProfileID}}},
-			{Key: "$set", Value: bson.D{{Key: "profileType", Value: vote.ProfileType}}},
-			{Key: "$set", Value: bson.D{{Key: "userID", Value: vote.UserID}}},
-			{Key: "$set", Value: bson.D{{Key: "userName", Value: usr}}},
-			{Key: "$set", Value: bson.D{{Key: "voteTS", Value: time.Now()}}}, // $currentDate müsste nochmal "verpackt" werden
-			{Key: "$set", Value: bson.D{{Key: "vote", Value: vote.Vote}}},
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
 
-		opts := options.Update().SetUpsert(true)
+	session, err := v.Client.StartSession()
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+	defer session.EndSession(ctx)
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel() // nach 10 Sekunden abbrechen
+	// retried by the driver on transient errors (write conflicts of concurrent votes)
+	result, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
 
-		// not interessted in actual result
-		_, err = v.Collection.UpdateOne(ctx, filter, fields, opts)
+		// 1. save or delete the vote, reading the previous one atomically
+		counted := vote.Vote != VoteNeutral && voteCounted(vote.UserID)
+		previous, previousCounted, err := v.swapVote(sc, vote, userName, counted)
 		if err != nil {
-			return nil, helpers.WrapError(err, helpers.FuncName())
+			return nil, err
 		}
 
-	} else {
-		// delete vote (revoke)
-		filter := bson.D{
-			{Key: "profileID", Value: vote.ProfileID},
-			{Key: "userID", Value: vote.UserID},
-		}
+		// 2. adjust the counters of the profile by the difference
+		// the previous vote is subtracted only if it was added (flag stored with the vote)
+		up, down := voteDelta(countedVote(previous, previousCounted), countedVote(vote.Vote, counted))
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel() // nach 10 Sekunden abbrechen
+		err = v.recordEvent(sc, vote, previous, up, down)
+		if err != nil {
+			return nil, err
+		}
 
-		// not interessted in actual result
-		_, err := v.Collection.DeleteOne(ctx, filter)
+		social, err := profile.IncVotes(sc, vote.ProfileID, up, down)
 		if err != nil {
-			return nil, helpers.WrapError(err, helpers.FuncName())
+			// the vote is kept even if the profile was deleted in the meantime
+			if err == apperror.ErrNoData {
+				return &Social{ProfileOID: vote.ProfileID}, nil
+			}
+			return nil, err
 		}
-	}
 
-	// 2. calculate the new rating and sort order of the profile
-	// reasons for client-side/api implemenation:
-	//  I. speed
-	// II. complexity of queries
+		// 3. calculate the new rating and sort order from the counters
+		// reasons for client-side/api implemenation:
+		//  I. speed
+		// II. complexity of queries
+		rate(social)
+		social.TouchedTS = time.Now()
 
-	// https://github.com/omsec/racing-db/blob/master/setup.sql
-	// #441
+		err = profile.SetRating(sc, social)
+		if err != nil && err != apperror.ErrNoData {
+			return nil, err
+		}
 
-	// ToDo:
-	// überlegen, ob hier wirklich agregiert werden soll/kann (performance)
-	up, down, err := v.countVotes(vote.ProfileID)
+		return social, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// https://yourbasic.org/golang/round-float-to-int/
-
-	var rating float32 = 0
-	var ratingSort float32 = 0
-
-	upVotes := float64(up)
-	downVotes := float64(down)
-	totalVotes := upVotes + downVotes
-
-	if upVotes > 0 && totalVotes > 0 {
-		rating = float32(math.Round(float64((((upVotes/totalVotes)*4)+1)*2) / 2))
-		ratingSort = float32((upVotes+1.9208)/totalVotes - 1.96*math.Sqrt((upVotes*downVotes)/totalVotes+0.9604)/totalVotes/(1+3.8416/totalVotes)) // lower bound
-	}
-
-	// pass "social meta data" to the referenced profile
-	// which will stored it in its document
-
-	// the struct literal syntax also creates the variable
-	// https://yourbasic.org/golang/structs-explained/
-	social := &Social{
-		ProfileOID: vote.ProfileID,
-		Rating:     rating,
-		SortOrder:  ratingSort,
-		UpVotes:    up,
-		DownVotes:  down,
-		TouchedTS:  time.Now(),
-	}
-
-	SetRating(social)
+	social := result.(*Social)
 
 	profileVotes = new(ProfileVotes)
-	profileVotes.DownVotes = down
-	profileVotes.UpVotes = up
+	profileVotes.DownVotes = social.DownVotes
+	profileVotes.UpVotes = social.UpVotes
 	profileVotes.UserVote = vote.Vote
 
 	return profileVotes, nil
@@ -251,63 +248,503 @@ func (v VoteModel) GetUserVotes(domain string, userID string) ([]UserVote, error
 	return votes, nil
 }
 
+// GetUserVotesFor returns the vote actions of a user to the given items (eg. the comments of a list)
+func (v VoteModel) GetUserVotesFor(userID string, profileOIDs []primitive.ObjectID) ([]UserVote, error) {
+
+	if len(profileOIDs) == 0 {
+		return nil, apperror.ErrNoData
+	}
+
+	fields := bson.D{
+		{Key: "_id", Value: 0},
+		{Key: "profileID", Value: 1},
+		{Key: "vote", Value: 1},
+	}
+
+	filter := bson.D{
+		{Key: "userID", Value: helpers.ObjectID(userID)},
+		{Key: "profileID", Value: bson.D{{Key: "$in", Value: profileOIDs}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := v.Collection.Find(ctx, filter, options.Find().SetProjection(fields))
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var votes []UserVote
+
+	err = cursor.All(ctx, &votes)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	if votes == nil {
+		return nil, apperror.ErrNoData
+	}
+
+	return votes, nil
+}
+
+// ListUserVotes returns all votes of a user (data export)
+func (v VoteModel) ListUserVotes(userOID primitive.ObjectID) ([]Vote, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := v.Collection.Find(ctx, bson.D{{Key: "userID", Value: userOID}})
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var votes []Vote
+
+	err = cursor.All(ctx, &votes)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return votes, nil
+}
+
+// RevokeUserVotes removes all votes of a user (deleted account)
+// each vote is revoked by CastVote, so the ratings of the profiles are recalculated
+func (v VoteModel) RevokeUserVotes(userOID primitive.ObjectID) error {
+
+	votes, err := v.ListUserVotes(userOID)
+	if err != nil {
+		return err
+	}
+
+	for _, vote := range votes {
+		profile, ok := v.Profiles[vote.ProfileType]
+		if !ok {
+			// unknown domain - vote is removed without updating the profile
+			profile = unknownProfile{}
+		}
+
+		vote.Vote = VoteNeutral
+		_, err = v.CastVote(vote, profile)
+		if err != nil {
+			return err
+		}
+	}
+
+	return v.anonymizeEvents(userOID)
+}
+
+// Reconcile recounts the votes of the profiles voted since the given time and corrects their counters and rating
+// the counters are maintained incrementally by CastVote; the votes of accounts which reached
+// the minimum age meanwhile are added first, a recount repairs drifts (eg. failed writes).
+// the profiles are read from the vote events in batches; false is returned if a profile failed,
+// the caller passes the same time again (zero time: all profiles)
+// usually called by a GO-routine that runs in a ticker
+func (v VoteModel) Reconcile(since time.Time) bool {
+
+	const batchSize = 100
+
+	v.activateVotes()
+
+	complete := true
+	lastOID := primitive.NilObjectID
+
+	for {
+		profiles, err := v.readTouchedProfiles(since, lastOID, batchSize)
+		if err != nil {
+			v.Log.Err(err)
+			return false
+		}
+
+		for _, p := range profiles {
+			lastOID = p.ProfileID
+
+			profile, ok := v.Profiles[p.ProfileType]
+			if !ok {
+				continue
+			}
+
+			err = v.recount(profile, p.ProfileID, p.TouchedTS)
+			if err != nil && err != apperror.ErrNoData {
+				// profile is processed again by the next run
+				v.Log.Err(err, logging.Fields{"profileID": p.ProfileID.Hex()})
+				complete = false
+			}
+		}
+
+		if len(profiles) < batchSize {
+			return complete
+		}
+	}
+}
+
+// voted profile (reconciliation)
+type touchedProfile struct {
+	ProfileID   primitive.ObjectID `bson:"_id"`
+	ProfileType string             `bson:"profileType"`
+	TouchedTS   time.Time          `bson:"touchedTS"`
+}
+
+// reads a batch of the profiles voted since the given time, ordered by their ID
+func (v VoteModel) readTouchedProfiles(since time.Time, afterOID primitive.ObjectID, batchSize int) ([]touchedProfile, error) {
+
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "eventTS", Value: bson.D{{Key: "$gte", Value: since}}},
+			{Key: "profileID", Value: bson.D{{Key: "$gt", Value: afterOID}}},
+		}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$profileID"},
+			{Key: "profileType", Value: bson.D{{Key: "$first", Value: "$profileType"}}},
+			{Key: "touchedTS", Value: bson.D{{Key: "$max", Value: "$eventTS"}}},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: batchSize}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := v.History.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var profiles []touchedProfile
+
+	err = cursor.All(ctx, &profiles)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return profiles, nil
+}
+
 // GetVotes returns the up and down votes as well as the vote of the user
-// zur Zeit unbenutzt (gelesen über parent's meta); evtl. mal für stats-page
-/*
-func (v VoteModel) GetVotes(profileID string, userID string) (profileVotes *ProfileVotes, err error) {
+// the counters are read from the profile (maintained by CastVote and Reconcile)
+// the profile must be visible to the user (anonymous visitors pass an empty ID)
+func (v VoteModel) GetVotes(profileType string, profileID string, userID string) (*ProfileVotes, error) {
 
-	profileOID := helpers.ObjectID(profileID)
-	userOID := helpers.ObjectID(userID)
-	profileVotes = new(ProfileVotes)
+	profileOID, err := primitive.ObjectIDFromHex(profileID)
+	if err != nil {
+		return nil, apperror.ErrNoData
+	}
 
-	// 1. get the user's vote
+	profile, ok := v.Profiles[profileType]
+	if !ok {
+		return nil, apperror.ErrNoData
+	}
+
+	voteProfile, err := profile.GetVoteProfile(profileOID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	profileVotes := &ProfileVotes{UpVotes: voteProfile.UpVotes, DownVotes: voteProfile.DownVotes}
+
+	// vote of the user (anonymous visitors did not vote)
+	profileVotes.UserVote = VoteNeutral
 	if userID != "" {
-		filter := bson.D{
-			{Key: "profileID", Value: profileOID},
-			{Key: "userID", Value: userOID},
+		profileVotes.UserVote, err = v.GetUserVote(profileID, userID)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		fields := bson.D{
-			{Key: "_id", Value: 0}, // _id kommt immer, daher explizit ausschalten
-			{Key: "vote", Value: 1},
+	return profileVotes, nil
+}
+
+// ListVoters returns a page of the users who voted for a profile (owner & admins)
+// most recent votes first; the time and ID of the last vote of a page are passed as the cursor to read the next page
+func (v VoteModel) ListVoters(profileType string, profileID string, cursorID string, pageSize int, executiveUserID string) (*VoterList, error) {
+
+	profileOID, err := primitive.ObjectIDFromHex(profileID)
+	if err != nil {
+		return nil, apperror.ErrNoData
+	}
+
+	profile, ok := v.Profiles[profileType]
+	if !ok {
+		return nil, apperror.ErrNoData
+	}
+
+	voteProfile, err := profile.GetVoteProfile(profileOID, executiveUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := v.GetCredentials(executiveUserID, false)
+	if voteProfile.OwnerID != credentials.UserID && credentials.RoleCode != lookups.UserRoleAdmin {
+		return nil, apperror.ErrDenied
+	}
+
+	if pageSize <= 0 || pageSize > 50 {
+		pageSize = 20
+	}
+
+	filter := bson.D{
+		{Key: "profileID", Value: profileOID},
+		{Key: "vote", Value: VoteUp},
+	}
+	if cursorID != "" {
+		cursorTS, cursorOID, err := parseVoterCursor(cursorID)
+		if err != nil {
+			return nil, apperror.ErrNoData
+		}
+		// the vote time isn't unique, the ID decides between votes of the same time
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "voteTS", Value: bson.D{{Key: "$lt", Value: cursorTS}}}},
+			bson.D{{Key: "voteTS", Value: cursorTS}, {Key: "_id", Value: bson.D{{Key: "$lt", Value: cursorOID}}}},
+		}})
+	}
+
+	// one more is read to know if there's another page
+	opts := options.Find().
+		SetProjection(bson.D{{Key: "userID", Value: 1}, {Key: "voteTS", Value: 1}}).
+		SetSort(bson.D{{Key: "voteTS", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(pageSize + 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	cursor, err := v.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var votes []struct {
+		ID     primitive.ObjectID `bson:"_id"`
+		UserID primitive.ObjectID `bson:"userID"`
+		VoteTS time.Time          `bson:"voteTS"`
+	}
+
+	err = cursor.All(ctx, &votes)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	if len(votes) == 0 {
+		return nil, apperror.ErrNoData
+	}
+
+	list := VoterList{Voters: []Voter{}}
+	if len(votes) > pageSize {
+		votes = votes[:pageSize]
+		list.NextCursor = voterCursor(votes[pageSize-1].VoteTS, votes[pageSize-1].ID)
+	}
+
+	userOIDs := make([]primitive.ObjectID, len(votes))
+	for i, vote := range votes {
+		userOIDs[i] = vote.UserID
+	}
+
+	names, err := v.GetVoterNames(userOIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// accounts scheduled for deletion are not listed
+	for _, vote := range votes {
+		if name, ok := names[vote.UserID]; ok {
+			list.Voters = append(list.Voters, Voter{UserID: vote.UserID, Name: name, VoteTS: vote.VoteTS})
+		}
+	}
+
+	return &list, nil
+}
+
+// counts the votes of a profile and saves the counters and rating
+// the snapshot makes concurrent votes of the profile conflict (and retry) instead of being overwritten
+func (v VoteModel) recount(profile Votable, profileOID primitive.ObjectID, touchedTS time.Time) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	session, err := v.Client.StartSession()
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+	defer session.EndSession(ctx)
+
+	opts := options.Transaction().SetReadConcern(readconcern.Snapshot())
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+
+		social := &Social{ProfileOID: profileOID, TouchedTS: touchedTS}
+
+		social.UpVotes, social.DownVotes, err = v.countVotes(sc, profileOID)
+		if err != nil {
+			return nil, err
 		}
 
-		opts := options.FindOne().SetProjection(fields)
+		rate(social)
+
+		return nil, profile.SetRating(sc, social)
+	}, opts)
+
+	return err
+}
+
+// cursor of the voters' list: vote time (milliseconds, precision of the database) and ID of the vote
+func voterCursor(voteTS time.Time, voteOID primitive.ObjectID) string {
+	return strconv.FormatInt(voteTS.UnixNano()/int64(time.Millisecond), 10) + "_" + voteOID.Hex()
+}
+
+func parseVoterCursor(cursor string) (time.Time, primitive.ObjectID, error) {
 
-		// user vote
-		data := struct {
-			Vote int32 `bson:"vote"`
-		}{VoteNeutral}
+	parts := strings.SplitN(cursor, "_", 2)
+	if len(parts) != 2 {
+		return time.Time{}, primitive.NilObjectID, apperror.ErrNoData
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel() // nach 10 Sekunden abbrechen
+	millis, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, err
+	}
 
-		err = v.Collection.FindOne(ctx, filter, opts).Decode(&data)
+	voteOID, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, err
+	}
+
+	return time.Unix(0, millis*int64(time.Millisecond)), voteOID, nil
+}
+
+// pending vote of an account which has not reached the minimum age when it voted
+type pendingVote struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	ProfileID   primitive.ObjectID `bson:"profileID"`
+	ProfileType string             `bson:"profileType"`
+	UserID      primitive.ObjectID `bson:"userID"`
+	Vote        int32              `bson:"vote"`
+}
+
+// adds the votes of accounts which reached the minimum age meanwhile to the counters of their profiles
+// the votes are read in batches, each vote is added in its own transaction
+func (v VoteModel) activateVotes() {
+
+	const batchSize = 100
+
+	filter := bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "counted", Value: false}},
+		countedVoters(),
+	}}}
+
+	for {
+		votes, err := v.readPendingVotes(filter, batchSize)
 		if err != nil {
-			// it's NOT an error if the user didn't vote
-			if err != mongo.ErrNoDocuments {
-				return nil, helpers.WrapError(err, helpers.FuncName())
+			v.Log.Err(err)
+			return
+		}
+
+		for _, vote := range votes {
+			err = v.activateVote(vote)
+			if err != nil {
+				// the vote is still pending, it's processed again by the next run
+				v.Log.Err(err, logging.Fields{"voteID": vote.ID.Hex()})
+				return
 			}
 		}
-		profileVotes.UserVote = data.Vote
-	} else {
-		profileVotes.UserVote = VoteNeutral
+
+		if len(votes) < batchSize {
+			return
+		}
 	}
+}
 
-	// 2. count votes for/against profile
-	profileVotes.UpVotes, profileVotes.DownVotes, err = v.countVotes(profileOID)
+// reads a batch of pending votes
+func (v VoteModel) readPendingVotes(filter bson.D, batchSize int) ([]pendingVote, error) {
 
-	return profileVotes, nil
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(batchSize))
+
+	cursor, err := v.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	var votes []pendingVote
+
+	err = cursor.All(ctx, &votes)
+	if err != nil {
+		return nil, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	return votes, nil
 }
-*/
 
-// count the actual votes for/against a profile
-func (v VoteModel) countVotes(profileOID primitive.ObjectID) (up int32, down int32, err error) {
+// flags a pending vote as counted and adds it to the counters of its profile
+func (v VoteModel) activateVote(vote pendingVote) error {
+
+	profile, ok := v.Profiles[vote.ProfileType]
+	if !ok {
+		profile = unknownProfile{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // nach 10 Sekunden abbrechen
+
+	session, err := v.Client.StartSession()
+	if err != nil {
+		return helpers.WrapError(err, helpers.FuncName())
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+
+		// changed or revoked in the meantime - CastVote has set the flag
+		filter := bson.D{{Key: "_id", Value: vote.ID}, {Key: "counted", Value: false}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "counted", Value: true}}}}
+
+		result, err := v.Collection.UpdateOne(sc, filter, update)
+		if err != nil {
+			return nil, helpers.WrapError(err, helpers.FuncName())
+		}
+		if result.ModifiedCount == 0 {
+			return nil, nil
+		}
+
+		up, down := voteDelta(VoteNeutral, vote.Vote)
+
+		err = v.recordActivation(sc, vote, up, down)
+		if err != nil {
+			return nil, err
+		}
+
+		social, err := profile.IncVotes(sc, vote.ProfileID, up, down)
+		if err != nil {
+			// the vote is flagged even if the profile was deleted in the meantime
+			if err == apperror.ErrNoData {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		rate(social)
+		social.TouchedTS = time.Now()
+
+		err = profile.SetRating(sc, social)
+		if err != nil && err != apperror.ErrNoData {
+			return nil, err
+		}
+
+		return nil, nil
+	})
+
+	return err
+}
+
+// count the actual votes for/against a profile (reconciliation)
+func (v VoteModel) countVotes(ctx context.Context, profileOID primitive.ObjectID) (up int32, down int32, err error) {
 
 	matchStage := bson.D{
 		{Key: "$match", Value: bson.D{
 			{Key: "$and", Value: bson.A{
 				bson.D{{Key: "profileID", Value: profileOID}},
+				countedVotes(), // votes of new accounts are not counted (yet)
 			}},
 		}},
 	}
@@ -326,9 +763,6 @@ func (v VoteModel) countVotes(profileOID primitive.ObjectID) (up int32, down int
 	// https://www.unitconverters.net/time/second-to-nanosecond.htm
 	opts := options.Aggregate().SetMaxTime(5000000000) // 5 secs
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel() // nach 10 Sekunden abbrechen
-
 	cursor, err := v.Collection.Aggregate(ctx, mongo.Pipeline{
 		matchStage,
 		groupStage}, opts)
@@ -357,3 +791,112 @@ func (v VoteModel) countVotes(profileOID primitive.ObjectID) (up int32, down int
 
 	return up, down, nil
 }
+
+// saves or deletes a user's vote and returns the previous one and whether it was counted (find-and-modify)
+func (v VoteModel) swapVote(ctx context.Context, vote Vote, userName string, counted bool) (int32, bool, error) {
+
+	filter := bson.D{
+		{Key: "profileID", Value: vote.ProfileID},
+		{Key: "userID", Value: vote.UserID},
+	}
+
+	previous := struct {
+		Vote    int32 `bson:"vote"`
+		Counted *bool `bson:"counted"`
+	}{Vote: VoteNeutral}
+
+	projection := bson.D{{Key: "vote", Value: 1}, {Key: "counted", Value: 1}}
+
+	var err error
+	if vote.Vote != VoteNeutral {
+		fields := bson.D{
+			{Key: "profileID", Value: vote.ProfileID},
+			{Key: "profileType", Value: vote.ProfileType},
+			{Key: "userID", Value: vote.UserID},
+			{Key: "userName", Value: userName},
+			{Key: "voteTS", Value: time.Now()}, // $currentDate müsste nochmal "verpackt" werden
+			{Key: "vote", Value: vote.Vote},
+			{Key: "counted", Value: counted},
+		}
+		if vote.IP != "" {
+			fields = append(fields, bson.E{Key: "ip", Value: vote.IP})
+		}
+
+		opts := options.FindOneAndUpdate().
+			SetUpsert(true).
+			SetReturnDocument(options.Before).
+			SetProjection(projection)
+
+		err = v.Collection.FindOneAndUpdate(ctx, filter, bson.D{{Key: "$set", Value: fields}}, opts).Decode(&previous)
+	} else {
+		// revoke
+		opts := options.FindOneAndDelete().SetProjection(projection)
+
+		err = v.Collection.FindOneAndDelete(ctx, filter, opts).Decode(&previous)
+	}
+
+	// it's NOT an error if the user didn't vote before
+	if err != nil && err != mongo.ErrNoDocuments {
+		return VoteNeutral, false, helpers.WrapError(err, helpers.FuncName())
+	}
+
+	// votes saved before the flag was stored were counted by the age of the account
+	if previous.Counted == nil {
+		return previous.Vote, previous.Vote != VoteNeutral && voteCounted(vote.UserID), nil
+	}
+
+	return previous.Vote, *previous.Counted, nil
+}
+
+// the vote as it's included in the counters
+func countedVote(vote int32, counted bool) int32 {
+	if !counted {
+		return VoteNeutral
+	}
+	return vote
+}
+
+// differences of the up and down counters when a vote is changed
+func voteDelta(previous int32, current int32) (up int32, down int32) {
+	count := func(vote int32, value int32) int32 {
+		if vote == value {
+			return 1
+		}
+		return 0
+	}
+	return count(current, VoteUp) - count(previous, VoteUp), count(current, VoteDown) - count(previous, VoteDown)
+}
+
+// calculates the rating (stars) and the lower bound of the confidence interval to sort the profiles
+// https://github.com/omsec/racing-db/blob/master/setup.sql
+// #441
+func rate(social *Social) {
+
+	// https://yourbasic.org/golang/round-float-to-int/
+	social.Rating = 0
+	social.SortOrder = 0
+
+	upVotes := float64(social.UpVotes)
+	downVotes := float64(social.DownVotes)
+	totalVotes := upVotes + downVotes
+
+	if upVotes > 0 && totalVotes > 0 {
+		social.Rating = float32(math.Round(float64((((upVotes/totalVotes)*4)+1)*2) / 2))
+		social.SortOrder = float32((upVotes+1.9208)/totalVotes - 1.96*math.Sqrt((upVotes*downVotes)/totalVotes+0.9604)/totalVotes/(1+3.8416/totalVotes)) // lower bound
+	}
+}
+
+// votes to profiles of unknown domains are removed without updating the profile
+type unknownProfile struct{}
+
+func (unknownProfile) GetVoteProfile(profileOID primitive.ObjectID, userID string) (*VoteProfile, error) {
+	return nil, apperror.ErrNoData
+}
+
+func (unknownProfile) IncVotes(ctx context.Context, profileOID primitive.ObjectID, up int32, down int32) (*Social, error) {
+	return nil, apperror.ErrNoData
+}
+
+func (unknownProfile) SetRating(ctx context.Context, social *Social) error {
+	return nil
+}
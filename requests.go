@@ -6,11 +6,14 @@ import (
 	"forza-garage/controllers"
 	"forza-garage/environment"
 	"forza-garage/middleware"
+	"forza-garage/storage"
 	"os"
 )
 
 func handleRequests() {
 	router.Use(middleware.CORSMiddleware())
+	router.Use(middleware.RequestLogger(environment.Env.Log))
+	router.Use(middleware.Recovery()) // after the logger: panics are logged & answered with the request ID
 
 	// uploads: Set a lower memory limit for multipart forms (default is 32 MiB)
 	router.MaxMultipartMemory = 8 << 20 // 8 MiB
@@ -18,7 +21,10 @@ func handleRequests() {
 	// ToDo: Groups ?
 
 	router.GET("/test", controllers.Test)
-	router.Static(environment.UploadEndpoint, os.Getenv("UPLOAD_TARGET"))
+	// files of the local blob store are served if their URLs are signed (S3 buckets are addressed by the clients)
+	if _, ok := environment.Env.Blobs.(*storage.LocalStore); ok {
+		router.GET(environment.UploadEndpoint+"/*key", controllers.DownloadBlob)
+	}
 
 	router.GET("/lookups", controllers.ListLookups)
 
@@ -36,13 +42,20 @@ func handleRequests() {
 	router.POST("/user/changePass", authentication.TokenAuthMiddleware(), controllers.ChangePassword)
 	router.POST("/user/verifyPass", authentication.TokenAuthMiddleware(), controllers.VerifyPassword)
 	router.POST("/user/uploadAvatar", authentication.TokenAuthMiddleware(), controllers.UploadProfilePicture)
+	router.GET("/user/export", authentication.TokenAuthMiddleware(), controllers.ExportUserData)
+	router.GET("/user/storage", authentication.TokenAuthMiddleware(), controllers.GetStorageUsage)
+	router.DELETE("/user", authentication.TokenAuthMiddleware(), controllers.DeleteAccount) // grace period (ACCOUNT_DELETION_DAYS)
+	router.POST("/user/restore", authentication.TokenAuthMiddleware(), controllers.RestoreAccount)
 
 	// nicht öffentlich, kein aufruf für andere als der aktuelle user vorgesehen (daher kein param)
 	router.POST("/user/blocked", authentication.TokenAuthMiddleware(), controllers.BlockUser)
 	router.DELETE("/user/blocked", authentication.TokenAuthMiddleware(), controllers.UnblockUser)
 
 	router.GET("/user/votes", authentication.TokenAuthMiddleware(), controllers.GetUserVotes) // nur noch für (eigenes) profil als übersicht
+	router.GET("/user/vote", authentication.TokenAuthMiddleware(), controllers.GetUserVote)   // ?pId=
 	// ToDo: /user/comments
+	router.GET("/user/notifications", authentication.TokenAuthMiddleware(), controllers.ListNotifications)
+	router.POST("/user/notifications/read", authentication.TokenAuthMiddleware(), controllers.MarkNotificationsRead) // ?id= (all if missing)
 
 	// öffentlich/einsehbar, aufruf auch für profile anderer user (daher mit param)
 	router.GET("/users/:id/friends", authentication.TokenAuthMiddleware(), controllers.GetFriends)
@@ -53,9 +66,27 @@ func handleRequests() {
 	router.POST("/users/:id/followings", authentication.TokenAuthMiddleware(), controllers.FollowUser) // ToDo: Vs Verb "follow"
 
 	router.GET("/users/:id/followers", authentication.TokenAuthMiddleware(), controllers.GetFollowers)
+	router.GET("/users/:id/comments", authentication.TokenAuthMiddleware(), controllers.ListProfileComments)
 
 	router.DELETE("/users/:id/uploads/:fid", authentication.TokenAuthMiddleware(), controllers.DeleteFile)
 
+	// user administration (admins only, checked by model)
+	router.GET("/admin/users", authentication.TokenAuthMiddleware(), controllers.SearchUsers)
+	router.PUT("/admin/users/:id/role", authentication.TokenAuthMiddleware(), controllers.SetUserRole)
+	router.POST("/admin/users/:id/suspension", authentication.TokenAuthMiddleware(), controllers.SuspendUser)
+	router.DELETE("/admin/users/:id/suspension", authentication.TokenAuthMiddleware(), controllers.UnsuspendUser)
+	router.POST("/admin/users/:id/passwordReset", authentication.TokenAuthMiddleware(), controllers.ResetUserPassword)
+	router.GET("/admin/audit", authentication.TokenAuthMiddleware(), controllers.ListAuditLog)
+	router.GET("/admin/votes/clusters", authentication.TokenAuthMiddleware(), controllers.ListVoteClusters)
+	router.GET("/admin/uploads/garbage", authentication.TokenAuthMiddleware(), controllers.GetGarbageReport) // dry run
+
+	// reporting content to the moderators (comments, replies, uploads, courses & users)
+	router.POST("/reports", authentication.TokenAuthMiddleware(), controllers.AddReport)
+
+	// moderation (admins only, checked by model)
+	router.GET("/moderation/queue", authentication.TokenAuthMiddleware(), controllers.GetModerationQueue)
+	router.POST("/moderation/:type/:id/:decision", authentication.TokenAuthMiddleware(), controllers.Moderate) // approve, reject, flag
+
 	// system tools
 	router.GET("/monitor/requests/count", authentication.TokenAuthMiddleware(), controllers.CountRequests)
 	router.GET("/monitor/requests/dump", authentication.TokenAuthMiddleware(), controllers.DumpRequests)
@@ -68,10 +99,25 @@ func handleRequests() {
 	router.POST("/vote", authentication.TokenAuthMiddleware(), controllers.CastVote)
 
 	// commenting
-	router.POST("/comment", authentication.TokenAuthMiddleware(), controllers.AddComment) // easier handling for client
+	router.POST("/comment", authentication.TokenAuthMiddleware(), controllers.AddComment)           // easier handling for client
+	router.PUT("/comments/:id", authentication.TokenAuthMiddleware(), controllers.UpdateComment)    // comments & replies
+	router.DELETE("/comments/:id", authentication.TokenAuthMiddleware(), controllers.DeleteComment) // comments & replies
+	router.POST("/comments/:id/pin", authentication.TokenAuthMiddleware(), controllers.PinComment)
+	router.DELETE("/comments/:id/pin", authentication.TokenAuthMiddleware(), controllers.UnpinComment)
+	router.GET("/comments/:id/replies", controllers.ListReplies)   // votes are merged for members
+	router.GET("/comments/:id/votes", controllers.GetCommentVotes) // comments & replies, user's vote for members
+	router.GET("/comments/:id/voters", authentication.TokenAuthMiddleware(), controllers.ListCommentVoters)
+	// profile owners may disable the comments of their profiles (course, user, upload)
+	router.POST("/profiles/:type/:id/comments/disabled", authentication.TokenAuthMiddleware(), controllers.DisableComments)
+	router.DELETE("/profiles/:type/:id/comments/disabled", authentication.TokenAuthMiddleware(), controllers.EnableComments)
 
 	// uploading
 	router.POST("/upload", authentication.TokenAuthMiddleware(), controllers.UploadFile)
+	// resumable uploads (tus-style: create, send chunks, query the offset, cancel)
+	router.POST("/upload/resumable", authentication.TokenAuthMiddleware(), controllers.CreateResumableUpload)
+	router.HEAD("/upload/resumable/:id", authentication.TokenAuthMiddleware(), controllers.GetResumableUpload)
+	router.PATCH("/upload/resumable/:id", authentication.TokenAuthMiddleware(), controllers.PatchResumableUpload)
+	router.DELETE("/upload/resumable/:id", authentication.TokenAuthMiddleware(), controllers.DeleteResumableUpload)
 
 	// course
 	// GET hat keinen BODY (Go/Gin & Postman unterstützen das zwar, Angular nicht) - deshalb Parameter
@@ -85,6 +131,11 @@ func handleRequests() {
 	// ToDO: Delete
 	// statistics
 	router.GET("/courses/public/:id/visits", controllers.GetCourseVisits) // visits since last 7 days "hot"
+	// voting - counts for everyone, voters and history for the creator
+	router.GET("/courses/public/:id/votes", controllers.GetVotesPublic)
+	router.GET("/courses/member/:id/votes", authentication.TokenAuthMiddleware(), controllers.GetVotesMember)
+	router.GET("/courses/member/:id/voters", authentication.TokenAuthMiddleware(), controllers.ListCourseVoters)
+	router.GET("/courses/member/:id/votes/history", authentication.TokenAuthMiddleware(), controllers.GetCourseVoteHistory)
 	// commenting - generic handlers for all profile types
 	router.GET("/courses/public/:id/comments", controllers.ListCommentsPublic)
 	router.GET("/courses/member/:id/comments", authentication.TokenAuthMiddleware(), controllers.ListCommentsMember)
@@ -92,6 +143,10 @@ func handleRequests() {
 	router.GET("/courses/public/:id/uploads", controllers.DownloadFilesPublic)
 	router.GET("/courses/member/:id/uploads", authentication.TokenAuthMiddleware(), controllers.DownloadFilesMember)
 	router.DELETE("/courses/member/:id/uploads/:fid", authentication.TokenAuthMiddleware(), controllers.DeleteFile)
+	router.PUT("/courses/:id/uploads", authentication.TokenAuthMiddleware(), controllers.SortUploads)               // gallery order (owner)
+	router.PUT("/courses/:id/uploads/:fid", authentication.TokenAuthMiddleware(), controllers.UpdateUpload)         // description (uploader)
+	router.PUT("/courses/:id/uploads/:fid/cover", authentication.TokenAuthMiddleware(), controllers.SetUploadCover) // (owner)
+	router.GET("/uploads/:fid/comments", controllers.ListUploadComments)                                            // visibility of the file's profile, votes are merged for members
 
 	// logics
 	router.POST("/course/exists", authentication.TokenAuthMiddleware(), controllers.ExistsForzaShare) // protected to prevent sniffs ;-)
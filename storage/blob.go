@@ -0,0 +1,65 @@
+package storage
+
+// storage of uploaded files (blobs), independent of where they are kept
+// files are addressed by keys; a slash separates prefixes (eg. "staged/course_uuid.png")
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNotFound is returned if there's no blob with the given key
+var ErrNotFound = errors.New("blob not found")
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// BlobStore keeps the uploaded files
+type BlobStore interface {
+	// Put saves the content under the key (replaces an existing blob)
+	Put(key string, content io.Reader, size int64, contentType string) error
+	// Get opens a blob for reading, the caller must close it
+	Get(key string) (io.ReadCloser, *BlobInfo, error)
+	// Delete removes a blob, missing ones are no error
+	Delete(key string) error
+	// Stat returns the description of a blob
+	Stat(key string) (*BlobInfo, error)
+	// Move renames a blob (eg. when a staged file is approved)
+	Move(srcKey string, dstKey string) error
+	// SignedURL returns the address clients download the blob from, it's valid until the given time
+	// (the same key and expiration result in the same URL, hence they're cached by the browsers)
+	SignedURL(key string, expires time.Time) string
+	// List returns the blobs whose keys start with the prefix (garbage collection)
+	List(prefix string) ([]BlobInfo, error)
+}
+
+// NewBlobStore returns the driver configured by STORAGE_DRIVER (local by default)
+// the URLs of the local store are signed by UPLOAD_URL_SECRET (ACCESS_SECRET if missing)
+func NewBlobStore(baseURL string) (BlobStore, error) {
+
+	switch os.Getenv("STORAGE_DRIVER") {
+	case "", "local":
+		secret := os.Getenv("UPLOAD_URL_SECRET")
+		if secret == "" {
+			secret = os.Getenv("ACCESS_SECRET")
+		}
+		return NewLocalStore(os.Getenv("UPLOAD_TARGET"), baseURL, secret)
+	case "s3":
+		return NewS3Store(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+	default:
+		return nil, errors.New("unknown storage driver " + os.Getenv("STORAGE_DRIVER"))
+	}
+}
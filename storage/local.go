@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalStore keeps the blobs in a directory of the server's file system
+// the prefixes of the keys are sub-directories; files are served by a handler which checks
+// the signatures of the URLs (Verify), hence unknown URLs can't be derived from known ones
+type LocalStore struct {
+	Root    string // directory
+	BaseURL string // URL of the handler
+	secret  []byte // signs the URLs
+}
+
+// NewLocalStore returns a driver for the given directory, which is created if necessary
+func NewLocalStore(root string, baseURL string, secret string) (*LocalStore, error) {
+
+	if root == "" {
+		return nil, errors.New("storage directory not set")
+	}
+	if secret == "" {
+		return nil, errors.New("secret of the storage URLs not set")
+	}
+
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalStore{Root: root, BaseURL: strings.TrimRight(baseURL, "/"), secret: []byte(secret)}, nil
+}
+
+// Put saves the content to a temporary file first, so readers never see partial files
+func (s *LocalStore) Put(key string, content io.Reader, size int64, contentType string) error {
+
+	fullPath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(fullPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(fullPath), ".upload-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tmp, content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fullPath)
+}
+
+// Get opens a blob for reading
+func (s *LocalStore) Get(key string) (io.ReadCloser, *BlobInfo, error) {
+
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fullPath, _ := s.path(key)
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return f, info, nil
+}
+
+// Delete removes a blob
+func (s *LocalStore) Delete(key string) error {
+
+	fullPath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(fullPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Stat returns the description of a blob, the content type is derived from its extension
+func (s *LocalStore) Stat(key string) (*BlobInfo, error) {
+
+	fullPath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &BlobInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(fullPath)),
+		LastModified: fi.ModTime(),
+	}, nil
+}
+
+// Move renames a blob
+func (s *LocalStore) Move(srcKey string, dstKey string) error {
+
+	src, err := s.path(srcKey)
+	if err != nil {
+		return err
+	}
+
+	dst, err := s.path(dstKey)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(src, dst)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// SignedURL returns an address which expires
+func (s *LocalStore) SignedURL(key string, expires time.Time) string {
+	exp := expires.Unix()
+	return s.BaseURL + "/" + key + "?exp=" + strconv.FormatInt(exp, 10) + "&sig=" + s.signature(key, exp)
+}
+
+// Verify checks the signature and expiration (query parameters) of a requested blob
+// every URL expires, so visibility changes of the profiles apply to the URLs issued before
+func (s *LocalStore) Verify(key string, exp string, sig string) bool {
+
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	return hmac.Equal([]byte(sig), []byte(s.signature(key, expires)))
+}
+
+// List walks the prefix's directory, temporary files of running uploads are skipped
+func (s *LocalStore) List(prefix string) ([]BlobInfo, error) {
+
+	// the prefix may end within a file name, hence its directory is walked
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+	dir = filepath.Join(s.Root, filepath.FromSlash(path.Clean("/"+dir)))
+
+	var blobs []BlobInfo
+	err := filepath.Walk(dir, func(fullPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.Root, fullPath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		blobs = append(blobs, BlobInfo{
+			Key:          key,
+			Size:         fi.Size(),
+			ContentType:  mime.TypeByExtension(filepath.Ext(fullPath)),
+			LastModified: fi.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return blobs, nil
+}
+
+// HMAC of the key and the expiration
+func (s *LocalStore) signature(key string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// maps a key to a path within the root directory
+func (s *LocalStore) path(key string) (string, error) {
+
+	clean := path.Clean("/" + key)
+	if clean == "/" || strings.HasSuffix(key, "/") {
+		return "", errors.New("invalid blob key " + key)
+	}
+
+	return filepath.Join(s.Root, filepath.FromSlash(clean)), nil
+}
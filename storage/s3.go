@@ -0,0 +1,435 @@
+package storage
+
+// driver for S3-compatible object stores (AWS S3, MinIO...)
+// requests are signed by AWS signature version 4, path-style addressing is used (endpoint/bucket/key)
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config contains the connection settings of a bucket
+type S3Config struct {
+	Endpoint  string // eg. https://s3.eu-central-1.amazonaws.com or http://localhost:9000 (MinIO)
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3Store keeps the blobs in a bucket
+type S3Store struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// payload hash of requests whose body is not signed (streamed uploads)
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// maximum validity of presigned URLs (signature version 4)
+const maxPresign = 7 * 24 * time.Hour
+
+// NewS3Store returns a driver for the configured bucket
+func NewS3Store(config S3Config) (*S3Store, error) {
+
+	if config.Endpoint == "" || config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("incomplete S3 configuration")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1" // MinIO's default
+	}
+
+	endpoint, err := url.Parse(strings.TrimRight(config.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Store{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// Put uploads the content (the size must be known)
+func (s *S3Store) Put(key string, content io.Reader, size int64, contentType string) error {
+
+	req, err := s.request(http.MethodPut, key, content)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := s.do(req, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// Get downloads a blob, the caller must close it
+func (s *S3Store) Get(key string) (io.ReadCloser, *BlobInfo, error) {
+
+	req, err := s.request(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := s.do(req, emptyHash())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return res.Body, blobInfo(key, res), nil
+}
+
+// Delete removes a blob (S3 doesn't report missing objects)
+func (s *S3Store) Delete(key string) error {
+
+	req, err := s.request(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(req, emptyHash())
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if res != nil {
+		res.Body.Close()
+	}
+
+	return nil
+}
+
+// Stat reads the header of a blob
+func (s *S3Store) Stat(key string) (*BlobInfo, error) {
+
+	req, err := s.request(http.MethodHead, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(req, emptyHash())
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	return blobInfo(key, res), nil
+}
+
+// Move copies the blob on the server and deletes the source (there's no rename in S3)
+func (s *S3Store) Move(srcKey string, dstKey string) error {
+
+	req, err := s.request(http.MethodPut, dstKey, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-amz-copy-source", "/"+s.config.Bucket+"/"+uriEncode(srcKey, false))
+
+	res, err := s.do(req, emptyHash())
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return s.Delete(srcKey)
+}
+
+// SignedURL returns a presigned address of the bucket (the bucket stays private)
+// the signing time is derived from the expiration, so the URL is stable until the expiration changes
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+func (s *S3Store) SignedURL(key string, expires time.Time) string {
+
+	req, err := s.request(http.MethodGet, key, nil)
+	if err != nil {
+		return ""
+	}
+
+	// URLs are valid for the maximum of signature version 4 (7 days) before they expire
+	signed := expires.UTC().Truncate(time.Second).Add(-maxPresign)
+	if now := time.Now().UTC().Truncate(time.Second); signed.After(now) {
+		signed = now // expirations beyond the maximum are shortened
+	}
+
+	return s.presign(req.URL, signed, int64(maxPresign/time.Second))
+}
+
+// List reads the bucket's index page by page (ListObjectsV2, 1000 keys per page)
+func (s *S3Store) List(prefix string) ([]BlobInfo, error) {
+
+	var blobs []BlobInfo
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		u := *s.endpoint
+		u.Path = s.endpoint.Path + "/" + s.config.Bucket
+		u.RawPath = s.endpoint.Path + "/" + uriEncode(s.config.Bucket, false)
+		u.RawQuery = canonicalQuery(query)
+
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := s.do(req, emptyHash())
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range page.Contents {
+			blobs = append(blobs, BlobInfo{Key: c.Key, Size: c.Size, LastModified: c.LastModified})
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return blobs, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// internal helpers
+
+func (s *S3Store) request(method string, key string, body io.Reader) (*http.Request, error) {
+
+	if key == "" || strings.HasPrefix(key, "/") {
+		return nil, errors.New("invalid blob key " + key)
+	}
+
+	u := *s.endpoint
+	u.Path = s.endpoint.Path + "/" + s.config.Bucket + "/" + key
+	u.RawPath = s.endpoint.Path + "/" + uriEncode(s.config.Bucket, false) + "/" + uriEncode(key, false)
+
+	return http.NewRequest(method, u.String(), body)
+}
+
+// signs and sends a request, responses other than 2xx are returned as errors
+func (s *S3Store) do(req *http.Request, payloadHash string) (*http.Response, error) {
+
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		// S3 sends an XML document describing the error (HEAD requests don't)
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s %s", req.Method, req.URL.Path, res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return res, nil
+}
+
+// adds the authorization header (AWS signature version 4)
+func (s *S3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	scope := day + "/" + s.config.Region + "/s3/aws4_request"
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	// 1. canonical request
+	canonical, signedHeaders := canonicalRequest(req, payloadHash)
+
+	// 2. string to sign
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hashHex([]byte(canonical)),
+	}, "\n")
+
+	// 3. signature
+	signature := s.signature(day, stringToSign)
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.config.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// the canonical form of a request and the names of its signed headers (host, content type and the x-amz-* headers)
+func canonicalRequest(req *http.Request, payloadHash string) (string, string) {
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	return strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n"), signedHeaders
+}
+
+// adds the authentication to the query of a GET request, only the host header is signed
+func (s *S3Store) presign(u *url.URL, now time.Time, expires int64) string {
+
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	scope := day + "/" + s.config.Region + "/s3/aws4_request"
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.config.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.FormatInt(expires, 10))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hashHex([]byte(canonical)),
+	}, "\n")
+
+	signed := *u
+	signed.RawQuery = canonicalQuery(query) + "&X-Amz-Signature=" + s.signature(day, stringToSign)
+
+	return signed.String()
+}
+
+// the signing key is derived from the secret and the scope
+func (s *S3Store) signature(day string, stringToSign string) string {
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), day)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func blobInfo(key string, res *http.Response) *BlobInfo {
+	size, _ := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	modified, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return &BlobInfo{
+		Key:          key,
+		Size:         size,
+		ContentType:  res.Header.Get("Content-Type"),
+		LastModified: modified,
+	}
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range values[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// URI encoding as required by S3: everything but the unreserved characters (and slashes of paths)
+func uriEncode(value string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func emptyHash() string {
+	return hashHex(nil)
+}
@@ -0,0 +1,270 @@
+package video
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// MP4 files (ISO base media file format) consist of nested boxes: size (incl. header), type, payload
+// https://developer.apple.com/library/archive/documentation/QuickTime/QTFF/QTFFChap2/qtff2.html
+
+// image formats share the container (HEIF, AVIF)
+var imageBrands = map[string]bool{
+	"heic": true, "heix": true, "mif1": true, "msf1": true, "avif": true, "avis": true,
+}
+
+func isMP4(head []byte) bool {
+	if len(head) < 12 || string(head[4:8]) != "ftyp" {
+		return false
+	}
+	brand := string(head[8:12])
+	return !imageBrands[brand] && brand != "qt  " // QuickTime movies aren't played by all browsers
+}
+
+func probeMP4(r io.ReaderAt, size int64) (*Info, error) {
+
+	moov := readBox(r, size, "moov")
+	if moov == nil {
+		return nil, ErrUnsupported
+	}
+
+	var info Info
+
+	// duration of the movie (fragmented files have it in the extends header)
+	timescale, duration := mvhdDuration(findBox(moov, "mvhd"))
+	if duration == 0 {
+		duration = fullBoxValue(findBox(moov, "mvex", "mehd"), 0)
+	}
+	if timescale > 0 {
+		info.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+	}
+
+	// first video track
+	var stbl []byte
+	for _, trak := range boxes(moov) {
+		if trak.typ != "trak" {
+			continue
+		}
+		hdlr := findBox(trak.data, "mdia", "hdlr")
+		if len(hdlr) < 12 || string(hdlr[8:12]) != "vide" {
+			continue
+		}
+
+		// dimensions are the last fields of the track header (fixed-point 16.16)
+		tkhd := findBox(trak.data, "tkhd")
+		if len(tkhd) >= 84 {
+			info.Width = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16)
+			info.Height = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16)
+		}
+
+		stbl = findBox(trak.data, "mdia", "minf", "stbl")
+		stsd := findBox(stbl, "stsd")
+		if len(stsd) >= 16 {
+			info.Codec = mp4Codec(string(stsd[12:16]))
+		}
+		break
+	}
+	if stbl == nil {
+		return nil, ErrUnsupported
+	}
+
+	// cover art (iTunes metadata), the first frame of Motion JPEG clips otherwise
+	info.Poster = mp4Cover(moov)
+	if info.Poster == nil && info.Codec == CodecMJPEG {
+		info.Poster = mp4FirstSample(r, size, stbl)
+	}
+
+	return &info, nil
+}
+
+// internal helpers
+
+// reads the payload of a top-level box, the others (eg. the media data) are skipped
+func readBox(r io.ReaderAt, fileSize int64, typ string) []byte {
+
+	var offset int64
+	for offset+8 <= fileSize {
+		header := readAt(r, offset, minSize(fileSize-offset, 16))
+		if header == nil {
+			return nil
+		}
+
+		size := uint64(binary.BigEndian.Uint32(header))
+		headerSize := uint64(8)
+		switch size {
+		case 0: // up to the end of the file
+			size = uint64(fileSize - offset)
+		case 1: // 64-bit size
+			if len(header) < 16 {
+				return nil
+			}
+			size = binary.BigEndian.Uint64(header[8:])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(fileSize-offset) {
+			return nil
+		}
+
+		if string(header[4:8]) == typ {
+			return readAt(r, offset+int64(headerSize), int64(size-headerSize))
+		}
+		offset += int64(size)
+	}
+
+	return nil
+}
+
+type box struct {
+	typ  string
+	data []byte // payload
+}
+
+// splits the payload of a box into its children, truncated boxes are dropped
+func boxes(data []byte) []box {
+
+	var list []box
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		header := uint64(8)
+		switch size {
+		case 0: // up to the end of the file
+			size = uint64(len(data))
+		case 1: // 64-bit size
+			if len(data) < 16 {
+				return list
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return list
+		}
+
+		list = append(list, box{typ: string(data[4:8]), data: data[header:size]})
+		data = data[size:]
+	}
+
+	return list
+}
+
+// returns the payload of the first box along the path, nil if it's missing
+func findBox(data []byte, path ...string) []byte {
+	for _, typ := range path {
+		var found []byte
+		for _, b := range boxes(data) {
+			if b.typ == typ {
+				found = b.data
+				break
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		data = found
+	}
+	return data
+}
+
+// movie header: version, flags, creation & modification time, timescale, duration (32/64 bits by version)
+func mvhdDuration(mvhd []byte) (uint32, uint64) {
+	if len(mvhd) < 20 {
+		return 0, 0
+	}
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return 0, 0
+		}
+		return binary.BigEndian.Uint32(mvhd[20:]), binary.BigEndian.Uint64(mvhd[24:])
+	}
+	return binary.BigEndian.Uint32(mvhd[12:]), uint64(binary.BigEndian.Uint32(mvhd[16:]))
+}
+
+// the value after version and flags (32/64 bits by version)
+func fullBoxValue(data []byte, offset int) uint64 {
+	if len(data) < 4+offset+4 {
+		return 0
+	}
+	if data[0] == 1 {
+		if len(data) < 4+offset+8 {
+			return 0
+		}
+		return binary.BigEndian.Uint64(data[4+offset:])
+	}
+	return uint64(binary.BigEndian.Uint32(data[4+offset:]))
+}
+
+// sample entries are named by fourcc
+func mp4Codec(fourcc string) string {
+	switch fourcc {
+	case "avc1", "avc3":
+		return CodecH264
+	case "hvc1", "hev1":
+		return CodecHEVC
+	case "vp08":
+		return CodecVP8
+	case "vp09":
+		return CodecVP9
+	case "av01":
+		return CodecAV1
+	case "mp4v":
+		return CodecMPEG4
+	case "jpeg", "mjpa", "mjpb":
+		return CodecMJPEG
+	}
+	return fourcc
+}
+
+// moov/udta/meta/ilst/covr/data: type indicator, locale, image
+func mp4Cover(moov []byte) []byte {
+
+	for _, path := range [][]string{{"udta", "meta"}, {"meta"}} {
+		meta := findBox(moov, path...)
+		if len(meta) < 4 {
+			continue
+		}
+		// meta is a full box (version & flags) in MP4, but not in QuickTime files
+		if binary.BigEndian.Uint32(meta) == 0 {
+			meta = meta[4:]
+		}
+
+		data := findBox(meta, "ilst", "covr", "data")
+		if len(data) > 8 {
+			return data[8:]
+		}
+	}
+
+	return nil
+}
+
+// the first sample starts at the first chunk's offset
+func mp4FirstSample(r io.ReaderAt, fileSize int64, stbl []byte) []byte {
+
+	var offset uint64
+	if stco := findBox(stbl, "stco"); len(stco) >= 12 && binary.BigEndian.Uint32(stco[4:]) > 0 {
+		offset = uint64(binary.BigEndian.Uint32(stco[8:]))
+	} else if co64 := findBox(stbl, "co64"); len(co64) >= 16 && binary.BigEndian.Uint32(co64[4:]) > 0 {
+		offset = binary.BigEndian.Uint64(co64[8:])
+	} else {
+		return nil
+	}
+
+	// samples have the same size or a table of sizes follows
+	stsz := findBox(stbl, "stsz")
+	if len(stsz) < 12 || binary.BigEndian.Uint32(stsz[8:]) == 0 {
+		return nil
+	}
+	size := uint64(binary.BigEndian.Uint32(stsz[4:]))
+	if size == 0 {
+		if len(stsz) < 16 {
+			return nil
+		}
+		size = uint64(binary.BigEndian.Uint32(stsz[12:]))
+	}
+
+	// 64-bit offsets may overflow the sum
+	if offset > uint64(fileSize) || size > uint64(fileSize)-offset {
+		return nil
+	}
+	return readAt(r, int64(offset), int64(size))
+}
@@ -0,0 +1,128 @@
+package video
+
+// probing of uploaded video clips (race clips)
+// the containers (MP4, WebM) are parsed for the duration, resolution and codec of the first video track.
+// the streams aren't decoded, hence clips are stored as uploaded. a poster frame is only available if
+// the container embeds a cover image or the key frames are images themselves (Motion JPEG).
+// clips are read in parts, only the metadata and the poster are loaded into memory.
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+// supported containers
+const (
+	FormatMP4  = "mp4"
+	FormatWebM = "webm"
+)
+
+// codecs of the video tracks (others are reported by their fourcc or codec ID)
+const (
+	CodecH264  = "h264"
+	CodecHEVC  = "hevc"
+	CodecVP8   = "vp8"
+	CodecVP9   = "vp9"
+	CodecAV1   = "av1"
+	CodecMPEG4 = "mpeg4"
+	CodecMJPEG = "mjpeg"
+)
+
+// the container is detected by the first bytes
+const headSize = 4096
+
+// larger metadata (eg. attachments) and posters are skipped
+const maxMetadata = 32 << 20
+
+// errors
+var (
+	ErrUnsupported = errors.New("unsupported video format")
+)
+
+// Info describes a video clip
+type Info struct {
+	Format      string
+	Codec       string
+	Width       int
+	Height      int
+	Duration    time.Duration
+	ContentType string
+	Ext         string // file extension incl. dot
+	Poster      []byte // embedded cover or key frame (JPEG/PNG), nil if there's none
+}
+
+// Sniff detects the container by the first bytes of a file
+func Sniff(head []byte) (string, bool) {
+	switch {
+	case isMP4(head):
+		return FormatMP4, true
+	case isWebM(head):
+		return FormatWebM, true
+	}
+	return "", false
+}
+
+// Probe parses the container of a clip of the given size, files without video track are refused
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+
+	head := readAt(r, 0, minSize(size, headSize))
+	format, ok := Sniff(head)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	var info *Info
+	var err error
+	switch format {
+	case FormatMP4:
+		info, err = probeMP4(r, size)
+		if err == nil {
+			info.ContentType, info.Ext = "video/mp4", ".mp4"
+		}
+	case FormatWebM:
+		info, err = probeWebM(r, size)
+		if err == nil {
+			info.ContentType, info.Ext = "video/webm", ".webm"
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Format = format
+
+	// only images are accepted as posters
+	if !isImage(info.Poster) {
+		info.Poster = nil
+	}
+
+	return info, nil
+}
+
+// internal helpers
+
+// reads a part of the clip, nil if it's truncated
+func readAt(r io.ReaderAt, offset int64, length int64) []byte {
+	if offset < 0 || length < 0 || length > maxMetadata {
+		return nil
+	}
+	data := make([]byte, length)
+	n, _ := r.ReadAt(data, offset) // io.EOF is returned with the last byte by some readers
+	if int64(n) < length {
+		return nil
+	}
+	return data
+}
+
+func minSize(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func isImage(data []byte) bool {
+	return (len(data) >= 3 && data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF) ||
+		(len(data) >= 8 && bytes.Equal(data[:8], []byte("\x89PNG\r\n\x1a\n")))
+}
@@ -0,0 +1,350 @@
+package video
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"math/bits"
+	"strings"
+	"time"
+)
+
+// WebM files (Matroska) consist of EBML elements: ID, size (both variable-length integers), payload
+// https://www.matroska.org/technical/elements.html
+
+// element IDs (incl. the length marker)
+const (
+	idEBML          = 0x1A45DFA3
+	idDocType       = 0x4282
+	idSegment       = 0x18538067
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+	idDuration      = 0x4489
+	idTracks        = 0x1654AE6B
+	idTrackEntry    = 0xAE
+	idTrackNumber   = 0xD7
+	idTrackType     = 0x83
+	idCodecID       = 0x86
+	idVideo         = 0xE0
+	idPixelWidth    = 0xB0
+	idPixelHeight   = 0xBA
+	idAttachments   = 0x1941A469
+	idAttachedFile  = 0x61A7
+	idFileName      = 0x466E
+	idFileMimeType  = 0x4660
+	idFileData      = 0x465C
+	idCluster       = 0x1F43B675
+	idTimecode      = 0xE7
+	idBlockGroup    = 0xA0
+	idBlock         = 0xA1
+	idSimpleBlock   = 0xA3
+)
+
+// track type of video tracks
+const trackTypeVideo = 1
+
+func isWebM(head []byte) bool {
+
+	id, n := readID(head)
+	if id != idEBML {
+		return false
+	}
+	size, m := readSize(head[n:])
+	if m == 0 || size < 0 || int64(len(head)-n-m) < size {
+		return false
+	}
+
+	docType := ""
+	elements(head[n+m:n+m+int(size)], func(id uint32, payload []byte) {
+		if id == idDocType {
+			docType = strings.TrimRight(string(payload), "\x00")
+		}
+	})
+	return docType == "webm" // Matroska files aren't played by browsers
+}
+
+// state of the parser
+type webm struct {
+	info       Info
+	scale      uint64  // nanoseconds per timecode unit
+	duration   float64 // in timecode units (optional)
+	track      uint64  // number of the video track
+	cluster    uint64  // timecode of the current cluster
+	lastBlock  int64   // timecode of the last block (live recordings don't have a duration)
+	attachment []byte  // cover image
+}
+
+func probeWebM(r io.ReaderAt, fileSize int64) (*Info, error) {
+
+	p := webm{scale: 1000000}
+
+	// containers are entered instead of being skipped, their children's IDs are unique.
+	// hence elements of unknown size (live recordings) are no problem.
+	// the EBML header is checked by isWebM and skipped like an unknown element
+	var offset int64
+	for offset < fileSize {
+		// ID (1-4 bytes) and size (1-8 bytes)
+		header := readAt(r, offset, minSize(fileSize-offset, 12))
+		if header == nil {
+			break
+		}
+		id, n := readID(header)
+		if n == 0 {
+			break
+		}
+		size, m := readSize(header[n:])
+		if m == 0 {
+			break
+		}
+		offset += int64(n + m)
+
+		switch id {
+		case idSegment, idCluster, idBlockGroup:
+			continue
+		}
+
+		// truncated or unknown size (only allowed for containers)
+		if size < 0 || size > fileSize-offset {
+			break
+		}
+		if payload := p.read(r, offset, id, size); payload != nil {
+			p.element(id, payload)
+		}
+		offset += size
+	}
+
+	if p.track == 0 {
+		return nil, ErrUnsupported
+	}
+
+	if p.duration > 0 {
+		p.info.Duration = time.Duration(p.duration * float64(p.scale))
+	} else if p.lastBlock > 0 {
+		p.info.Duration = time.Duration(uint64(p.lastBlock) * p.scale)
+	}
+
+	// an attached cover is preferred to a key frame
+	if p.attachment != nil {
+		p.info.Poster = p.attachment
+	}
+
+	return &p.info, nil
+}
+
+// reads the payload of the elements used by the parser, the media data of the blocks is only read for the poster
+func (p *webm) read(r io.ReaderAt, offset int64, id uint32, size int64) []byte {
+
+	switch id {
+	case idInfo, idTracks, idAttachments, idTimecode:
+		return readAt(r, offset, size)
+	case idSimpleBlock, idBlock:
+		if p.info.Codec == CodecMJPEG && p.info.Poster == nil {
+			if payload := readAt(r, offset, size); payload != nil {
+				return payload
+			}
+		}
+		// track number (1-8 bytes), timecode and flags
+		return readAt(r, offset, minSize(size, 11))
+	}
+
+	return nil
+}
+
+func (p *webm) element(id uint32, payload []byte) {
+
+	switch id {
+	case idInfo:
+		elements(payload, func(id uint32, payload []byte) {
+			switch id {
+			case idTimecodeScale:
+				p.scale = readUint(payload)
+			case idDuration:
+				p.duration = readFloat(payload)
+			}
+		})
+	case idTracks:
+		elements(payload, func(id uint32, payload []byte) {
+			if id == idTrackEntry && p.track == 0 {
+				p.trackEntry(payload)
+			}
+		})
+	case idAttachments:
+		elements(payload, func(id uint32, payload []byte) {
+			if id == idAttachedFile {
+				p.attachedFile(payload)
+			}
+		})
+	case idTimecode:
+		p.cluster = readUint(payload)
+	case idSimpleBlock, idBlock:
+		p.block(payload)
+	}
+}
+
+func (p *webm) trackEntry(payload []byte) {
+
+	var number, trackType uint64
+	var codec string
+	var width, height uint64
+	elements(payload, func(id uint32, payload []byte) {
+		switch id {
+		case idTrackNumber:
+			number = readUint(payload)
+		case idTrackType:
+			trackType = readUint(payload)
+		case idCodecID:
+			codec = strings.TrimRight(string(payload), "\x00")
+		case idVideo:
+			elements(payload, func(id uint32, payload []byte) {
+				switch id {
+				case idPixelWidth:
+					width = readUint(payload)
+				case idPixelHeight:
+					height = readUint(payload)
+				}
+			})
+		}
+	})
+
+	if trackType != trackTypeVideo || number == 0 {
+		return
+	}
+
+	p.track = number
+	p.info.Codec = webmCodec(codec)
+	p.info.Width, p.info.Height = int(width), int(height)
+}
+
+// the first attached image, preferably named "cover"
+func (p *webm) attachedFile(payload []byte) {
+
+	var name, mimeType string
+	var data []byte
+	elements(payload, func(id uint32, payload []byte) {
+		switch id {
+		case idFileName:
+			name = strings.ToLower(string(payload))
+		case idFileMimeType:
+			mimeType = string(payload)
+		case idFileData:
+			data = payload
+		}
+	})
+
+	if !strings.HasPrefix(mimeType, "image/") || !isImage(data) {
+		return
+	}
+	if p.attachment == nil || strings.HasPrefix(name, "cover") {
+		p.attachment = data
+	}
+}
+
+// block header: track number, timecode (relative to the cluster), flags
+func (p *webm) block(payload []byte) {
+
+	track, n := readSize(payload)
+	if n == 0 || len(payload) < n+3 {
+		return
+	}
+
+	timecode := int64(p.cluster) + int64(int16(binary.BigEndian.Uint16(payload[n:])))
+	if timecode > p.lastBlock {
+		p.lastBlock = timecode
+	}
+
+	// the first frame of Motion JPEG clips (without lacing)
+	flags := payload[n+2]
+	if uint64(track) == p.track && p.info.Codec == CodecMJPEG && p.info.Poster == nil && flags&0x06 == 0 {
+		p.info.Poster = payload[n+3:]
+	}
+}
+
+func webmCodec(codecID string) string {
+	switch codecID {
+	case "V_VP8":
+		return CodecVP8
+	case "V_VP9":
+		return CodecVP9
+	case "V_AV1":
+		return CodecAV1
+	case "V_MPEG4/ISO/AVC":
+		return CodecH264
+	case "V_MPEGH/ISO/HEVC":
+		return CodecHEVC
+	case "V_MJPEG":
+		return CodecMJPEG
+	}
+	return codecID
+}
+
+// calls fn for the children of an element, stops at elements of unknown size
+func elements(data []byte, fn func(id uint32, payload []byte)) {
+	for len(data) > 0 {
+		id, n := readID(data)
+		if n == 0 {
+			return
+		}
+		size, m := readSize(data[n:])
+		if m == 0 || size < 0 || size > int64(len(data)-n-m) {
+			return
+		}
+		fn(id, data[n+m:n+m+int(size)])
+		data = data[n+m+int(size):]
+	}
+}
+
+// IDs keep their length marker (1-4 bytes)
+func readID(data []byte) (uint32, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	length := bits.LeadingZeros8(data[0]) + 1
+	if length > 4 || len(data) < length {
+		return 0, 0
+	}
+	var id uint32
+	for _, b := range data[:length] {
+		id = id<<8 | uint32(b)
+	}
+	return id, length
+}
+
+// sizes lose their length marker (1-8 bytes), unknown sizes (all bits set) are returned as -1
+func readSize(data []byte) (int64, int) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0
+	}
+	length := bits.LeadingZeros8(data[0]) + 1
+	if len(data) < length {
+		return 0, 0
+	}
+	value := uint64(data[0] & (0xFF >> uint(length)))
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+	}
+	if value == 1<<uint(7*length)-1 {
+		return -1, length
+	}
+	return int64(value), length
+}
+
+func readUint(data []byte) uint64 {
+	var value uint64
+	for i, b := range data {
+		if i == 8 {
+			break
+		}
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func readFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}